@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/utils"
+)
+
+// buckets spreads the balance of a hot account over buckets, so its
+// concurrent credits stop waiting on each other. The count can be changed
+// while the account is in use, 0 turns it back into a plain account:
+//
+//	go run ./cmd/buckets -username merchant -buckets 16
+//	go run ./cmd/buckets -username merchant -buckets 0
+func main() {
+	configPath := flag.String("config", "setting/setting.yaml", "path to the config file")
+	username := flag.String("username", "", "account to change the buckets of")
+	count := flag.Int("buckets", -1, fmt.Sprintf("bucket count, 0 to %d", account.MaxBuckets))
+	flag.Parse()
+
+	if *username == "" || *count < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.MustLoadConfig(*configPath)
+	db, err := sqlx.Connect("postgres", utils.BuildDatasourceName(utils.DataSource{
+		User:     cfg.DB.User,
+		Password: cfg.DB.Password,
+		Host:     cfg.DB.Host,
+		Database: cfg.DB.DBName,
+	}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	txPolicy, err := account.NewTxPolicy(cfg.DB.Transactions)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid transaction config:", err)
+		os.Exit(1)
+	}
+
+	err = account.SetBuckets(context.Background(), db, txPolicy, *username, *count)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s now has %d buckets\n", *username, *count)
+}
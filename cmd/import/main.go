@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/importer"
+	"github.com/yeyee2901/test/internal/utils"
+)
+
+// import submits a CSV of adjustments, executed asynchronously by the
+// import worker of the server, and fetches its result:
+//
+//	go run ./cmd/import -file adjustments.csv -wait
+//	go run ./cmd/import -result <job id> -out result.csv
+func main() {
+	configPath := flag.String("config", "setting/setting.yaml", "path to the config file")
+	file := flag.String("file", "", "CSV file to import (username,amount,type,reference)")
+	wait := flag.Bool("wait", false, "with -file, wait for the import to complete")
+	result := flag.String("result", "", "job ID to fetch the result of")
+	out := flag.String("out", "", "with -result, file to write the result to, defaults to stdout")
+	flag.Parse()
+
+	if *file == "" && *result == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.MustLoadConfig(*configPath)
+	db, err := sqlx.Connect("postgres", utils.BuildDatasourceName(utils.DataSource{
+		User:     cfg.DB.User,
+		Password: cfg.DB.Password,
+		Host:     cfg.DB.Host,
+		Database: cfg.DB.DBName,
+	}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	manager := importer.NewManager(db, cfg.Import.MaxRows)
+
+	if *file != "" {
+		os.Exit(submit(manager, *file, *wait))
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err = manager.WriteResult(*result, w)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func submit(manager importer.Manager, path string, wait bool) int {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	job, duplicate, err := manager.Create(filepath.Base(path), content)
+	if err != nil {
+		var validationErr *importer.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, rowErr := range validationErr.Errors {
+				fmt.Fprintln(os.Stderr, rowErr)
+			}
+			fmt.Fprintf(os.Stderr, "%s rejected: %d invalid lines\n", path, len(validationErr.Errors))
+			return 3
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if duplicate {
+		fmt.Printf("%s was already uploaded as job %s (%s)\n", path, job.ID, job.Status)
+	} else {
+		fmt.Printf("job %s: %d rows pending\n", job.ID, job.TotalRows)
+	}
+
+	for wait && job.Status != importer.JobStatusCompleted {
+		time.Sleep(2 * time.Second)
+
+		job, err = manager.Get(job.ID)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("job %s: %s, %d/%d rows\n", job.ID, job.Status, job.ProcessedRows, job.TotalRows)
+	}
+
+	if job.Status == importer.JobStatusCompleted {
+		fmt.Printf("job %s: %d succeeded, %d failed\n", job.ID, job.Succeeded, job.Failed)
+	}
+
+	return 0
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/interest"
+	"github.com/yeyee2901/test/internal/utils"
+)
+
+// interest runs the interest accrual by hand, mainly to backfill the days
+// missed by the job:
+//
+//	go run ./cmd/interest -from 2024-01-01 -to 2024-01-31
+//	go run ./cmd/interest -payout 2024-01
+func main() {
+	configPath := flag.String("config", "setting/setting.yaml", "path to the config file")
+	from := flag.String("from", "", "first day to accrue (YYYY-MM-DD)")
+	to := flag.String("to", "", "last day to accrue (YYYY-MM-DD), defaults to yesterday")
+	payout := flag.String("payout", "", "month to pay out (YYYY-MM)")
+	flag.Parse()
+
+	if *from == "" && *payout == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.MustLoadConfig(*configPath)
+	db, err := sqlx.Connect("postgres", utils.BuildDatasourceName(utils.DataSource{
+		User:     cfg.DB.User,
+		Password: cfg.DB.Password,
+		Host:     cfg.DB.Host,
+		Database: cfg.DB.DBName,
+	}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	txPolicy, err := account.NewTxPolicy(cfg.DB.Transactions)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid transaction config:", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	accruer := interest.NewAccruer(cfg, db, txPolicy)
+
+	if *from != "" {
+		fromDate, err := time.Parse(time.DateOnly, *from)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -from:", err)
+			os.Exit(2)
+		}
+
+		toDate := time.Now().UTC().AddDate(0, 0, -1)
+		if *to != "" {
+			toDate, err = time.Parse(time.DateOnly, *to)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "invalid -to:", err)
+				os.Exit(2)
+			}
+		}
+
+		days, err := accruer.Backfill(ctx, fromDate, toDate)
+		for _, day := range days {
+			fmt.Println("accrued", day.Format(time.DateOnly))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *payout != "" {
+		month, err := time.Parse("2006-01", *payout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -payout:", err)
+			os.Exit(2)
+		}
+
+		paid, total, err := accruer.PayoutMonth(ctx, month)
+		fmt.Printf("paid out %s: %d accounts, total %.2f\n", *payout, paid, total)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/ledger"
+	"github.com/yeyee2901/test/internal/utils"
+)
+
+// ledger verifies and checkpoints the transaction hash chains:
+//
+//	go run ./cmd/ledger -keygen > setting/ledger.key
+//	go run ./cmd/ledger -verify
+//	go run ./cmd/ledger -checkpoint
+//	go run ./cmd/ledger -export -after 0 > checkpoints.json
+//	go run ./cmd/ledger -check checkpoints.json -public-key <hex>
+func main() {
+	configPath := flag.String("config", "setting/setting.yaml", "path to the config file")
+	keygen := flag.Bool("keygen", false, "print a new checkpoint signing key")
+	verify := flag.Bool("verify", false, "walk the hash chains and report the first broken link of each")
+	checkpoint := flag.Bool("checkpoint", false, "sign and store a checkpoint of the chain heads")
+	export := flag.Bool("export", false, "print the stored checkpoints as JSON")
+	after := flag.Int("after", 0, "with -export, only the checkpoints with an ID above this one")
+	check := flag.String("check", "", "exported checkpoints to check against the chains")
+	publicKey := flag.String("public-key", "", "with -check, the trusted public key (hex)")
+	flag.Parse()
+
+	if *keygen {
+		key, err := ledger.GenerateKey()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	if !*verify && !*checkpoint && !*export && *check == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.MustLoadConfig(*configPath)
+	db, err := sqlx.Connect("postgres", utils.BuildDatasourceName(utils.DataSource{
+		User:     cfg.DB.User,
+		Password: cfg.DB.Password,
+		Host:     cfg.DB.Host,
+		Database: cfg.DB.DBName,
+	}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	status := 0
+
+	if *verify {
+		result, err := ledger.Verify(ctx, db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		for _, brk := range result.Breaks {
+			fmt.Printf("account %d: broken at transaction %d: %s\n", brk.AccountID, brk.TransactionID, brk.Reason)
+		}
+		fmt.Printf("%d accounts, %d transactions checked, %d broken chains\n",
+			result.AccountsChecked, result.TransactionsChecked, len(result.Breaks))
+		if len(result.Breaks) > 0 {
+			status = 3
+		}
+	}
+
+	if *checkpoint {
+		key, err := ledger.LoadKey(cfg.Ledger.SigningKeyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cannot load the signing key:", err)
+			os.Exit(1)
+		}
+
+		cp, err := ledger.CreateCheckpoint(ctx, db, key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("checkpoint %d: %d accounts, root %s\n", cp.ID, len(cp.Heads), cp.Root)
+	}
+
+	if *export {
+		checkpoints, err := ledger.ListCheckpoints(ctx, db, *after)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checkpoints); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *check != "" {
+		if s := checkCheckpoints(ctx, db, *check, *publicKey); s > status {
+			status = s
+		}
+	}
+
+	os.Exit(status)
+}
+
+func checkCheckpoints(ctx context.Context, db *sqlx.DB, path, publicKey string) int {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	checkpoints := []ledger.Checkpoint{}
+	if err := json.Unmarshal(raw, &checkpoints); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid checkpoints:", err)
+		return 2
+	}
+
+	status := 0
+	for i := range checkpoints {
+		cp := &checkpoints[i]
+		if publicKey != "" && cp.PublicKey != publicKey {
+			fmt.Printf("checkpoint %d: signed by an untrusted key\n", cp.ID)
+			status = 3
+			continue
+		}
+
+		if err := ledger.VerifySignature(cp); err != nil {
+			fmt.Printf("checkpoint %d: %s\n", cp.ID, err)
+			status = 3
+			continue
+		}
+
+		missing, err := ledger.CheckHeads(ctx, db, cp)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, head := range missing {
+			fmt.Printf("checkpoint %d: head %s of account %d is no longer in its chain\n", cp.ID, head.Hash, head.AccountID)
+			status = 3
+		}
+	}
+
+	fmt.Printf("%d checkpoints checked\n", len(checkpoints))
+	return status
+}
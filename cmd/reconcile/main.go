@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/reconcile"
+	"github.com/yeyee2901/test/internal/utils"
+)
+
+// reconcile compares the stored balances against the ledger and prints
+// the drift report. Once an operator reviewed a JSON report, its
+// correcting adjustments are written with -apply:
+//
+//	go run ./cmd/reconcile -format csv
+//	go run ./cmd/reconcile -out drift.json
+//	go run ./cmd/reconcile -apply drift.json -approved-by alice
+func main() {
+	configPath := flag.String("config", "setting/setting.yaml", "path to the config file")
+	format := flag.String("format", "json", "report format, json or csv")
+	out := flag.String("out", "", "file to write the report to, defaults to stdout")
+	apply := flag.String("apply", "", "approved JSON report to write the adjustments of")
+	approvedBy := flag.String("approved-by", "", "operator approving the adjustments")
+	flag.Parse()
+
+	if *format != "json" && *format != "csv" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.MustLoadConfig(*configPath)
+	db, err := sqlx.Connect("postgres", utils.BuildDatasourceName(utils.DataSource{
+		User:     cfg.DB.User,
+		Password: cfg.DB.Password,
+		Host:     cfg.DB.Host,
+		Database: cfg.DB.DBName,
+	}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	txPolicy, err := account.NewTxPolicy(cfg.DB.Transactions)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid transaction config:", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	reconciler := reconcile.NewReconciler(db, txPolicy)
+
+	if *apply != "" {
+		os.Exit(applyReport(ctx, reconciler, *apply, *approvedBy))
+	}
+
+	report, err := reconciler.Run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "csv" {
+		err = report.WriteCSV(w)
+	} else {
+		err = report.WriteJSON(w)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d accounts checked, %d drifted\n", report.AccountsChecked, len(report.Drifts))
+	if len(report.Drifts) > 0 {
+		os.Exit(3)
+	}
+}
+
+func applyReport(ctx context.Context, reconciler *reconcile.Reconciler, path, approvedBy string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	report, err := reconcile.ReadReport(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid report:", err)
+		return 2
+	}
+
+	adjusted, skipped, err := reconciler.Adjust(ctx, report, approvedBy)
+	for _, d := range adjusted {
+		fmt.Printf("adjusted account %d by %.2f\n", d.AccountID, d.Drift)
+	}
+	for _, d := range skipped {
+		fmt.Printf("skipped account %d, drift changed since the report\n", d.AccountID)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -9,9 +10,20 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
 	"github.com/yeyee2901/test/internal/api"
+	"github.com/yeyee2901/test/internal/grpcapi"
+	"github.com/yeyee2901/test/internal/importer"
+	"github.com/yeyee2901/test/internal/interest"
+	"github.com/yeyee2901/test/internal/ledger"
 	"github.com/yeyee2901/test/internal/logging"
+	"github.com/yeyee2901/test/internal/outbox"
+	"github.com/yeyee2901/test/internal/reconcile"
+	"github.com/yeyee2901/test/internal/replica"
+	"github.com/yeyee2901/test/internal/scheduler"
+	"github.com/yeyee2901/test/internal/snapshot"
 	"github.com/yeyee2901/test/internal/utils"
+	"github.com/yeyee2901/test/internal/webhook"
 )
 
 // @title                   API Gateway - Simple Account
@@ -24,7 +36,11 @@ func main() {
 	cfg := config.MustLoadConfig("setting/setting.yaml")
 
 	// setup logger
-	logger := logging.NewFileLogger(cfg.Server.Logfile, cfg.Server.Name, slog.LevelInfo)
+	logger, err := logging.New(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot setup logging:", err)
+		os.Exit(1)
+	}
 	slog.SetDefault(logger)
 
 	db, err := connectDB(cfg)
@@ -33,30 +49,135 @@ func main() {
 		os.Exit(1)
 	}
 
-	server := api.NewAPIServer(cfg, db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// relay the domain events to the sinks
+	if cfg.Outbox.Enabled {
+		sinks, err := outbox.NewSinksFromConfig(cfg)
+		if err != nil {
+			slog.Error("Cannot setup outbox sinks", "error", err)
+			os.Exit(1)
+		}
+
+		// webhooks are fanned out from the outbox
+		if cfg.Webhook.Enabled {
+			sinks = append(sinks, webhook.NewDispatcher(db))
+			go webhook.NewWorker(cfg, db).Run(ctx)
+		}
+
+		relay := outbox.NewRelay(cfg, db, sinks)
+		go relay.Run(ctx)
+	}
+
+	// the balance & history reads go to the replicas
+	replicas, err := openReplicas(cfg)
+	if err != nil {
+		slog.Error("Cannot open read replicas", "error", err)
+		os.Exit(1)
+	}
+	reads := replica.NewRouter(cfg, db, cfg.DB.Replicas, replicas)
+	go reads.Run(ctx)
+
+	txPolicy, err := account.NewTxPolicy(cfg.DB.Transactions)
+	if err != nil {
+		slog.Error("Invalid transaction config", "error", err)
+		os.Exit(1)
+	}
+
+	opts := []account.Option{
+		account.WithFeeEngine(account.NewFeeEngine(cfg.Fees)),
+		account.WithReadReplicas(reads),
+		account.WithTxPolicy(txPolicy),
+	}
+	if cfg.GroupCommit.Enabled {
+		opts = append(opts, account.WithGroupCommit(cfg.GroupCommit))
+	}
+	if cfg.Cache.Enabled {
+		opts = append(opts, account.WithBalanceCache(account.NewBalanceCache(nil, cfg.Cache)))
+	}
+	ewallet := account.NewSimpleEWalletSystem(db, opts...)
+
+	// the writers of a hot account wait in memory, not on its row lock
+	if cfg.WriteQueue.Enabled {
+		ewallet = account.NewWriteQueue(ewallet, cfg.WriteQueue)
+	}
+
+	// execute the standing orders
+	if cfg.Scheduler.Enabled {
+		executor := scheduler.NewExecutor(cfg, db, ewallet, txPolicy)
+		go executor.Run(ctx)
+	}
+
+	// execute the CSV imports
+	if cfg.Import.Enabled {
+		go importer.NewWorker(cfg, db, ewallet, txPolicy).Run(ctx)
+	}
+
+	// accrue & pay the interest
+	if cfg.Interest.Enabled {
+		go interest.NewJob(cfg, db, txPolicy).Run(ctx)
+	}
+
+	// snapshot the daily balances of the past balance queries
+	if cfg.Snapshots.Enabled {
+		go snapshot.NewJob(cfg, db).Run(ctx)
+	}
+
+	// check the balances against the ledger
+	if cfg.Reconcile.Enabled {
+		go reconcile.NewJob(cfg, db, txPolicy).Run(ctx)
+	}
+
+	// sign the transaction hash chain heads
+	if cfg.Ledger.CheckpointEnabled {
+		job, err := ledger.NewCheckpointJob(cfg, db)
+		if err != nil {
+			slog.Error("Cannot setup ledger checkpoints", "error", err)
+			os.Exit(1)
+		}
+		go job.Run(ctx)
+	}
+
+	server := api.NewAPIServer(cfg, db, ewallet)
 
 	server.RegisterMiddlewares()
 	server.RegisterEndpoints()
 
 	errChan := server.Run()
 	slog.Info("Server is running")
-	err = <-errChan
+
+	// the gRPC API is served on its own listener
+	var grpcErrChan <-chan error
+	if cfg.Server.GRPCListener != "" {
+		grpcServer := grpcapi.NewServer(cfg, ewallet)
+		grpcErrChan = grpcServer.Run()
+		slog.Info("gRPC server is running", "listener", cfg.Server.GRPCListener)
+	}
+
+	// the metrics and the log level are served on the admin listener only
+	var adminErrChan <-chan error
+	if cfg.Server.AdminListener != "" {
+		adminErrChan = api.NewAdminServer(cfg).Run()
+		slog.Info("Admin server is running", "listener", cfg.Server.AdminListener)
+	}
+
+	select {
+	case err = <-errChan:
+	case err = <-grpcErrChan:
+	case err = <-adminErrChan:
+	}
 	if err != nil {
-		slog.Error("Server exited")
+		slog.Error("Server exited", "error", err)
 		os.Exit(1)
 	}
 }
 
 func connectDB(cfg *config.Config) (*sqlx.DB, error) {
-	dsn := utils.BuildDatasourceName(utils.DataSource{
-		User:     cfg.DB.User,
-		Password: cfg.DB.Password,
-		Host:     cfg.DB.Host,
-		Database: cfg.DB.DBName,
-	})
+	dsn := datasourceName(cfg, cfg.DB.Host)
 	db, err := sqlx.Connect("postgres", dsn)
 	if err != nil {
-		return nil, fmt.Errorf("cannot connect to %s : %w", dsn, err)
+		return nil, fmt.Errorf("cannot connect to %s : %w", logging.RedactString(dsn), err)
 	}
 
 	// OPTIMIZE: fine tuning disini
@@ -66,3 +187,31 @@ func connectDB(cfg *config.Config) (*sqlx.DB, error) {
 
 	return db, nil
 }
+
+// openReplicas opens the read replicas without connecting, an unreachable
+// replica is left out by the lag checks instead of failing the startup
+func openReplicas(cfg *config.Config) ([]*sqlx.DB, error) {
+	replicas := make([]*sqlx.DB, 0, len(cfg.DB.Replicas))
+	for _, host := range cfg.DB.Replicas {
+		db, err := sqlx.Open("postgres", datasourceName(cfg, host))
+		if err != nil {
+			return nil, fmt.Errorf("cannot open replica %s : %w", host, err)
+		}
+
+		db.SetMaxOpenConns(50)
+		db.SetMaxIdleConns(25)
+		db.SetConnMaxLifetime(time.Hour)
+		replicas = append(replicas, db)
+	}
+
+	return replicas, nil
+}
+
+func datasourceName(cfg *config.Config, host string) string {
+	return utils.BuildDatasourceName(utils.DataSource{
+		User:     cfg.DB.User,
+		Password: cfg.DB.Password,
+		Host:     host,
+		Database: cfg.DB.DBName,
+	})
+}
@@ -1,14 +1,59 @@
 package config
 
 import (
+	"errors"
 	"os"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server ServerConfig `yaml:"server"`
-	DB     DBConfig     `yaml:"db"`
+	Server      ServerConfig      `yaml:"server"`
+	DB          DBConfig          `yaml:"db"`
+	Outbox      OutboxConfig      `yaml:"outbox"`
+	Webhook     WebhookConfig     `yaml:"webhook"`
+	Scheduler   SchedulerConfig   `yaml:"scheduler"`
+	Fees        FeeConfig         `yaml:"fees"`
+	Interest    InterestConfig    `yaml:"interest"`
+	Reconcile   ReconcileConfig   `yaml:"reconcile"`
+	Ledger      LedgerConfig      `yaml:"ledger"`
+	Import      ImportConfig      `yaml:"import"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	CORS        CORSConfig        `yaml:"cors"`
+	WriteQueue  WriteQueueConfig  `yaml:"write_queue"`
+	GroupCommit GroupCommitConfig `yaml:"group_commit"`
+	Cache       CacheConfig       `yaml:"cache"`
+	Snapshots   SnapshotConfig    `yaml:"snapshots"`
+}
+
+// WriteQueueConfig serializes the writes of every account in process. A
+// write is rejected when MaxQueueLength writes of its account are already
+// waiting. The goroutine of an account stops after IdleTimeoutSeconds
+// without writes.
+type WriteQueueConfig struct {
+	Enabled            bool `yaml:"enabled"`
+	MaxQueueLength     int  `yaml:"max_queue_length"`
+	IdleTimeoutSeconds int  `yaml:"idle_timeout_seconds"`
+}
+
+// GroupCommitConfig coalesces the concurrent credits of an account into 1
+// database transaction, committed WindowMs after the first credit or once
+// MaxBatchSize credits joined
+type GroupCommitConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	WindowMs     int  `yaml:"window_ms"`
+	MaxBatchSize int  `yaml:"max_batch_size"`
+}
+
+// CacheConfig caches the accounts read by ReadUser in process, holding at
+// most MaxEntries of them for TTLSeconds. The writes of this instance
+// invalidate their accounts, the writes of the others show up after
+// MaxStalenessMs. GetUser always reads the primary and refreshes the cache.
+type CacheConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	MaxEntries     int  `yaml:"max_entries"`
+	TTLSeconds     int  `yaml:"ttl_seconds"`
+	MaxStalenessMs int  `yaml:"max_staleness_ms"`
 }
 
 type ServerConfig struct {
@@ -17,6 +62,46 @@ type ServerConfig struct {
 	Listener             string `yaml:"listener"`
 	ServerTimeoutSeconds int    `yaml:"server_timeout_seconds"`
 	Logfile              string `yaml:"logfile"`
+
+	// GRPCListener is the address of the gRPC API, empty disables it
+	GRPCListener string `yaml:"grpc_listener"`
+
+	// AdminListener is the address of /debug/vars and /debug/log-level,
+	// empty disables them. They are not authenticated, the address must
+	// not be reachable from outside.
+	AdminListener string `yaml:"admin_listener"`
+
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig configures the TLS termination of the HTTP API. The
+// certificates are reloaded when their files change on disk, checked at
+// most every ReloadIntervalSeconds.
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// MinVersion is 1.2 or 1.3, 1.2 by default
+	MinVersion string `yaml:"min_version"`
+
+	// CipherSuites are the names of the TLS 1.2 cipher suites, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Empty uses the Go defaults,
+	// TLS 1.3 suites are not configurable.
+	CipherSuites []string `yaml:"cipher_suites"`
+
+	// ClientCAFile enables the client certificates, verified against this
+	// CA bundle. RequireClientCert rejects the callers without one.
+	ClientCAFile      string `yaml:"client_ca_file"`
+	RequireClientCert bool   `yaml:"require_client_cert"`
+
+	// ClientIdentities maps the subject common name of the client
+	// certificates to an API identity. When set, the certificates of the
+	// other subjects are rejected, otherwise the common name is the
+	// identity.
+	ClientIdentities map[string]string `yaml:"client_identities"`
+
+	ReloadIntervalSeconds int `yaml:"reload_interval_seconds"`
 }
 
 type DBConfig struct {
@@ -24,10 +109,159 @@ type DBConfig struct {
 	Host     string `yaml:"host"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
+
+	// Replicas are the hosts of the read replicas, with the same database
+	// and credentials as the primary
+	Replicas []string `yaml:"replicas"`
+
+	// ReadYourWritesSeconds routes the reads of an account to the primary
+	// for this long after it was written, so a caller sees its own writes
+	ReadYourWritesSeconds int `yaml:"read_your_writes_seconds"`
+
+	// MaxReplicaLagSeconds is the lag above which a replica is skipped,
+	// checked every ReplicaCheckIntervalSeconds
+	MaxReplicaLagSeconds        float64 `yaml:"max_replica_lag_seconds"`
+	ReplicaCheckIntervalSeconds int     `yaml:"replica_check_interval_seconds"`
+
+	Transactions TransactionConfig `yaml:"transactions"`
+}
+
+// TransactionConfig is the isolation of the money movements. The
+// serialization failures and deadlocks are retried MaxAttempts times in
+// total, with a jittered exponential backoff from RetryBaseMs to
+// RetryMaxMs.
+type TransactionConfig struct {
+	// Isolation is read_committed (default), repeatable_read or
+	// serializable
+	Isolation   string `yaml:"isolation"`
+	MaxAttempts int    `yaml:"max_attempts"`
+	RetryBaseMs int    `yaml:"retry_base_ms"`
+	RetryMaxMs  int    `yaml:"retry_max_ms"`
+}
+
+// OutboxConfig configures the outbox relay. A failed event is retried
+// with exponential backoff from BackoffBaseSeconds to BackoffMaxSeconds,
+// and is dead after MaxAttempts attempts.
+type OutboxConfig struct {
+	Enabled            bool         `yaml:"enabled"`
+	PollIntervalMs     int          `yaml:"poll_interval_ms"`
+	BatchSize          int          `yaml:"batch_size"`
+	MaxAttempts        int          `yaml:"max_attempts"`
+	BackoffBaseSeconds int          `yaml:"backoff_base_seconds"`
+	BackoffMaxSeconds  int          `yaml:"backoff_max_seconds"`
+	Sinks              []SinkConfig `yaml:"sinks"`
+}
+
+// SinkConfig describes where the outbox events are delivered to.
+// Type is one of: stdout, file, http
+type SinkConfig struct {
+	Type           string `yaml:"type"`
+	Path           string `yaml:"path"`
+	URL            string `yaml:"url"`
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+}
+
+// WebhookConfig configures the webhook delivery worker. The webhooks are
+// fed by the outbox, so the outbox must be enabled as well.
+type WebhookConfig struct {
+	Enabled            bool `yaml:"enabled"`
+	PollIntervalMs     int  `yaml:"poll_interval_ms"`
+	BatchSize          int  `yaml:"batch_size"`
+	MaxAttempts        int  `yaml:"max_attempts"`
+	BackoffBaseSeconds int  `yaml:"backoff_base_seconds"`
+	BackoffMaxSeconds  int  `yaml:"backoff_max_seconds"`
+	TimeoutSeconds     int  `yaml:"timeout_seconds"`
+}
+
+// SchedulerConfig configures the executor of the scheduled transfers
+type SchedulerConfig struct {
+	Enabled             bool `yaml:"enabled"`
+	PollIntervalSeconds int  `yaml:"poll_interval_seconds"`
+	BatchSize           int  `yaml:"batch_size"`
+}
+
+// FeeConfig configures the fee charged on the debits. The fees are
+// credited to the house account.
+type FeeConfig struct {
+	HouseAccount string    `yaml:"house_account"`
+	Rules        []FeeRule `yaml:"rules"`
+}
+
+// FeeRule applies to a transaction type ("withdrawal" or "transfer") and
+// an account tier, empty means any. The first matching rule wins.
+//
+// The fee is Flat + Percentage% of the amount. When Tiers are set, the
+// Flat and Percentage of the first tier whose UpTo covers the amount are
+// used instead. The result is then capped by Min and Max (0 = no cap).
+type FeeRule struct {
+	TrxType    string    `yaml:"trx_type"`
+	Tier       string    `yaml:"tier"`
+	Flat       float64   `yaml:"flat"`
+	Percentage float64   `yaml:"percentage"`
+	Tiers      []FeeTier `yaml:"tiers"`
+	Min        float64   `yaml:"min"`
+	Max        float64   `yaml:"max"`
+}
+
+// FeeTier covers the amounts up to UpTo (inclusive), 0 means unbounded
+type FeeTier struct {
+	UpTo       float64 `yaml:"up_to"`
+	Flat       float64 `yaml:"flat"`
+	Percentage float64 `yaml:"percentage"`
+}
+
+// InterestConfig configures the daily interest accrual of the savings
+// product. AnnualRates maps the account tier to the annual rate in
+// percent, tiers without a rate do not earn interest.
+type InterestConfig struct {
+	Enabled     bool               `yaml:"enabled"`
+	AnnualRates map[string]float64 `yaml:"annual_rates"`
+
+	// DayCount is the day count convention: ACT/365 (default), ACT/360
+	// or ACT/ACT
+	DayCount string `yaml:"day_count"`
+
+	// PayoutDay is the day of month on which the accruals of the previous
+	// month are paid out, defaults to 1
+	PayoutDay int `yaml:"payout_day"`
+}
+
+// ReconcileConfig configures the scheduled ledger reconciliation. When
+// ReportDir is set, a JSON report is written there on every drift.
+type ReconcileConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	IntervalMinutes int    `yaml:"interval_minutes"`
+	ReportDir       string `yaml:"report_dir"`
+}
+
+// LedgerConfig configures the signed checkpoints of the transaction hash
+// chains. SigningKeyFile holds the hex encoded ed25519 seed, it can be
+// generated with the ledger command.
+type LedgerConfig struct {
+	CheckpointEnabled         bool   `yaml:"checkpoint_enabled"`
+	CheckpointIntervalMinutes int    `yaml:"checkpoint_interval_minutes"`
+	SigningKeyFile            string `yaml:"signing_key_file"`
+}
+
+// SnapshotConfig configures the job materializing the daily balance
+// snapshots of the past balance queries. At most MaxDaysPerRun missed
+// days are caught up per run.
+type SnapshotConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	MaxDaysPerRun int  `yaml:"max_days_per_run"`
+}
+
+// ImportConfig configures the worker executing the CSV imports
+type ImportConfig struct {
+	Enabled             bool `yaml:"enabled"`
+	PollIntervalSeconds int  `yaml:"poll_interval_seconds"`
+
+	// MaxRows caps the rows of 1 file, defaults to 10000
+	MaxRows int `yaml:"max_rows"`
 }
 
 func MustLoadConfig(path string) *Config {
-	f, err := os.ReadFile("setting/setting.yaml")
+	f, err := os.ReadFile(path)
 	if err != nil {
 		panic(err)
 	}
@@ -38,5 +272,92 @@ func MustLoadConfig(path string) *Config {
 		panic(err)
 	}
 
+	err = cfg.Validate()
+	if err != nil {
+		panic(err)
+	}
+
 	return cfg
 }
+
+// Validate rejects the combinations of settings that cannot work
+func (c *Config) Validate() error {
+	if c.Webhook.Enabled && !c.Outbox.Enabled {
+		return errors.New("config: webhook requires the outbox to be enabled")
+	}
+
+	return nil
+}
+
+// LoggingConfig configures the logs. The keys are added to the defaults
+// of the logging package, the secrets are always redacted.
+type LoggingConfig struct {
+	// Level is one of debug, info, warn, error. It can be changed at
+	// runtime on /debug/log-level of the admin listener.
+	Level string `yaml:"level"`
+
+	// Format is json or text, the sinks may override it
+	Format string `yaml:"format"`
+
+	// Sinks are where the records are written to, server.logfile is used
+	// when there is none
+	Sinks []LogSinkConfig `yaml:"sinks"`
+
+	// RedactKeys are the attribute keys whose value is redacted
+	RedactKeys []string `yaml:"redact_keys"`
+
+	// HashKeys are the attribute keys of the account identifiers, their
+	// value is replaced by a salted hash
+	HashKeys []string `yaml:"hash_keys"`
+	HashSalt string   `yaml:"hash_salt"`
+
+	AccessLog AccessLogConfig `yaml:"access_log"`
+}
+
+// AccessLogConfig configures the HTTP access log. SampleRate is the
+// fraction (0 to 1) of the successful requests that are logged, the
+// failed requests and the ones slower than SlowThresholdMs always are.
+type AccessLogConfig struct {
+	Enabled         bool    `yaml:"enabled"`
+	SampleRate      float64 `yaml:"sample_rate"`
+	SlowThresholdMs int     `yaml:"slow_threshold_ms"`
+}
+
+// LogSinkConfig describes where the records are written to.
+// Type is one of: stdout, stderr, file. The file is rotated once it
+// reaches MaxSizeMB, MaxAgeDays and MaxBackups limit the rotated files,
+// 0 keeps them all.
+type LogSinkConfig struct {
+	Type       string `yaml:"type"`
+	Format     string `yaml:"format"`
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxAgeDays int    `yaml:"max_age_days"`
+	MaxBackups int    `yaml:"max_backups"`
+	Compress   bool   `yaml:"compress"`
+}
+
+// CORSConfig is the CORS policy of the HTTP API. A route policy applies
+// to the paths starting with its PathPrefix, the longest prefix wins, and
+// replaces the default policy entirely.
+type CORSConfig struct {
+	CORSPolicy `yaml:",inline"`
+	Routes     []CORSRoute `yaml:"routes"`
+}
+
+type CORSRoute struct {
+	PathPrefix string `yaml:"path_prefix"`
+	CORSPolicy `yaml:",inline"`
+}
+
+// CORSPolicy lists what the cross-origin callers are allowed. An origin is
+// either exact (https://app.example.com), a pattern (https://*.example.com)
+// or "*", which allows any origin but never with credentials.
+type CORSPolicy struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAgeSeconds    int      `yaml:"max_age_seconds"`
+}
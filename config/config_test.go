@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		outbox  bool
+		webhook bool
+		wantErr bool
+	}{
+		{"nothing enabled", false, false, false},
+		{"outbox only", true, false, false},
+		{"webhook with the outbox", true, true, false},
+		{"webhook without the outbox", false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Outbox.Enabled = tt.outbox
+			cfg.Webhook.Enabled = tt.webhook
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
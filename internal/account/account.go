@@ -4,25 +4,37 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/internal/outbox"
+	"github.com/yeyee2901/test/internal/replica"
 )
 
-var (
-	ErrInsufficient = fmt.Errorf("account: insufficient funds")
-	ErrNotFound     = fmt.Errorf("account: data not found")
-)
+// ErrNoHouse is a misconfiguration, not a domain error
+var ErrNoHouse = fmt.Errorf("account: fee house account not found")
 
 const (
 	TrxTypeCredit = "credit"
 	TrxTypeDebit  = "debit"
 )
 
+// domain events written to the outbox on every balance mutation
+const (
+	EventBalanceCredited = "BalanceCredited"
+	EventBalanceDebited  = "BalanceDebited"
+	EventLedgerAdjusted  = "LedgerAdjusted"
+)
+
 type Account struct {
 	ID        int          `db:"id"`
 	Username  string       `db:"username"`
 	Balance   float64      `db:"balance"`
+	Tier      string       `db:"tier"`
 	CreatedAt sql.NullTime `db:"created_at"`
+
+	// Version is incremented by every mutation of the account
+	Version int64 `db:"version"`
 }
 
 type Transactions struct {
@@ -31,6 +43,36 @@ type Transactions struct {
 	Amount    float64      `db:"amount"`
 	TrxType   string       `db:"type"`
 	CreatedAt sql.NullTime `db:"created_at"`
+
+	// ParentID links a fee transaction to its principal transaction
+	ParentID sql.NullInt64 `db:"parent_id"`
+
+	// Fee is the fee charged on top of the principal, it is recorded as
+	// its own transaction
+	Fee float64 `db:"-"`
+
+	// PrevHash and Hash link the transaction into the hash chain of its
+	// account, see ChainHash
+	PrevHash string `db:"-"`
+	Hash     string `db:"-"`
+
+	// Bucket is the credited bucket of a hot account, its chain holds the
+	// transaction instead of the chain of the account
+	Bucket sql.NullInt32 `db:"bucket"`
+
+	// BalanceAfter is the balance of the account once the transaction was
+	// written, fee included. It is not valid for a hot account.
+	BalanceAfter sql.NullFloat64 `db:"balance_after"`
+}
+
+// BalanceChangedEvent is the payload of the BalanceCredited and
+// BalanceDebited events
+type BalanceChangedEvent struct {
+	AccountID     int       `json:"account_id"`
+	TransactionID int       `json:"transaction_id"`
+	Amount        float64   `json:"amount"`
+	Type          string    `json:"type"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 // EWalletSystem is the interface responsible for operations
@@ -42,25 +84,108 @@ type EWalletSystem interface {
 	// GetUser gets user info with this username
 	GetUser(username string) (*Account, error)
 
+	// ReadUser is GetUser from a read replica, when configured. The
+	// balance may lag behind, it is for display and never for moving money.
+	ReadUser(username string) (*Account, error)
+
+	// GetBalanceAt returns the balance of the user at the time, from the
+	// latest running balance or daily snapshot before it, whichever is
+	// later, and the transactions since. It is read from a read replica,
+	// when configured.
+	GetBalanceAt(acc *Account, at time.Time) (float64, error)
+
 	// AddBalance adds fund for the user
 	AddBalance(*Account, float64) (*Transactions, error)
 
 	// DeductBalance deducts fund from the user
 	DeductBalance(*Account, float64) (*Transactions, error)
+
+	// AddBalanceIfVersion is AddBalance, failing with ErrVersionMismatch
+	// when the account is no longer at version
+	AddBalanceIfVersion(acc *Account, amount float64, version int64) (*Transactions, error)
+
+	// DeductBalanceIfVersion is DeductBalance, failing with
+	// ErrVersionMismatch when the account is no longer at version
+	DeductBalanceIfVersion(acc *Account, amount float64, version int64) (*Transactions, error)
+
+	// Transfer moves fund between 2 users atomically, returning the debit
+	// transaction of the sender and the credit transaction of the receiver
+	Transfer(from *Account, to *Account, amount float64) (*Transactions, *Transactions, error)
+
+	// ExecuteBatch executes the items, returning 1 result per item. In
+	// atomic mode the items are executed in 1 transaction and a failed
+	// item rolls back the whole batch, otherwise every item is executed
+	// on its own. The error is only set when the batch could not run.
+	ExecuteBatch(items []BatchItem, atomic bool) ([]BatchResult, error)
+
+	// ListTransactions lists the latest transactions of the user, newest
+	// first. When beforeID is set, only the transactions older than it
+	// are listed, for paging. They are read from a read replica, when
+	// configured.
+	ListTransactions(acc *Account, limit int, beforeID int) ([]Transactions, error)
+
+	// QuoteFee returns the fee that would be charged on top of the amount
+	// for the transaction type (FeeTrxWithdrawal or FeeTrxTransfer)
+	QuoteFee(acc *Account, trxType string, amount float64) float64
 }
 
 type simpleEWallet struct {
-	db *sqlx.DB
+	db    *sqlx.DB
+	fees  *FeeEngine
+	reads *replica.Router
+	txs   *TxPolicy
+
+	// credits coalesces the credits of AddBalance, nil when disabled
+	credits *groupCommit
+
+	// cache serves GetUser and ReadUser, nil when disabled
+	cache *BalanceCache
+}
+
+// Option configures the EWalletSystem
+type Option func(*simpleEWallet)
+
+// WithFeeEngine charges the fees computed by the engine on the debits
+func WithFeeEngine(fees *FeeEngine) Option {
+	return func(s *simpleEWallet) {
+		s.fees = fees
+	}
 }
 
-func NewSimpleEWalletSystem(db *sqlx.DB) EWalletSystem {
-	return &simpleEWallet{
-		db: db,
+// WithReadReplicas reads the balances and the transaction history from
+// the replicas of the router
+func WithReadReplicas(router *replica.Router) Option {
+	return func(s *simpleEWallet) {
+		s.reads = router
 	}
 }
 
+func NewSimpleEWalletSystem(db *sqlx.DB, opts ...Option) EWalletSystem {
+	s := &simpleEWallet{
+		db:  db,
+		txs: defaultTxPolicy(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
 // CreateNewAccount implements AccountService.
 func (s *simpleEWallet) CreateNewAccount(initBalance float64, userName string) error {
+	checks := []error{ValidateUsername(userName)}
+	if initBalance != 0 {
+		checks = append(checks, ValidateAmount(initBalance))
+	}
+	err := validateInput(checks...)
+	if err != nil {
+		return err
+	}
+
+	// the account starts empty, the initial balance is recorded as an
+	// opening credit so that the balance always matches the ledger
 	q := `
         INSERT INTO users
         (
@@ -69,49 +194,67 @@ func (s *simpleEWallet) CreateNewAccount(initBalance float64, userName string) e
         )
         VALUES 
         (
-            :username,
-            :balance
+            $1,
+            0
         )
+        RETURNING
+            id
     `
 
-	tx, err := s.db.Beginx()
-	if err != nil {
-		return err
-	}
+	return s.inTx("CreateNewAccount", func(tx *sqlx.Tx) error {
+		var accID int
+		err := tx.Get(&accID, q, userName)
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.NamedExec(q, Account{
-		Username: userName,
-		Balance:  initBalance,
-	})
-	if err != nil {
-		tx.Rollback()
+		if initBalance != 0 {
+			_, err = Credit(tx, accID, initBalance)
+		}
 		return err
+	})
+}
+
+// GetUser implements AccountService. The account is always read from the
+// primary, its balance and version are checked before the writes, and it
+// refreshes the cached account of ReadUser.
+func (s *simpleEWallet) GetUser(username string) (*Account, error) {
+	fetch := func() (*Account, error) { return getUser(s.db, username) }
+	if s.cache == nil {
+		return fetch()
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return err
+	return s.cache.refresh(username, fetch)
+}
+
+// ReadUser implements EWalletSystem. A cached account is at most the
+// staleness bound old.
+func (s *simpleEWallet) ReadUser(username string) (*Account, error) {
+	fetch := func() (*Account, error) { return getUser(s.reader(username), username) }
+	if s.cache == nil {
+		return fetch()
 	}
 
-	return nil
+	return s.cache.load(username, fetch)
 }
 
-// GetUser implements AccountService.
-func (s *simpleEWallet) GetUser(username string) (*Account, error) {
+func getUser(db *sqlx.DB, username string) (*Account, error) {
+	// the balance of a hot account is spread over its buckets
 	q := `
         SELECT 
-            id, username, balance, created_at
-        FROM users
-        WHERE username = $1
+            u.id, u.username, u.tier, u.created_at,
+            u.balance + COALESCE((SELECT SUM(b.balance) FROM balance_buckets b WHERE b.user_id = u.id), 0) AS balance,
+            u.version + COALESCE((SELECT SUM(b.version) FROM balance_buckets b WHERE b.user_id = u.id), 0)::BIGINT AS version
+        FROM users u
+        WHERE u.username = $1
         LIMIT 1
     `
 
 	acc := new(Account)
-	err := s.db.Get(acc, q, username)
+	err := db.Get(acc, q, username)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.Join(ErrNotFound, err)
+			return nil, errors.Join(ErrNotFound.Detailf("user not found"), err)
 		}
 		return nil, err
 	}
@@ -119,122 +262,298 @@ func (s *simpleEWallet) GetUser(username string) (*Account, error) {
 	return acc, nil
 }
 
+// reader returns the database to read the data of the user from
+func (s *simpleEWallet) reader(username string) *sqlx.DB {
+	if s.reads == nil {
+		return s.db
+	}
+	return s.reads.Reader(username)
+}
+
+// written routes the reads of the users to the primary for a while and
+// drops their cached accounts, so the caller reads its own writes
+func (s *simpleEWallet) written(usernames ...string) {
+	if s.reads != nil {
+		s.reads.Written(usernames...)
+	}
+	if s.cache != nil {
+		s.cache.invalidate(usernames...)
+	}
+}
+
 // AddBalance implements AccountService.
 func (s *simpleEWallet) AddBalance(acc *Account, amount float64) (*Transactions, error) {
-	qBalance := `
-        UPDATE users
-        SET
-            balance = balance + $1
-        WHERE
-            id = $2
-    `
-	tx, err := s.db.Beginx()
+	if s.credits == nil {
+		return s.addBalance(acc, amount, nil)
+	}
+
+	// an invalid amount must not fail the other credits of its batch
+	err := ValidateAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.credits.add(acc, amount)
+}
+
+// AddBalanceIfVersion implements EWalletSystem.
+func (s *simpleEWallet) AddBalanceIfVersion(acc *Account, amount float64, version int64) (*Transactions, error) {
+	return s.addBalance(acc, amount, &version)
+}
+
+// addBalance credits the account, checking first that it is at version
+// when version is set
+func (s *simpleEWallet) addBalance(acc *Account, amount float64, version *int64) (*Transactions, error) {
+	err := ValidateAmount(amount)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = tx.Exec(qBalance, amount, acc.ID)
+	var trx *Transactions
+	err = s.inTx("AddBalance", func(tx *sqlx.Tx) error {
+		err := checkVersion(tx, acc.ID, version)
+		if err != nil {
+			return err
+		}
+
+		trx, err = Credit(tx, acc.ID, amount)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.written(acc.Username)
+
+	return trx, nil
+}
+
+// Credit adds fund for the user within the caller's transaction, with the
+// same bookkeeping as AddBalance. This is for jobs that must commit the
+// credit together with their own records.
+func Credit(tx *sqlx.Tx, accID int, amount float64) (*Transactions, error) {
+	bucket, err := updateBalance(tx, accID, amount)
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
 	// if successful on adding balance, then create
 	// the transaction record
-	qTrx := `
-        INSERT INTO transactions
-            (user_id, amount, type)
-        VALUES
-            ($1, $2, $3)
-        RETURNING
-            id, created_at
-    `
-
 	trx := &Transactions{
-		UserID:  acc.ID,
+		UserID:  accID,
 		Amount:  amount,
 		TrxType: TrxTypeCredit,
+		Bucket:  bucket,
 	}
+	err = recordTrx(tx, trx)
+	if err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+// DeductBalance implements EWalletSystem.
+func (s *simpleEWallet) DeductBalance(acc *Account, amount float64) (*Transactions, error) {
+	return s.deductBalance(acc, amount, nil)
+}
+
+// DeductBalanceIfVersion implements EWalletSystem.
+func (s *simpleEWallet) DeductBalanceIfVersion(acc *Account, amount float64, version int64) (*Transactions, error) {
+	// the balance checked below is only current at the account version
+	if acc.Version != version {
+		return nil, versionMismatch(acc.Version, version)
+	}
+
+	return s.deductBalance(acc, amount, &version)
+}
 
-	var trxID int
-	var trxDate sql.NullTime
-	err = tx.QueryRow(qTrx, trx.UserID, trx.Amount, trx.TrxType).Scan(&trxID, &trxDate)
+// deductBalance debits the account, checking first that it is at version
+// when version is set
+func (s *simpleEWallet) deductBalance(acc *Account, amount float64, version *int64) (*Transactions, error) {
+	err := ValidateAmount(amount)
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	err = tx.Commit()
+	fee := s.fees.Calculate(FeeTrxWithdrawal, acc.Tier, amount)
+	if !canDeductFund(acc, amount+fee) {
+		return nil, ErrInsufficient
+	}
+
+	var trx *Transactions
+	err = s.inTx("DeductBalance", func(tx *sqlx.Tx) error {
+		err := checkVersion(tx, acc.ID, version)
+		if err != nil {
+			return err
+		}
+
+		bucket, err := updateBalance(tx, acc.ID, -amount)
+		if err != nil {
+			return err
+		}
+
+		// if successful on deducting balance, then create
+		// the transaction record
+		trx = &Transactions{
+			UserID:  acc.ID,
+			Amount:  amount,
+			TrxType: TrxTypeDebit,
+			Bucket:  bucket,
+		}
+		err = recordTrx(tx, trx)
+		if err != nil {
+			return err
+		}
+
+		return s.chargeFee(tx, acc, trx, fee)
+	})
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	trx.ID = trxID
-	trx.CreatedAt = trxDate
+	s.written(s.fees.charged(fee, acc.Username)...)
 
 	return trx, nil
 }
 
-// DeductBalance implements EWalletSystem.
-func (s *simpleEWallet) DeductBalance(acc *Account, amount float64) (*Transactions, error) {
-	if !canDeductFund(acc, amount) {
-		return nil, ErrInsufficient
+// ListTransactions implements EWalletSystem.
+func (s *simpleEWallet) ListTransactions(acc *Account, limit int, beforeID int) ([]Transactions, error) {
+	q := `
+        SELECT
+            id, user_id, amount, type, parent_id, created_at
+        FROM transactions
+        WHERE
+            user_id = $1 AND ($2 = 0 OR id < $2)
+        ORDER BY id DESC
+        LIMIT $3
+    `
+
+	trxs := []Transactions{}
+	err := s.reader(acc.Username).Select(&trxs, q, acc.ID, beforeID, limit)
+	if err != nil {
+		return nil, err
 	}
 
+	return trxs, nil
+}
+
+// checkVersion locks the account and fails with ErrVersionMismatch when
+// it is not at version. A nil version is not checked.
+func checkVersion(tx *sqlx.Tx, accID int, version *int64) error {
+	if version == nil {
+		return nil
+	}
+
+	qVersion := `
+        SELECT
+            u.version + COALESCE((SELECT SUM(b.version) FROM balance_buckets b WHERE b.user_id = u.id), 0)::BIGINT
+        FROM users u
+        WHERE u.id = $1
+        FOR UPDATE OF u
+    `
+
+	var current int64
+	err := tx.Get(&current, qVersion, accID)
+	if err != nil {
+		return err
+	}
+	if current != *version {
+		return versionMismatch(current, *version)
+	}
+
+	return nil
+}
+
+func versionMismatch(current int64, expected int64) error {
+	return ErrVersionMismatch.Detailf("account is at version %d, not %d", current, expected)
+}
+
+// updateBalance applies delta (negative for deductions) to the balance,
+// returning the bucket it went to when the account is hot. A deduction
+// fails with ErrInsufficient when the committed balance does not cover
+// it, whatever balance the caller checked beforehand.
+func updateBalance(tx *sqlx.Tx, accID int, delta float64) (sql.NullInt32, error) {
 	qBalance := `
         UPDATE users
         SET
-            balance = balance - $1
+            balance = balance + $1,
+            version = version + 1
         WHERE
-            id = $2
+            id = $2 AND bucket_count = 0 AND ($1 >= 0 OR balance + $1 >= 0)
     `
-	tx, err := s.db.Beginx()
+
+	res, err := tx.Exec(qBalance, delta, accID)
 	if err != nil {
-		return nil, err
+		return noBucket, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 1 {
+		return noBucket, err
 	}
 
-	_, err = tx.Exec(qBalance, amount, acc.ID)
-	if err != nil {
-		tx.Rollback()
-		return nil, err
+	return updateBuckets(tx, accID, delta)
+}
+
+// recordTrx inserts the transaction record and publishes the matching
+// balance event to the outbox, all within the same transaction. The ID
+// and creation date are filled into trx.
+func recordTrx(tx *sqlx.Tx, trx *Transactions) error {
+	eventType := EventBalanceCredited
+	if trx.TrxType == TrxTypeDebit {
+		eventType = EventBalanceDebited
 	}
 
-	// if successful on adding balance, then create
-	// the transaction record
+	return recordTrxEvent(tx, trx, eventType)
+}
+
+// recordTrxEvent is recordTrx with an explicit event type. The balance of
+// the account was already updated, and locked, by the transaction.
+func recordTrxEvent(tx *sqlx.Tx, trx *Transactions, eventType string) error {
 	qTrx := `
         INSERT INTO transactions
-            (user_id, amount, type)
+            (user_id, amount, type, parent_id, bucket, balance_after)
         VALUES
-            ($1, $2, $3)
+            ($1, $2, $3, $4, $5, (SELECT balance FROM users WHERE id = $1 AND bucket_count = 0))
         RETURNING
-            id, created_at
+            id, amount, created_at, balance_after
     `
 
-	trx := &Transactions{
-		UserID:  acc.ID,
-		Amount:  amount,
-		TrxType: TrxTypeDebit,
+	// the stored amount is read back, the hash covers what is persisted
+	err := tx.QueryRow(qTrx, trx.UserID, trx.Amount, trx.TrxType, trx.ParentID, trx.Bucket).
+		Scan(&trx.ID, &trx.Amount, &trx.CreatedAt, &trx.BalanceAfter)
+	if err != nil {
+		return err
 	}
 
-	var trxID int
-	var trxDate sql.NullTime
-	err = tx.QueryRow(qTrx, trx.UserID, trx.Amount, trx.TrxType).Scan(&trxID, &trxDate)
+	err = chainTrx(tx, trx)
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return err
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return nil, err
+	return outbox.Write(tx, trx.UserID, eventType, BalanceChangedEvent{
+		AccountID:     trx.UserID,
+		TransactionID: trx.ID,
+		Amount:        trx.Amount,
+		Type:          trx.TrxType,
+		CreatedAt:     trx.CreatedAt.Time,
+	})
+}
+
+// BalanceAfter returns the balance of the account right after the
+// transaction, as written with it. A hot account records none, its
+// balance is then read back from the primary.
+func BalanceAfter(ewallet EWalletSystem, acc *Account, trx *Transactions) (float64, error) {
+	if trx.BalanceAfter.Valid {
+		return trx.BalanceAfter.Float64, nil
 	}
 
-	trx.ID = trxID
-	trx.CreatedAt = trxDate
+	user, err := ewallet.GetUser(acc.Username)
+	if err != nil {
+		return 0, err
+	}
 
-	return trx, nil
+	return user.Balance, nil
 }
 
 func canDeductFund(acc *Account, amount float64) bool {
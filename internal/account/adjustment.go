@@ -0,0 +1,27 @@
+package account
+
+import "github.com/jmoiron/sqlx"
+
+// RecordAdjustment records a ledger-only correction within the caller's
+// transaction: a credit (positive amount) or debit (negative amount)
+// transaction is written while the stored balance is left untouched.
+// This is used by the reconciliation to bring the ledger back in line
+// with the balance.
+func RecordAdjustment(tx *sqlx.Tx, accID int, amount float64) (*Transactions, error) {
+	trx := &Transactions{
+		UserID:  accID,
+		Amount:  amount,
+		TrxType: TrxTypeCredit,
+	}
+	if amount < 0 {
+		trx.Amount = -amount
+		trx.TrxType = TrxTypeDebit
+	}
+
+	err := recordTrxEvent(tx, trx, EventLedgerAdjusted)
+	if err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
@@ -0,0 +1,282 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidBatchItem = &Error{Code: CodeValidation, Message: "invalid batch item"}
+	ErrBatchAborted     = fmt.Errorf("account: batch aborted by another failed item")
+)
+
+// batch item types
+const (
+	BatchCredit   = "credit"
+	BatchDebit    = "debit"
+	BatchTransfer = "transfer"
+)
+
+// BatchItem is 1 money movement of a batch. TargetUsername is only used
+// by transfers.
+type BatchItem struct {
+	Type           string
+	Username       string
+	TargetUsername string
+	Amount         float64
+}
+
+// BatchResult is the outcome of the batch item with the same index. Debit
+// is set for debits and transfers, Credit for credits and transfers.
+type BatchResult struct {
+	Debit  *Transactions
+	Credit *Transactions
+	Err    error
+}
+
+// ExecuteBatch implements EWalletSystem.
+func (s *simpleEWallet) ExecuteBatch(items []BatchItem, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	for i, item := range items {
+		results[i].Err = validateBatchItem(item)
+	}
+	// the fees of the debits may have credited the house account
+	written := batchUsernames(items...)
+	if s.fees != nil {
+		written = append(written, s.fees.houseAccount)
+	}
+	defer s.written(written...)
+
+	if atomic {
+		return results, s.executeAtomic(items, results)
+	}
+
+	for i, item := range items {
+		if results[i].Err != nil {
+			continue
+		}
+
+		results[i], _ = s.executeItem(item)
+	}
+
+	return results, nil
+}
+
+// executeAtomic runs every item in 1 transaction. The first failed item
+// rolls the whole batch back, the other items are then ErrBatchAborted.
+func (s *simpleEWallet) executeAtomic(items []BatchItem, results []BatchResult) error {
+	failed := -1
+	for i := range results {
+		if results[i].Err != nil {
+			failed = i
+			break
+		}
+	}
+
+	if failed < 0 {
+		err := s.inTx("ExecuteBatch", func(tx *sqlx.Tx) error {
+			failed = -1
+			accounts, err := lockAccounts(tx, batchUsernames(items...))
+			if err != nil {
+				return err
+			}
+
+			for i, item := range items {
+				results[i] = s.applyBatchItem(tx, accounts, item)
+				if results[i].Err != nil {
+					failed = i
+					return results[i].Err
+				}
+			}
+
+			return nil
+		})
+		if err != nil && failed < 0 {
+			return err
+		}
+	}
+
+	if failed >= 0 {
+		for i := range results {
+			if i != failed {
+				results[i] = BatchResult{Err: ErrBatchAborted}
+			}
+		}
+	}
+
+	return nil
+}
+
+// executeItem runs 1 item in its own transaction
+func (s *simpleEWallet) executeItem(item BatchItem) (BatchResult, error) {
+	var result BatchResult
+	err := s.inTx("ExecuteBatch", func(tx *sqlx.Tx) error {
+		accounts, err := lockAccounts(tx, batchUsernames(item))
+		if err != nil {
+			return err
+		}
+
+		result = s.applyBatchItem(tx, accounts, item)
+		return result.Err
+	})
+	if err != nil {
+		return BatchResult{Err: err}, err
+	}
+
+	return result, nil
+}
+
+// applyBatchItem moves the money of the item. The accounts are locked and
+// their balances are kept up to date along the batch.
+func (s *simpleEWallet) applyBatchItem(tx *sqlx.Tx, accounts map[string]*Account, item BatchItem) BatchResult {
+	acc, ok := accounts[item.Username]
+	if !ok {
+		return BatchResult{Err: ErrNotFound}
+	}
+
+	switch item.Type {
+	case BatchCredit:
+		credit, err := Credit(tx, acc.ID, item.Amount)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+		acc.Balance += item.Amount
+
+		return BatchResult{Credit: credit}
+
+	case BatchDebit:
+		fee := s.fees.Calculate(FeeTrxWithdrawal, acc.Tier, item.Amount)
+		if !canDeductFund(acc, item.Amount+fee) {
+			return BatchResult{Err: ErrInsufficient}
+		}
+
+		debit, err := s.debit(tx, acc, item.Amount, fee)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+
+		return BatchResult{Debit: debit}
+
+	default:
+		target, ok := accounts[item.TargetUsername]
+		if !ok {
+			return BatchResult{Err: ErrNotFound}
+		}
+
+		fee := s.fees.Calculate(FeeTrxTransfer, acc.Tier, item.Amount)
+		if !canDeductFund(acc, item.Amount+fee) {
+			return BatchResult{Err: ErrInsufficient}
+		}
+
+		debit, err := s.debit(tx, acc, item.Amount, fee)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+
+		credit, err := Credit(tx, target.ID, item.Amount)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+		target.Balance += item.Amount
+
+		return BatchResult{Debit: debit, Credit: credit}
+	}
+}
+
+// debit deducts the amount and charges the fee on the locked account
+func (s *simpleEWallet) debit(tx *sqlx.Tx, acc *Account, amount, fee float64) (*Transactions, error) {
+	bucket, err := updateBalance(tx, acc.ID, -amount)
+	if err != nil {
+		return nil, err
+	}
+
+	trx := &Transactions{
+		UserID:  acc.ID,
+		Amount:  amount,
+		TrxType: TrxTypeDebit,
+		Bucket:  bucket,
+	}
+	err = recordTrx(tx, trx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.chargeFee(tx, acc, trx, fee)
+	if err != nil {
+		return nil, err
+	}
+	acc.Balance -= amount + fee
+
+	return trx, nil
+}
+
+// lockAccounts loads and locks the accounts, always in the same order
+// (lowest ID first) so concurrent batches cannot deadlock
+func lockAccounts(tx *sqlx.Tx, usernames []string) (map[string]*Account, error) {
+	q := `
+        SELECT
+            u.id, u.username, u.tier, u.created_at,
+            u.balance + COALESCE((SELECT SUM(b.balance) FROM balance_buckets b WHERE b.user_id = u.id), 0) AS balance,
+            u.version + COALESCE((SELECT SUM(b.version) FROM balance_buckets b WHERE b.user_id = u.id), 0)::BIGINT AS version
+        FROM users u
+        WHERE u.username = ANY($1)
+        ORDER BY u.id
+        FOR UPDATE OF u
+    `
+
+	locked := []Account{}
+	err := tx.Select(&locked, q, pq.Array(usernames))
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make(map[string]*Account, len(locked))
+	for i := range locked {
+		accounts[locked[i].Username] = &locked[i]
+	}
+
+	return accounts, nil
+}
+
+func batchUsernames(items ...BatchItem) []string {
+	seen := map[string]bool{}
+	for _, item := range items {
+		seen[item.Username] = true
+		if item.Type == BatchTransfer {
+			seen[item.TargetUsername] = true
+		}
+	}
+
+	usernames := make([]string, 0, len(seen))
+	for username := range seen {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	return usernames
+}
+
+func validateBatchItem(item BatchItem) error {
+	switch {
+	case item.Type != BatchCredit && item.Type != BatchDebit && item.Type != BatchTransfer:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidBatchItem, item.Type)
+
+	case usernameMessage(item.Username) != "":
+		return fmt.Errorf("%w: username %s", ErrInvalidBatchItem, usernameMessage(item.Username))
+
+	case amountMessage(item.Amount) != "":
+		return fmt.Errorf("%w: amount %s", ErrInvalidBatchItem, amountMessage(item.Amount))
+
+	case item.Type == BatchTransfer && usernameMessage(item.TargetUsername) != "":
+		return fmt.Errorf("%w: target username %s", ErrInvalidBatchItem, usernameMessage(item.TargetUsername))
+
+	case item.Type == BatchTransfer && item.TargetUsername == item.Username:
+		return errors.Join(ErrInvalidBatchItem, ErrSameAccount)
+	}
+
+	return nil
+}
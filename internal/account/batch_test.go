@@ -0,0 +1,48 @@
+package account
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateBatchItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		item    BatchItem
+		wantErr bool
+	}{
+		{"credit", BatchItem{Type: BatchCredit, Username: "alice", Amount: 10}, false},
+		{"transfer", BatchItem{Type: BatchTransfer, Username: "alice", TargetUsername: "bob", Amount: 10}, false},
+		{"unknown type", BatchItem{Type: "refund", Username: "alice", Amount: 10}, true},
+		{"no username", BatchItem{Type: BatchDebit, Amount: 10}, true},
+		{"negative amount", BatchItem{Type: BatchCredit, Username: "alice", Amount: -10}, true},
+		{"too many decimals", BatchItem{Type: BatchCredit, Username: "alice", Amount: 0.001}, true},
+		{"invalid target", BatchItem{Type: BatchTransfer, Username: "alice", TargetUsername: "bob smith", Amount: 10}, true},
+		{"transfer without target", BatchItem{Type: BatchTransfer, Username: "alice", Amount: 10}, true},
+		{"transfer to self", BatchItem{Type: BatchTransfer, Username: "alice", TargetUsername: "alice", Amount: 10}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBatchItem(tt.item)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateBatchItem() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidBatchItem) {
+				t.Errorf("validateBatchItem() = %v, want ErrInvalidBatchItem", err)
+			}
+		})
+	}
+}
+
+func TestBatchUsernames(t *testing.T) {
+	got := batchUsernames(
+		BatchItem{Type: BatchCredit, Username: "carol", TargetUsername: "ignored"},
+		BatchItem{Type: BatchTransfer, Username: "bob", TargetUsername: "alice"},
+		BatchItem{Type: BatchDebit, Username: "bob"},
+	)
+	want := []string{"alice", "bob", "carol"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("batchUsernames() = %v, want %v", got, want)
+	}
+}
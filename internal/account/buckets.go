@@ -0,0 +1,206 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"math/rand/v2"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// MaxBuckets is the most buckets a hot account may spread its balance on
+const MaxBuckets = 64
+
+var ErrInvalidBucketCount = &Error{Code: CodeValidation, Message: "bucket count must be within 0 and 64"}
+
+// noBucket is the bucket of the movements on the account row itself
+var noBucket = sql.NullInt32{}
+
+// updateBuckets applies delta to an account updateBalance left untouched:
+// a hot account or a deduction the plain account cannot cover. A credit
+// goes to a random bucket and only locks that bucket, a debit draws from
+// the account and then from the buckets, see drawBuckets. It returns the
+// credited bucket.
+func updateBuckets(tx *sqlx.Tx, accID int, delta float64) (sql.NullInt32, error) {
+	var count int
+	err := tx.Get(&count, `SELECT bucket_count FROM users WHERE id = $1`, accID)
+	if err != nil {
+		return noBucket, err
+	}
+
+	switch {
+	case count == 0 && delta < 0:
+		return noBucket, ErrInsufficient
+	case count == 0:
+		// turned back into a plain account meanwhile
+		_, err = tx.Exec(`UPDATE users SET balance = balance + $1, version = version + 1 WHERE id = $2`, delta, accID)
+		return noBucket, err
+	case delta < 0:
+		return noBucket, drawBuckets(tx, accID, -delta)
+	}
+
+	qBucket := `
+        UPDATE balance_buckets
+        SET
+            balance = balance + $1,
+            version = version + 1
+        WHERE
+            user_id = $2 AND bucket = $3
+    `
+	bucket := rand.IntN(count)
+	_, err = tx.Exec(qBucket, delta, accID, bucket)
+	if err != nil {
+		return noBucket, err
+	}
+
+	return sql.NullInt32{Int32: int32(bucket), Valid: true}, nil
+}
+
+// drawBuckets debits the amount from a hot account: from the balance of
+// the account row first, then from the buckets in order. The account row
+// is locked before the buckets, so the debits of the account run one at a
+// time while the credits go on in the other buckets.
+// It fails with ErrInsufficient when they do not cover the amount.
+func drawBuckets(tx *sqlx.Tx, accID int, amount float64) error {
+	var main float64
+	err := tx.Get(&main, `UPDATE users SET version = version + 1 WHERE id = $1 RETURNING balance`, accID)
+	if err != nil {
+		return err
+	}
+
+	buckets := []bucketBalance{}
+	qBuckets := `
+        SELECT
+            bucket, balance
+        FROM balance_buckets
+        WHERE user_id = $1
+        ORDER BY bucket
+        FOR UPDATE
+    `
+	err = tx.Select(&buckets, qBuckets, accID)
+	if err != nil {
+		return err
+	}
+
+	fromMain, drawn, takes, err := planDraw(main, buckets, amount)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET balance = balance - $1 WHERE id = $2`, fromMain, accID)
+	if err != nil || len(drawn) == 0 {
+		return err
+	}
+
+	qDraw := `
+        UPDATE balance_buckets b
+        SET
+            balance = b.balance - d.take,
+            version = b.version + 1
+        FROM unnest($2::INTEGER[], $3::NUMERIC[]) AS d(bucket, take)
+        WHERE
+            b.user_id = $1 AND b.bucket = d.bucket
+    `
+	_, err = tx.Exec(qDraw, accID, pq.Array(drawn), pq.Array(takes))
+	return err
+}
+
+type bucketBalance struct {
+	Bucket  int     `db:"bucket"`
+	Balance float64 `db:"balance"`
+}
+
+// planDraw splits the debit of amount between the account row holding
+// main and the buckets, in order. It fails with ErrInsufficient when the
+// account and the buckets together do not cover the amount, so the
+// account row never pays for the buckets.
+func planDraw(main float64, buckets []bucketBalance, amount float64) (fromMain float64, drawn []int64, takes []float64, err error) {
+	total := main
+	for _, b := range buckets {
+		total += b.Balance
+	}
+	if cents(total) < cents(amount) {
+		return 0, nil, nil, ErrInsufficient
+	}
+
+	remaining := cents(amount - math.Max(main, 0))
+	fromMain = cents(amount)
+	for _, b := range buckets {
+		if remaining <= 0 {
+			break
+		}
+		if b.Balance <= 0 {
+			continue
+		}
+
+		take := math.Min(b.Balance, remaining)
+		drawn = append(drawn, int64(b.Bucket))
+		takes = append(takes, take)
+		remaining = cents(remaining - take)
+		fromMain = cents(fromMain - take)
+	}
+
+	return fromMain, drawn, takes, nil
+}
+
+// SetBuckets spreads the credits of the account over count buckets, 0
+// turns the hot account back into a plain one. The balances of the
+// buckets are moved onto the account row, the total is unchanged. The
+// bucket rows are kept, they hold the heads of their hash chains.
+func SetBuckets(ctx context.Context, db *sqlx.DB, txs *TxPolicy, username string, count int) error {
+	if count < 0 || count > MaxBuckets {
+		return ErrInvalidBucketCount
+	}
+
+	return txs.InTx(ctx, db, "SetBuckets", func(tx *sqlx.Tx) error {
+		// lock the account then every bucket, like a debit
+		var accID int
+		err := tx.GetContext(ctx, &accID, `SELECT id FROM users WHERE username = $1 FOR UPDATE`, username)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errors.Join(ErrNotFound.Detailf("user not found"), err)
+			}
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `SELECT 1 FROM balance_buckets WHERE user_id = $1 ORDER BY bucket FOR UPDATE`, accID)
+		if err != nil {
+			return err
+		}
+
+		qMove := `
+            UPDATE users
+            SET
+                balance = balance + COALESCE((SELECT SUM(balance) FROM balance_buckets WHERE user_id = $1), 0),
+                bucket_count = $2
+            WHERE
+                id = $1
+        `
+		_, err = tx.ExecContext(ctx, qMove, accID, count)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE balance_buckets SET balance = 0 WHERE user_id = $1`, accID)
+		if err != nil {
+			return err
+		}
+
+		qCreate := `
+            INSERT INTO balance_buckets
+                (user_id, bucket)
+            SELECT
+                $1, generate_series(0, $2 - 1)
+            ON CONFLICT DO NOTHING
+        `
+		_, err = tx.ExecContext(ctx, qCreate, accID, count)
+		return err
+	})
+}
+
+// cents rounds away the float error of the sums of 2 decimal amounts
+func cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
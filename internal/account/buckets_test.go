@@ -0,0 +1,80 @@
+package account
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanDraw(t *testing.T) {
+	buckets := []bucketBalance{
+		{Bucket: 0, Balance: 100},
+		{Bucket: 1, Balance: 0},
+		{Bucket: 2, Balance: 250.5},
+		{Bucket: 3, Balance: 40},
+	}
+
+	tests := []struct {
+		name      string
+		main      float64
+		amount    float64
+		wantMain  float64
+		wantDrawn []int64
+		wantTakes []float64
+		wantErr   error
+	}{
+		{
+			name:     "test_main_covers",
+			main:     500,
+			amount:   300,
+			wantMain: 300,
+		},
+		{
+			name:      "test_spill_to_buckets",
+			main:      50,
+			amount:    200.25,
+			wantMain:  50,
+			wantDrawn: []int64{0, 2},
+			wantTakes: []float64{100, 50.25},
+		},
+		{
+			name:      "test_negative_main",
+			main:      -10,
+			amount:    120,
+			wantMain:  0,
+			wantDrawn: []int64{0, 2},
+			wantTakes: []float64{100, 20},
+		},
+		{
+			name:      "test_drain_everything",
+			main:      9.5,
+			amount:    400,
+			wantMain:  9.5,
+			wantDrawn: []int64{0, 2, 3},
+			wantTakes: []float64{100, 250.5, 40},
+		},
+		{
+			name:    "test_buckets_short",
+			main:    9.5,
+			amount:  400.01,
+			wantErr: ErrInsufficient,
+		},
+		{
+			name:    "test_negative_main_short",
+			main:    -100,
+			amount:  350,
+			wantErr: ErrInsufficient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fromMain, drawn, takes, err := planDraw(tt.main, buckets, tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("planDraw() error = %v, want %v", err, tt.wantErr)
+			}
+			if fromMain != tt.wantMain || !reflect.DeepEqual(drawn, tt.wantDrawn) || !reflect.DeepEqual(takes, tt.wantTakes) {
+				t.Errorf("planDraw() = %v, %v, %v, want %v, %v, %v", fromMain, drawn, takes, tt.wantMain, tt.wantDrawn, tt.wantTakes)
+			}
+		})
+	}
+}
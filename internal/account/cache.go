@@ -0,0 +1,188 @@
+package account
+
+import (
+	"encoding/json"
+	"expvar"
+	"hash/fnv"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/cache"
+)
+
+const (
+	defaultCacheTTL       = 30 * time.Second
+	defaultCacheStaleness = time.Second
+	defaultCacheEntries   = 10000
+
+	// cacheEpochs is the number of invalidation counters the usernames are
+	// hashed on
+	cacheEpochs = 256
+)
+
+// the lookups of the account cache, exported on /debug/vars
+var (
+	metricCacheHits   = expvar.NewInt("account_cache_hits")
+	metricCacheMisses = expvar.NewInt("account_cache_misses")
+	metricCacheErrors = expvar.NewInt("account_cache_errors")
+)
+
+func init() {
+	expvar.Publish("account_cache_hit_ratio", expvar.Func(func() any {
+		hits, misses := metricCacheHits.Value(), metricCacheMisses.Value()
+		if hits+misses == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(hits+misses)
+	}))
+}
+
+// BalanceCache caches the accounts of ReadUser by username. The writes of
+// the EWalletSystem invalidate the accounts they changed once committed,
+// the cached accounts are otherwise served up to the staleness bound. The
+// cached accounts are never used to move money.
+type BalanceCache struct {
+	store        cache.Store
+	ttl          time.Duration
+	maxStaleness time.Duration
+
+	// epochs count the invalidations, an account read while its epoch
+	// moved may predate the write and is not cached
+	epochs [cacheEpochs]atomic.Uint64
+
+	// now is replaced by the tests
+	now func() time.Time
+}
+
+// cachedAccount is the stored value, CachedAt is when it was read from
+// the database
+type cachedAccount struct {
+	Account  *Account  `json:"account"`
+	CachedAt time.Time `json:"cached_at"`
+}
+
+// NewBalanceCache caches the accounts in the store, nil for an in process
+// LRU
+func NewBalanceCache(store cache.Store, cfg config.CacheConfig) *BalanceCache {
+	c := &BalanceCache{
+		store:        store,
+		ttl:          time.Duration(cfg.TTLSeconds) * time.Second,
+		maxStaleness: time.Duration(cfg.MaxStalenessMs) * time.Millisecond,
+		now:          time.Now,
+	}
+	if c.store == nil {
+		entries := cfg.MaxEntries
+		if entries <= 0 {
+			entries = defaultCacheEntries
+		}
+		c.store = cache.NewLRU(entries)
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultCacheTTL
+	}
+	if c.maxStaleness <= 0 {
+		c.maxStaleness = defaultCacheStaleness
+	}
+	// the staleness bound is checked on the stored entries
+	if c.maxStaleness > c.ttl {
+		c.maxStaleness = c.ttl
+	}
+
+	return c
+}
+
+// WithBalanceCache serves ReadUser from the cache, GetUser refreshes it
+func WithBalanceCache(c *BalanceCache) Option {
+	return func(s *simpleEWallet) {
+		s.cache = c
+	}
+}
+
+// load returns the cached account when it is younger than the staleness
+// bound, otherwise the one of fetch, which is then cached. A failing store
+// is skipped, the account is read from the database.
+func (c *BalanceCache) load(username string, fetch func() (*Account, error)) (*Account, error) {
+	acc, ok := c.get(username)
+	if ok {
+		metricCacheHits.Add(1)
+		return acc, nil
+	}
+	metricCacheMisses.Add(1)
+
+	return c.refresh(username, fetch)
+}
+
+// refresh returns the account of fetch and caches it
+func (c *BalanceCache) refresh(username string, fetch func() (*Account, error)) (*Account, error) {
+	epoch := c.epoch(username).Load()
+	cachedAt := c.now()
+	acc, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.epoch(username).Load() == epoch {
+		c.set(username, acc, cachedAt)
+	}
+
+	return acc, nil
+}
+
+func (c *BalanceCache) get(username string) (*Account, bool) {
+	raw, ok, err := c.store.Get(cacheKey(username))
+	if err != nil {
+		metricCacheErrors.Add(1)
+		slog.Warn("account cache read failed", "username", username, "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	var entry cachedAccount
+	err = json.Unmarshal(raw, &entry)
+	if err != nil || entry.Account == nil || c.now().Sub(entry.CachedAt) >= c.maxStaleness {
+		return nil, false
+	}
+
+	return entry.Account, true
+}
+
+func (c *BalanceCache) set(username string, acc *Account, cachedAt time.Time) {
+	raw, err := json.Marshal(cachedAccount{Account: acc, CachedAt: cachedAt})
+	if err == nil {
+		err = c.store.Set(cacheKey(username), raw, c.ttl)
+	}
+	if err != nil {
+		metricCacheErrors.Add(1)
+		slog.Warn("account cache write failed", "username", username, "error", err)
+	}
+}
+
+// invalidate drops the accounts, their next read goes to the database. An
+// account the store failed to drop is served until the staleness bound.
+func (c *BalanceCache) invalidate(usernames ...string) {
+	keys := make([]string, len(usernames))
+	for i, username := range usernames {
+		c.epoch(username).Add(1)
+		keys[i] = cacheKey(username)
+	}
+
+	err := c.store.Delete(keys...)
+	if err != nil {
+		metricCacheErrors.Add(1)
+		slog.Warn("account cache invalidation failed", "usernames", usernames, "error", err)
+	}
+}
+
+func (c *BalanceCache) epoch(username string) *atomic.Uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(username))
+	return &c.epochs[h.Sum32()%cacheEpochs]
+}
+
+func cacheKey(username string) string {
+	return "account:" + username
+}
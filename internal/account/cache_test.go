@@ -0,0 +1,170 @@
+package account
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+)
+
+// fakeStore is a local stand-in for a shared store like Redis, it keeps
+// copies of the bytes and fails every call while err is set
+type fakeStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	err    error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{values: map[string][]byte{}}
+}
+
+func (f *fakeStore) Get(key string) ([]byte, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	v, ok := f.values[key]
+	return append([]byte(nil), v...), ok, nil
+}
+
+func (f *fakeStore) Set(key string, value []byte, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	f.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (f *fakeStore) Delete(keys ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	for _, key := range keys {
+		delete(f.values, key)
+	}
+	return nil
+}
+
+// countingFetch returns the account with the balance of the number of
+// fetches so far
+func countingFetch(fetches *int) func() (*Account, error) {
+	return func() (*Account, error) {
+		*fetches++
+		return &Account{ID: 1, Username: "alice", Balance: float64(*fetches)}, nil
+	}
+}
+
+func newTestCache(store *fakeStore, now *time.Time) *BalanceCache {
+	c := NewBalanceCache(store, config.CacheConfig{TTLSeconds: 30, MaxStalenessMs: 1000})
+	c.now = func() time.Time { return *now }
+	return c
+}
+
+func TestBalanceCacheStaleness(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newTestCache(newFakeStore(), &now)
+
+	fetches := 0
+	fetch := countingFetch(&fetches)
+	c.load("alice", fetch)
+
+	acc, _ := c.load("alice", fetch)
+	if fetches != 1 || acc.Balance != 1 {
+		t.Fatalf("fetches = %d, balance = %v, want the cached account", fetches, acc.Balance)
+	}
+
+	// past the staleness bound but within the TTL
+	now = now.Add(2 * time.Second)
+	acc, _ = c.load("alice", fetch)
+	if fetches != 2 || acc.Balance != 2 {
+		t.Errorf("fetches = %d, balance = %v, want a reload", fetches, acc.Balance)
+	}
+}
+
+func TestBalanceCacheRefresh(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newTestCache(newFakeStore(), &now)
+
+	fetches := 0
+	fetch := countingFetch(&fetches)
+	c.load("alice", fetch)
+
+	// the write path always reads, and the reads get its account
+	acc, _ := c.refresh("alice", fetch)
+	if fetches != 2 || acc.Balance != 2 {
+		t.Fatalf("refresh() fetched %d times, want a read", fetches)
+	}
+	acc, _ = c.load("alice", fetch)
+	if fetches != 2 || acc.Balance != 2 {
+		t.Errorf("load() = %v after %d fetches, want the refreshed account", acc.Balance, fetches)
+	}
+}
+
+func TestBalanceCacheInvalidate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newTestCache(newFakeStore(), &now)
+
+	fetches := 0
+	fetch := countingFetch(&fetches)
+	c.load("alice", fetch)
+	c.invalidate("alice")
+
+	acc, _ := c.load("alice", fetch)
+	if fetches != 2 || acc.Balance != 2 {
+		t.Errorf("fetches = %d after the invalidation, want a reload", fetches)
+	}
+}
+
+func TestBalanceCacheSkipsReadsRacingAWrite(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	c := newTestCache(store, &now)
+
+	// the write commits and invalidates while the read is in flight, the
+	// account read may predate the write
+	_, err := c.load("alice", func() (*Account, error) {
+		c.invalidate("alice")
+		return &Account{Username: "alice", Balance: 1}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.values) != 0 {
+		t.Errorf("the racing read was cached: %v", store.values)
+	}
+}
+
+func TestBalanceCacheStoreDown(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	store.err = errors.New("connection refused")
+	c := newTestCache(store, &now)
+
+	fetches := 0
+	acc, err := c.load("alice", countingFetch(&fetches))
+	if err != nil || acc.Balance != 1 {
+		t.Errorf("load() = %v, %v, want the account of the database", acc, err)
+	}
+}
+
+func TestBalanceCacheFetchError(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	c := newTestCache(store, &now)
+
+	_, err := c.load("bob", func() (*Account, error) { return nil, ErrNotFound })
+	if !errors.Is(err, ErrNotFound) || len(store.values) != 0 {
+		t.Errorf("load() = %v with %d cached, want the error uncached", err, len(store.values))
+	}
+}
@@ -0,0 +1,128 @@
+package account
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ChainHash returns the hash of the transaction chained to the hash of the
+// previous transaction of the same account ("" for the first one). The
+// format is mirrored by the hash chain migration, change both together.
+func ChainHash(trx *Transactions, prevHash string) string {
+	parentID := ""
+	if trx.ParentID.Valid {
+		parentID = strconv.FormatInt(trx.ParentID.Int64, 10)
+	}
+
+	content := fmt.Sprintf("v1|%d|%d|%s|%s|%s|%d|%s",
+		trx.ID,
+		trx.UserID,
+		strconv.FormatFloat(trx.Amount, 'f', 2, 64),
+		trx.TrxType,
+		parentID,
+		trx.CreatedAt.Time.UnixMicro(),
+		prevHash,
+	)
+
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// chainTrx links the freshly inserted transaction to the chain of its
+// account, or of its bucket. The account row is locked so the chain stays
+// linear even for writes that leave the balance untouched.
+func chainTrx(tx *sqlx.Tx, trx *Transactions) error {
+	prevHash, err := lockChainHead(tx, trx.UserID, trx.Bucket)
+	if err != nil {
+		return err
+	}
+
+	trx.PrevHash = prevHash
+	trx.Hash = ChainHash(trx, prevHash)
+
+	qTrx := `
+        UPDATE transactions
+        SET
+            prev_hash = NULLIF($2, ''),
+            hash = $3
+        WHERE
+            id = $1
+    `
+	_, err = tx.Exec(qTrx, trx.ID, trx.PrevHash, trx.Hash)
+	if err != nil {
+		return err
+	}
+
+	return setChainHead(tx, trx.UserID, trx.Bucket, trx.Hash)
+}
+
+// chainTrxs links the freshly inserted transactions of 1 account, or of 1
+// bucket of it, to their chain, in the order of trxs, with 1 update for
+// all of them
+func chainTrxs(tx *sqlx.Tx, accID int, bucket sql.NullInt32, trxs []*Transactions) error {
+	prevHash, err := lockChainHead(tx, accID, bucket)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(trxs))
+	prevHashes := make([]string, len(trxs))
+	hashes := make([]string, len(trxs))
+	for i, trx := range trxs {
+		trx.PrevHash = prevHash
+		trx.Hash = ChainHash(trx, prevHash)
+		prevHash = trx.Hash
+
+		ids[i], prevHashes[i], hashes[i] = int64(trx.ID), trx.PrevHash, trx.Hash
+	}
+
+	qTrx := `
+        UPDATE transactions trx
+        SET
+            prev_hash = NULLIF(c.prev_hash, ''),
+            hash = c.hash
+        FROM unnest($1::INTEGER[], $2::TEXT[], $3::TEXT[]) AS c(id, prev_hash, hash)
+        WHERE
+            trx.id = c.id
+    `
+	_, err = tx.Exec(qTrx, pq.Array(ids), pq.Array(prevHashes), pq.Array(hashes))
+	if err != nil {
+		return err
+	}
+
+	return setChainHead(tx, accID, bucket, prevHash)
+}
+
+// lockChainHead locks the account, or only the bucket of a credit to a hot
+// account, and returns the hash of its last transaction, "" when it has
+// none
+func lockChainHead(tx *sqlx.Tx, accID int, bucket sql.NullInt32) (string, error) {
+	var head *string
+	var err error
+	if bucket.Valid {
+		err = tx.Get(&head, `SELECT chain_head FROM balance_buckets WHERE user_id = $1 AND bucket = $2 FOR UPDATE`, accID, bucket.Int32)
+	} else {
+		err = tx.Get(&head, `SELECT chain_head FROM users WHERE id = $1 FOR UPDATE`, accID)
+	}
+	if err != nil || head == nil {
+		return "", err
+	}
+
+	return *head, nil
+}
+
+func setChainHead(tx *sqlx.Tx, accID int, bucket sql.NullInt32, hash string) error {
+	if bucket.Valid {
+		_, err := tx.Exec(`UPDATE balance_buckets SET chain_head = $3 WHERE user_id = $1 AND bucket = $2`, accID, bucket.Int32, hash)
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE users SET chain_head = $2 WHERE id = $1`, accID, hash)
+	return err
+}
@@ -0,0 +1,99 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Code is the stable, machine readable code of a domain error. Clients
+// rely on it, existing codes must never change.
+type Code string
+
+const (
+	CodeNotFound          Code = "not_found"
+	CodeInsufficientFunds Code = "insufficient_funds"
+	CodeValidation        Code = "validation_failed"
+	CodeVersionMismatch   Code = "version_mismatch"
+	CodeBusy              Code = "account_busy"
+)
+
+// Error is an expected failure of an account operation, as opposed to an
+// infrastructure failure
+type Error struct {
+	Code    Code
+	Message string
+
+	// base is the sentinel this error details, see Detailf
+	base *Error
+}
+
+func (e *Error) Error() string {
+	return "account: " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	if e.base == nil {
+		return nil
+	}
+	return e.base
+}
+
+// Detailf returns the error with a more specific message, it still
+// matches the sentinel with errors.Is
+func (e *Error) Detailf(format string, args ...any) *Error {
+	return &Error{
+		Code:    e.Code,
+		Message: fmt.Sprintf(format, args...),
+		base:    e,
+	}
+}
+
+var (
+	ErrInsufficient = &Error{Code: CodeInsufficientFunds, Message: "insufficient funds"}
+	ErrNotFound     = &Error{Code: CodeNotFound, Message: "data not found"}
+	ErrSameAccount  = &Error{Code: CodeValidation, Message: "cannot transfer to the same account"}
+
+	// ErrVersionMismatch means the account changed since the caller read it
+	ErrVersionMismatch = &Error{Code: CodeVersionMismatch, Message: "account version mismatch"}
+
+	// ErrAccountBusy rejects a write when too many writes of the account
+	// are pending, the caller may retry later
+	ErrAccountBusy = &Error{Code: CodeBusy, Message: "account busy"}
+)
+
+// FieldError is 1 invalid field of an input
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError reports every invalid field of an input
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+
+	return "account: invalid input: " + strings.Join(msgs, ", ")
+}
+
+// CodeOf returns the code of the domain error in the chain of err, or ""
+// when err is not a domain error
+func CodeOf(err error) Code {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return CodeValidation
+	}
+
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Code
+	}
+
+	return ""
+}
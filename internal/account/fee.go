@@ -0,0 +1,129 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+)
+
+// transaction types the fee rules apply to
+const (
+	FeeTrxWithdrawal = "withdrawal"
+	FeeTrxTransfer   = "transfer"
+)
+
+// FeeEngine computes the fee of a debit from the configured rules
+type FeeEngine struct {
+	houseAccount string
+	rules        []config.FeeRule
+}
+
+func NewFeeEngine(cfg config.FeeConfig) *FeeEngine {
+	return &FeeEngine{
+		houseAccount: cfg.HouseAccount,
+		rules:        cfg.Rules,
+	}
+}
+
+// Calculate returns the fee for the amount, 0 when no rule matches
+func (e *FeeEngine) Calculate(trxType string, tier string, amount float64) float64 {
+	if e == nil {
+		return 0
+	}
+
+	for _, rule := range e.rules {
+		if rule.TrxType != "" && rule.TrxType != trxType {
+			continue
+		}
+		if rule.Tier != "" && rule.Tier != tier {
+			continue
+		}
+
+		return applyFeeRule(rule, amount)
+	}
+
+	return 0
+}
+
+func applyFeeRule(rule config.FeeRule, amount float64) float64 {
+	flat, pct := rule.Flat, rule.Percentage
+	for _, tier := range rule.Tiers {
+		if tier.UpTo == 0 || amount <= tier.UpTo {
+			flat, pct = tier.Flat, tier.Percentage
+			break
+		}
+	}
+
+	fee := flat + amount*pct/100
+	if rule.Min > 0 && fee < rule.Min {
+		fee = rule.Min
+	}
+	if rule.Max > 0 && fee > rule.Max {
+		fee = rule.Max
+	}
+
+	// balances are stored as DECIMAL(15, 2)
+	return math.Round(fee*100) / 100
+}
+
+// QuoteFee implements EWalletSystem.
+func (s *simpleEWallet) QuoteFee(acc *Account, trxType string, amount float64) float64 {
+	return s.fees.Calculate(trxType, acc.Tier, amount)
+}
+
+// charged returns the usernames written by a movement charging the fee,
+// with the house account when the fee is not 0
+func (e *FeeEngine) charged(fee float64, usernames ...string) []string {
+	if e == nil || fee == 0 {
+		return usernames
+	}
+
+	return append(usernames, e.houseAccount)
+}
+
+// chargeFee moves the fee from the account to the house account. Both
+// sides are recorded as their own transaction, linked to the principal.
+// The principal gets the charged fee, and the balance after it, filled in.
+func (s *simpleEWallet) chargeFee(tx *sqlx.Tx, acc *Account, principal *Transactions, fee float64) error {
+	if fee <= 0 {
+		return nil
+	}
+
+	var houseID int
+	err := tx.Get(&houseID, `SELECT id FROM users WHERE username = $1`, s.fees.houseAccount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoHouse
+		}
+		return err
+	}
+
+	parentID := sql.NullInt64{Int64: int64(principal.ID), Valid: true}
+	legs := []*Transactions{
+		{UserID: acc.ID, Amount: fee, TrxType: TrxTypeDebit, ParentID: parentID},
+		{UserID: houseID, Amount: fee, TrxType: TrxTypeCredit, ParentID: parentID},
+	}
+	for _, leg := range legs {
+		delta := leg.Amount
+		if leg.TrxType == TrxTypeDebit {
+			delta = -delta
+		}
+
+		leg.Bucket, err = updateBalance(tx, leg.UserID, delta)
+		if err != nil {
+			return err
+		}
+
+		err = recordTrx(tx, leg)
+		if err != nil {
+			return err
+		}
+	}
+
+	principal.Fee = fee
+	principal.BalanceAfter = legs[0].BalanceAfter
+	return nil
+}
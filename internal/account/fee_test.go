@@ -0,0 +1,105 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/yeyee2901/test/config"
+)
+
+func TestFeeEngine_Calculate(t *testing.T) {
+	engine := NewFeeEngine(config.FeeConfig{
+		HouseAccount: "fee_house",
+		Rules: []config.FeeRule{
+			{
+				TrxType: FeeTrxWithdrawal,
+				Tier:    "premium",
+			},
+			{
+				TrxType: FeeTrxWithdrawal,
+				Tiers: []config.FeeTier{
+					{UpTo: 100000, Flat: 2500},
+					{UpTo: 0, Percentage: 0.5},
+				},
+				Max: 10000,
+			},
+			{
+				TrxType:    FeeTrxTransfer,
+				Percentage: 0.1,
+				Min:        1000,
+				Max:        5000,
+			},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		trxType string
+		tier    string
+		amount  float64
+		want    float64
+	}{
+		{
+			name:    "test_premium_free",
+			trxType: FeeTrxWithdrawal,
+			tier:    "premium",
+			amount:  50000,
+			want:    0,
+		},
+		{
+			name:    "test_tier_flat",
+			trxType: FeeTrxWithdrawal,
+			tier:    "standard",
+			amount:  100000,
+			want:    2500,
+		},
+		{
+			name:    "test_tier_percentage",
+			trxType: FeeTrxWithdrawal,
+			tier:    "standard",
+			amount:  1000000,
+			want:    5000,
+		},
+		{
+			name:    "test_tier_percentage_capped",
+			trxType: FeeTrxWithdrawal,
+			tier:    "standard",
+			amount:  5000000,
+			want:    10000,
+		},
+		{
+			name:    "test_percentage_min",
+			trxType: FeeTrxTransfer,
+			tier:    "standard",
+			amount:  10000,
+			want:    1000,
+		},
+		{
+			name:    "test_percentage_rounded",
+			trxType: FeeTrxTransfer,
+			tier:    "standard",
+			amount:  1234567.89,
+			want:    1234.57,
+		},
+		{
+			name:    "test_no_rule",
+			trxType: "other",
+			tier:    "standard",
+			amount:  10000,
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := engine.Calculate(tt.trxType, tt.tier, tt.amount); got != tt.want {
+				t.Fatalf("Calculate() mismatch. Want %v; got %v", tt.want, got)
+			}
+		})
+	}
+
+	// no engine configured means no fee
+	var noFees *FeeEngine
+	if got := noFees.Calculate(FeeTrxWithdrawal, "standard", 10000); got != 0 {
+		t.Fatal("nil engine should not charge, got", got)
+	}
+}
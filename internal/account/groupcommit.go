@@ -0,0 +1,271 @@
+package account
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/outbox"
+)
+
+const (
+	defaultGroupCommitWindow = 5 * time.Millisecond
+	defaultGroupCommitSize   = 100
+)
+
+// the committed batches and the credits in them, exported on /debug/vars
+var (
+	metricGroupCommitBatches = expvar.NewInt("account_group_commit_batches")
+	metricGroupCommitCredits = expvar.NewInt("account_group_commit_credits")
+)
+
+// groupCommit coalesces the concurrent credits of an account into 1
+// database transaction. The first credit of an account opens a batch, the
+// batch is committed when the window elapses or it is full.
+type groupCommit struct {
+	window  time.Duration
+	maxSize int
+
+	// commit writes the credits of the batch, replaced by the tests
+	commit func(acc *Account, credits []*pendingCredit) error
+
+	mu      sync.Mutex
+	pending map[int]*creditBatch
+}
+
+type creditBatch struct {
+	acc     *Account
+	credits []*pendingCredit
+}
+
+// pendingCredit is the credit of 1 caller, trx is set once the batch is
+// committed
+type pendingCredit struct {
+	amount float64
+	trx    *Transactions
+	done   chan error
+}
+
+// WithGroupCommit coalesces the concurrent credits of an account made by
+// AddBalance. Every caller still gets its own transaction, and all of them
+// fail when their batch fails.
+func WithGroupCommit(cfg config.GroupCommitConfig) Option {
+	return func(s *simpleEWallet) {
+		g := &groupCommit{
+			window:  time.Duration(cfg.WindowMs) * time.Millisecond,
+			maxSize: cfg.MaxBatchSize,
+			commit:  s.commitCredits,
+			pending: map[int]*creditBatch{},
+		}
+		if g.window <= 0 {
+			g.window = defaultGroupCommitWindow
+		}
+		if g.maxSize <= 0 {
+			g.maxSize = defaultGroupCommitSize
+		}
+
+		s.credits = g
+	}
+}
+
+// add joins the credit to the open batch of the account and waits for the
+// batch to be committed
+func (g *groupCommit) add(acc *Account, amount float64) (*Transactions, error) {
+	credit := &pendingCredit{amount: amount, done: make(chan error, 1)}
+
+	g.mu.Lock()
+	batch, ok := g.pending[acc.ID]
+	if !ok {
+		batch = &creditBatch{acc: acc}
+		g.pending[acc.ID] = batch
+		time.AfterFunc(g.window, func() { g.flush(batch) })
+	}
+	batch.credits = append(batch.credits, credit)
+
+	full := len(batch.credits) >= g.maxSize
+	if full {
+		delete(g.pending, acc.ID)
+	}
+	g.mu.Unlock()
+
+	// the caller filling the batch commits it, instead of the timer
+	if full {
+		g.run(batch)
+	}
+
+	err := <-credit.done
+	if err != nil {
+		return nil, err
+	}
+
+	return credit.trx, nil
+}
+
+// flush commits the batch at the end of its window, unless it was
+// committed already because it was full
+func (g *groupCommit) flush(batch *creditBatch) {
+	g.mu.Lock()
+	open := g.pending[batch.acc.ID] == batch
+	if open {
+		delete(g.pending, batch.acc.ID)
+	}
+	g.mu.Unlock()
+
+	if open {
+		g.run(batch)
+	}
+}
+
+func (g *groupCommit) run(batch *creditBatch) {
+	err := g.commit(batch.acc, batch.credits)
+
+	metricGroupCommitBatches.Add(1)
+	metricGroupCommitCredits.Add(int64(len(batch.credits)))
+	for _, credit := range batch.credits {
+		credit.done <- err
+	}
+}
+
+// commitCredits writes the credits of the account in 1 transaction
+func (s *simpleEWallet) commitCredits(acc *Account, credits []*pendingCredit) error {
+	err := s.inTx("AddBalance", func(tx *sqlx.Tx) error {
+		return creditMany(tx, acc.ID, credits)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.written(acc.Username)
+	return nil
+}
+
+// creditMany is Credit for many credits of 1 account, with 1 balance
+// update and 1 insert of all the transactions
+func creditMany(tx *sqlx.Tx, accID int, credits []*pendingCredit) error {
+	amounts := make([]float64, len(credits))
+	total := 0.0
+	for i, credit := range credits {
+		amounts[i] = credit.amount
+		total += credit.amount
+	}
+	// the amounts have 2 decimals, the float sum may not
+	total = math.Round(total*100) / 100
+
+	qBalance := `
+        UPDATE users
+        SET
+            balance = balance + $1,
+            version = version + $2
+        WHERE
+            id = $3 AND bucket_count = 0
+    `
+	res, err := tx.Exec(qBalance, total, len(credits), accID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// the whole batch of a hot account goes to 1 bucket
+	bucket := noBucket
+	if n != 1 {
+		bucket, err = updateBuckets(tx, accID, total)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the IDs are drawn first so the rows can be joined back to their
+	// ordinal, the running balance of every credit is the updated balance
+	// minus the credits after it
+	qTrx := `
+        WITH c AS (
+            SELECT
+                nextval(pg_get_serial_sequence('transactions', 'id')) AS id, c.amount, c.n
+            FROM unnest($3::NUMERIC[]) WITH ORDINALITY AS c(amount, n)
+        ), inserted AS (
+            INSERT INTO transactions
+                (id, user_id, amount, type, bucket, balance_after)
+            SELECT
+                c.id, $1, c.amount, $2, $4,
+                (SELECT balance FROM users WHERE id = $1 AND bucket_count = 0)
+                - COALESCE(SUM(c.amount) OVER (ORDER BY c.id DESC ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING), 0)
+            FROM c
+            RETURNING
+                id, amount, created_at, balance_after
+        )
+        SELECT
+            i.id, i.amount, i.created_at, i.balance_after, c.n
+        FROM inserted i
+        JOIN c ON c.id = i.id
+        ORDER BY i.id
+    `
+
+	rows := []creditRow{}
+	err = tx.Select(&rows, qTrx, accID, TrxTypeCredit, pq.Array(amounts), bucket)
+	if err != nil {
+		return err
+	}
+
+	// the chain follows the IDs
+	chain := make([]*Transactions, len(rows))
+	for i := range rows {
+		rows[i].UserID = accID
+		rows[i].TrxType = TrxTypeCredit
+		rows[i].Bucket = bucket
+		chain[i] = &rows[i].Transactions
+	}
+
+	err = chainTrxs(tx, accID, bucket, chain)
+	if err != nil {
+		return err
+	}
+
+	for _, trx := range chain {
+		err = outbox.Write(tx, accID, EventBalanceCredited, BalanceChangedEvent{
+			AccountID:     accID,
+			TransactionID: trx.ID,
+			Amount:        trx.Amount,
+			Type:          trx.TrxType,
+			CreatedAt:     trx.CreatedAt.Time,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return assignCredits(credits, rows)
+}
+
+// creditRow is a transaction of creditMany, N is the 1-based ordinal of
+// its credit
+type creditRow struct {
+	Transactions
+	N int `db:"n"`
+}
+
+// assignCredits gives every credit the transaction of its ordinal
+func assignCredits(credits []*pendingCredit, rows []creditRow) error {
+	if len(rows) != len(credits) {
+		return fmt.Errorf("account: %d transactions recorded for %d credits", len(rows), len(credits))
+	}
+
+	assigned := make([]bool, len(credits))
+	for i := range rows {
+		n := rows[i].N
+		if n < 1 || n > len(credits) || assigned[n-1] {
+			return fmt.Errorf("account: unexpected credit ordinal %d", n)
+		}
+		assigned[n-1] = true
+		credits[n-1].trx = &rows[i].Transactions
+	}
+
+	return nil
+}
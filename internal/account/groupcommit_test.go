@@ -0,0 +1,184 @@
+package account
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCommit records the batches and gives every credit the next ID
+type fakeCommit struct {
+	err error
+
+	mu       sync.Mutex
+	batches  [][]float64
+	accounts []int
+	nextID   int
+}
+
+func (f *fakeCommit) commit(acc *Account, credits []*pendingCredit) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	amounts := []float64{}
+	for _, credit := range credits {
+		amounts = append(amounts, credit.amount)
+		if f.err == nil {
+			f.nextID++
+			credit.trx = &Transactions{ID: f.nextID, UserID: acc.ID, Amount: credit.amount}
+		}
+	}
+	f.batches = append(f.batches, amounts)
+	f.accounts = append(f.accounts, acc.ID)
+
+	return f.err
+}
+
+func newTestGroupCommit(f *fakeCommit, window time.Duration, maxSize int) *groupCommit {
+	return &groupCommit{
+		window:  window,
+		maxSize: maxSize,
+		commit:  f.commit,
+		pending: map[int]*creditBatch{},
+	}
+}
+
+// addConcurrently credits the account n times at once
+func addConcurrently(g *groupCommit, acc *Account, n int) ([]*Transactions, []error) {
+	trxs := make([]*Transactions, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trxs[i], errs[i] = g.add(acc, float64(i+1))
+		}()
+	}
+	wg.Wait()
+
+	return trxs, errs
+}
+
+func TestGroupCommitCoalesces(t *testing.T) {
+	f := &fakeCommit{}
+	g := newTestGroupCommit(f, 20*time.Millisecond, 1000)
+
+	trxs, errs := addConcurrently(g, &Account{ID: 1}, 50)
+
+	ids := map[int]bool{}
+	for i := range trxs {
+		if errs[i] != nil {
+			t.Fatalf("credit %d failed: %v", i, errs[i])
+		}
+		if trxs[i].Amount != float64(i+1) || ids[trxs[i].ID] {
+			t.Errorf("credit %d got transaction %+v", i, trxs[i])
+		}
+		ids[trxs[i].ID] = true
+	}
+
+	credits := 0
+	for _, batch := range f.batches {
+		credits += len(batch)
+	}
+	if credits != 50 || len(f.batches) >= 50 {
+		t.Errorf("%d credits in %d batches, want 50 coalesced", credits, len(f.batches))
+	}
+}
+
+func TestGroupCommitFullBatch(t *testing.T) {
+	f := &fakeCommit{}
+	// the window never elapses within the test, the full batch commits
+	g := newTestGroupCommit(f, time.Hour, 3)
+
+	done := make(chan struct{})
+	go func() {
+		addConcurrently(g, &Account{ID: 1}, 3)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the full batch was not committed")
+	}
+	if len(f.batches) != 1 || len(f.batches[0]) != 3 {
+		t.Errorf("batches = %v, want 1 of 3 credits", f.batches)
+	}
+}
+
+func TestGroupCommitFailsEveryCaller(t *testing.T) {
+	f := &fakeCommit{err: errors.New("connection reset")}
+	g := newTestGroupCommit(f, 10*time.Millisecond, 1000)
+
+	trxs, errs := addConcurrently(g, &Account{ID: 1}, 10)
+	for i := range errs {
+		if !errors.Is(errs[i], f.err) || trxs[i] != nil {
+			t.Errorf("credit %d = %v, %v, want the batch error", i, trxs[i], errs[i])
+		}
+	}
+}
+
+func TestGroupCommitSeparatesAccounts(t *testing.T) {
+	f := &fakeCommit{}
+	g := newTestGroupCommit(f, 10*time.Millisecond, 1000)
+
+	var wg sync.WaitGroup
+	for _, id := range []int{1, 2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addConcurrently(g, &Account{ID: id}, 5)
+		}()
+	}
+	wg.Wait()
+
+	credits := map[int]int{}
+	for i, batch := range f.batches {
+		credits[f.accounts[i]] += len(batch)
+	}
+	if credits[1] != 5 || credits[2] != 5 {
+		t.Errorf("credits per account = %v, want 5 each", credits)
+	}
+}
+
+func TestAssignCredits(t *testing.T) {
+	newCredits := func() []*pendingCredit {
+		return []*pendingCredit{{amount: 1}, {amount: 2}, {amount: 3}}
+	}
+
+	// the IDs do not follow the ordinals
+	rows := []creditRow{
+		{Transactions: Transactions{ID: 10, Amount: 3}, N: 3},
+		{Transactions: Transactions{ID: 11, Amount: 1}, N: 1},
+		{Transactions: Transactions{ID: 12, Amount: 2}, N: 2},
+	}
+	credits := newCredits()
+	err := assignCredits(credits, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, credit := range credits {
+		if credit.trx == nil || credit.trx.Amount != credit.amount {
+			t.Errorf("credit of %v got %+v", credit.amount, credit.trx)
+		}
+	}
+
+	tests := []struct {
+		name string
+		rows []creditRow
+	}{
+		{"missing row", rows[:2]},
+		{"duplicate ordinal", []creditRow{{N: 1}, {N: 1}, {N: 2}}},
+		{"ordinal out of range", []creditRow{{N: 1}, {N: 2}, {N: 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := assignCredits(newCredits(), tt.rows); err == nil {
+				t.Error("assignCredits() succeeded")
+			}
+		})
+	}
+}
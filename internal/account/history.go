@@ -0,0 +1,98 @@
+package account
+
+import (
+	"database/sql"
+	"time"
+)
+
+// balanceAnchor is a known balance of the account, the past balances are
+// summed from the latest one before the queried time
+type balanceAnchor struct {
+	Balance float64   `db:"balance"`
+	At      time.Time `db:"at"`
+
+	// TrxID is the transaction of a running balance, the transactions
+	// after it are the ones with a greater ID. It is not valid for a
+	// daily snapshot, the transactions after it are the ones created
+	// from At on.
+	TrxID sql.NullInt64 `db:"trx_id"`
+}
+
+// latestAnchor returns the latest of the anchors, a running balance wins
+// over a snapshot of the same instant. ok is false without any.
+func latestAnchor(anchors []balanceAnchor) (latest balanceAnchor, ok bool) {
+	for _, a := range anchors {
+		if !ok || a.At.After(latest.At) || (a.At.Equal(latest.At) && a.TrxID.Valid) {
+			latest, ok = a, true
+		}
+	}
+
+	return latest, ok
+}
+
+// GetBalanceAt implements EWalletSystem.
+func (s *simpleEWallet) GetBalanceAt(acc *Account, at time.Time) (float64, error) {
+	err := ValidateBalanceTime(at, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	db := s.reader(acc.Username)
+
+	// the timestamps are stored in UTC, at is converted explicitly. The
+	// running balance is the one of the latest transaction recording it,
+	// in the order the account was locked (by ID), the snapshot the one
+	// of the latest day over at the time.
+	qAnchors := `
+        (
+            SELECT
+                balance_after AS balance, created_at AS at, id AS trx_id
+            FROM transactions
+            WHERE
+                user_id = $1 AND balance_after IS NOT NULL
+                AND created_at <= ($2::TIMESTAMPTZ AT TIME ZONE 'UTC')
+            ORDER BY id DESC
+            LIMIT 1
+        )
+        UNION ALL
+        (
+            SELECT
+                balance, (snapshot_date + 1)::TIMESTAMP AS at, NULL AS trx_id
+            FROM balance_snapshots
+            WHERE
+                user_id = $1
+                AND (snapshot_date + 1)::TIMESTAMP <= ($2::TIMESTAMPTZ AT TIME ZONE 'UTC')
+            ORDER BY snapshot_date DESC
+            LIMIT 1
+        )
+    `
+	anchors := []balanceAnchor{}
+	err = db.Select(&anchors, qAnchors, acc.ID, at)
+	if err != nil {
+		return 0, err
+	}
+
+	anchor, ok := latestAnchor(anchors)
+	var since *time.Time
+	if ok && !anchor.TrxID.Valid {
+		since = &anchor.At
+	}
+
+	qTrx := `
+        SELECT
+            COALESCE(SUM(CASE WHEN type = 'credit' THEN amount ELSE -amount END), 0)
+        FROM transactions
+        WHERE
+            user_id = $1
+            AND ($2::TIMESTAMP IS NULL OR created_at >= $2::TIMESTAMP)
+            AND ($3::BIGINT IS NULL OR id > $3::BIGINT)
+            AND created_at <= ($4::TIMESTAMPTZ AT TIME ZONE 'UTC')
+    `
+	var delta float64
+	err = db.Get(&delta, qTrx, acc.ID, since, anchor.TrxID, at)
+	if err != nil {
+		return 0, err
+	}
+
+	return cents(anchor.Balance + delta), nil
+}
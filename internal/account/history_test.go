@@ -0,0 +1,124 @@
+package account
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLatestAnchor(t *testing.T) {
+	day := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	snapshot := balanceAnchor{Balance: 100, At: day}
+	before := balanceAnchor{Balance: 90, At: day.Add(-time.Hour), TrxID: sql.NullInt64{Int64: 7, Valid: true}}
+	after := balanceAnchor{Balance: 120, At: day.Add(time.Hour), TrxID: sql.NullInt64{Int64: 9, Valid: true}}
+	same := balanceAnchor{Balance: 110, At: day, TrxID: sql.NullInt64{Int64: 8, Valid: true}}
+
+	tests := []struct {
+		name    string
+		anchors []balanceAnchor
+		want    balanceAnchor
+		wantOK  bool
+	}{
+		{"none", nil, balanceAnchor{}, false},
+		{"snapshot only", []balanceAnchor{snapshot}, snapshot, true},
+		{"running balance after the snapshot", []balanceAnchor{after, snapshot}, after, true},
+		{"running balance before the snapshot", []balanceAnchor{before, snapshot}, snapshot, true},
+		{"same instant", []balanceAnchor{snapshot, same}, same, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := latestAnchor(tt.anchors)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("latestAnchor() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// The transaction that began first but locked the account last must not
+// anchor the balance at its begin time, its balance after includes the
+// other write.
+func TestGetBalanceAt_overlappingWrites(t *testing.T) {
+	db, err := connectDatabase()
+	if err != nil {
+		t.Skip("precondition:", err)
+	}
+	ewallet := NewSimpleEWalletSystem(db)
+
+	t.Cleanup(func() {
+		err := deleteTestTrx(db)
+		if err != nil {
+			t.Log("post-test:", err)
+		}
+
+		err = deleteTestUsers(db)
+		if err != nil {
+			t.Log("post-test:", err)
+		}
+	})
+
+	testUsername := "test_user_overlap_" + time.Now().Format("20060102150405")
+	err = ewallet.CreateNewAccount(0, testUsername)
+	if err != nil {
+		t.Fatal("precondition:", err)
+	}
+	acc, err := ewallet.GetUser(testUsername)
+	if err != nil {
+		t.Fatal("precondition:", err)
+	}
+
+	first, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+
+	var firstBegin time.Time
+	err = first.Get(&firstBegin, `SELECT CURRENT_TIMESTAMP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the 2nd transaction begins later and writes first
+	time.Sleep(50 * time.Millisecond)
+	second, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Credit(second, acc.ID, 10)
+	if err != nil {
+		second.Rollback()
+		t.Fatal(err)
+	}
+	if err := second.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Credit(first, acc.ID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want float64
+	}{
+		{"before both writes", firstBegin.Add(10 * time.Millisecond), 0},
+		{"after both writes", time.Now(), 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ewallet.GetBalanceAt(acc, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetBalanceAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
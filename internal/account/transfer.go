@@ -0,0 +1,74 @@
+package account
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Transfer implements EWalletSystem.
+func (s *simpleEWallet) Transfer(from *Account, to *Account, amount float64) (*Transactions, *Transactions, error) {
+	err := ValidateAmount(amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	if from.ID == to.ID {
+		return nil, nil, ErrSameAccount
+	}
+
+	fee := s.fees.Calculate(FeeTrxTransfer, from.Tier, amount)
+	if !canDeductFund(from, amount+fee) {
+		return nil, nil, ErrInsufficient
+	}
+
+	// always lock the rows in the same order (lowest ID first), so 2
+	// opposite transfers between the same users cannot deadlock
+	first, second := from, to
+	if to.ID < from.ID {
+		first, second = to, from
+	}
+
+	var debit, credit *Transactions
+	err = s.inTx("Transfer", func(tx *sqlx.Tx) error {
+		deltas := map[int]float64{from.ID: -amount, to.ID: amount}
+		buckets := map[int]sql.NullInt32{}
+		for _, acc := range []*Account{first, second} {
+			bucket, err := updateBalance(tx, acc.ID, deltas[acc.ID])
+			if err != nil {
+				return err
+			}
+			buckets[acc.ID] = bucket
+		}
+
+		debit = &Transactions{
+			UserID:  from.ID,
+			Amount:  amount,
+			TrxType: TrxTypeDebit,
+			Bucket:  buckets[from.ID],
+		}
+		err := recordTrx(tx, debit)
+		if err != nil {
+			return err
+		}
+
+		credit = &Transactions{
+			UserID:  to.ID,
+			Amount:  amount,
+			TrxType: TrxTypeCredit,
+			Bucket:  buckets[to.ID],
+		}
+		err = recordTrx(tx, credit)
+		if err != nil {
+			return err
+		}
+
+		return s.chargeFee(tx, from, debit, fee)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.written(s.fees.charged(fee, from.Username, to.Username)...)
+
+	return debit, credit, nil
+}
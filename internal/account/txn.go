@@ -0,0 +1,186 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+)
+
+const (
+	defaultTxAttempts  = 3
+	defaultRetryBase   = 10 * time.Millisecond
+	defaultRetryMax    = 500 * time.Millisecond
+	maxBackoffDoubling = 30
+)
+
+// the SQLSTATEs of the transactions that may succeed when run again
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// the retries of every operation, exported on /debug/vars
+var (
+	metricTxRetries   = expvar.NewMap("account_tx_retries")
+	metricTxExhausted = expvar.NewMap("account_tx_retries_exhausted")
+)
+
+// TxPolicy is the isolation level of the money movements, and how their
+// serialization failures and deadlocks are retried. The jobs moving money
+// outside of the EWalletSystem run their transactions with InTx too.
+type TxPolicy struct {
+	isolation   sql.IsolationLevel
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+
+	// sleep waits the backoff, replaced by the tests
+	sleep func(time.Duration)
+}
+
+// NewTxPolicy builds the policy of the config, an unknown isolation level
+// is an error
+func NewTxPolicy(cfg config.TransactionConfig) (*TxPolicy, error) {
+	p := defaultTxPolicy()
+
+	switch cfg.Isolation {
+	case "":
+	case "read_committed":
+		p.isolation = sql.LevelReadCommitted
+	case "repeatable_read":
+		p.isolation = sql.LevelRepeatableRead
+	case "serializable":
+		p.isolation = sql.LevelSerializable
+	default:
+		return nil, fmt.Errorf("account: unsupported isolation level %q", cfg.Isolation)
+	}
+
+	if cfg.MaxAttempts > 0 {
+		p.maxAttempts = cfg.MaxAttempts
+	}
+	if cfg.RetryBaseMs > 0 {
+		p.retryBase = time.Duration(cfg.RetryBaseMs) * time.Millisecond
+	}
+	if cfg.RetryMaxMs > 0 {
+		p.retryMax = time.Duration(cfg.RetryMaxMs) * time.Millisecond
+	}
+	if p.retryMax < p.retryBase {
+		p.retryMax = p.retryBase
+	}
+
+	return p, nil
+}
+
+// defaultTxPolicy runs at the isolation level of the database, still
+// retrying the deadlocks
+func defaultTxPolicy() *TxPolicy {
+	return &TxPolicy{
+		isolation:   sql.LevelDefault,
+		maxAttempts: defaultTxAttempts,
+		retryBase:   defaultRetryBase,
+		retryMax:    defaultRetryMax,
+		sleep:       time.Sleep,
+	}
+}
+
+// WithTxPolicy runs the money movements with the policy
+func WithTxPolicy(p *TxPolicy) Option {
+	return func(s *simpleEWallet) {
+		s.txs = p
+	}
+}
+
+func (s *simpleEWallet) inTx(operation string, fn func(tx *sqlx.Tx) error) error {
+	return s.txs.InTx(context.Background(), s.db, operation, fn)
+}
+
+// InTx runs fn in a transaction of db, committed when fn succeeds and
+// rolled back otherwise. A transaction failing on a serialization failure
+// or a deadlock is run again, so fn must not keep state across the
+// attempts. A nil policy is the default one.
+func (p *TxPolicy) InTx(ctx context.Context, db *sqlx.DB, operation string, fn func(tx *sqlx.Tx) error) error {
+	if p == nil {
+		p = defaultTxPolicy()
+	}
+
+	return p.retry(operation, func() error {
+		tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: p.isolation})
+		if err != nil {
+			return err
+		}
+
+		err = fn(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+// retry runs attempt until it succeeds, fails for a reason a retry cannot
+// fix, or the attempts are exhausted
+func (p *TxPolicy) retry(operation string, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+
+		state, retryable := retryableState(err)
+		if !retryable {
+			return err
+		}
+
+		if n >= p.maxAttempts {
+			metricTxExhausted.Add(operation, 1)
+			return fmt.Errorf("account: %s failed after %d attempts: %w", operation, n, err)
+		}
+
+		backoff := p.backoff(n)
+		metricTxRetries.Add(operation, 1)
+		slog.Warn("retrying transaction",
+			"operation", operation,
+			"attempt", n,
+			"sqlstate", state,
+			"backoff_ms", backoff.Milliseconds(),
+		)
+		p.sleep(backoff)
+	}
+}
+
+// backoff is a random wait up to retryBase doubled on every attempt,
+// capped at retryMax, so the conflicting transactions spread out
+func (p *TxPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.retryMax
+	if attempt <= maxBackoffDoubling {
+		if d := p.retryBase << (attempt - 1); d < ceiling {
+			ceiling = d
+		}
+	}
+
+	return rand.N(ceiling) + 1
+}
+
+// retryableState returns the SQLSTATE of err when it is a serialization
+// failure or a deadlock
+func retryableState(err error) (string, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return "", false
+	}
+
+	switch pqErr.Code {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return string(pqErr.Code), true
+	default:
+		return "", false
+	}
+}
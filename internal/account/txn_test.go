@@ -0,0 +1,101 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+)
+
+func TestTxPolicyRetry(t *testing.T) {
+	serialization := &pq.Error{Code: sqlStateSerializationFailure}
+	deadlock := fmt.Errorf("transfer: %w", &pq.Error{Code: sqlStateDeadlockDetected})
+	uniqueViolation := &pq.Error{Code: "23505"}
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"serialization failure then success", []error{serialization, nil}, 2, nil},
+		{"wrapped deadlock then success", []error{deadlock, serialization, nil}, 3, nil},
+		{"not retryable", []error{uniqueViolation, nil}, 1, uniqueViolation},
+		{"domain error", []error{ErrInsufficient, nil}, 1, ErrInsufficient},
+		{"exhausted", []error{serialization, serialization, serialization, serialization}, 3, serialization},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var waits []time.Duration
+			p := defaultTxPolicy()
+			p.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+			attempts := 0
+			err := p.retry("Test", func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(waits) != attempts-1 {
+				t.Errorf("waited %d times over %d attempts", len(waits), attempts)
+			}
+		})
+	}
+}
+
+func TestTxPolicyBackoff(t *testing.T) {
+	p, err := NewTxPolicy(config.TransactionConfig{RetryBaseMs: 10, RetryMaxMs: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ceilings := []time.Duration{10, 20, 40, 50, 50}
+	for i, ceiling := range ceilings {
+		for j := 0; j < 100; j++ {
+			d := p.backoff(i + 1)
+			if d <= 0 || d > ceiling*time.Millisecond {
+				t.Fatalf("backoff(%d) = %v, want within (0, %v]", i+1, d, ceiling*time.Millisecond)
+			}
+		}
+	}
+
+	// the doubling never overflows into a negative ceiling
+	if d := p.backoff(100); d <= 0 || d > 50*time.Millisecond {
+		t.Errorf("backoff(100) = %v", d)
+	}
+}
+
+func TestNewTxPolicy(t *testing.T) {
+	tests := []struct {
+		isolation string
+		want      sql.IsolationLevel
+		wantErr   bool
+	}{
+		{"", sql.LevelDefault, false},
+		{"read_committed", sql.LevelReadCommitted, false},
+		{"repeatable_read", sql.LevelRepeatableRead, false},
+		{"serializable", sql.LevelSerializable, false},
+		{"snapshot", 0, true},
+	}
+	for _, tt := range tests {
+		p, err := NewTxPolicy(config.TransactionConfig{Isolation: tt.isolation})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewTxPolicy(%q) err = %v", tt.isolation, err)
+			continue
+		}
+		if err == nil && p.isolation != tt.want {
+			t.Errorf("NewTxPolicy(%q) isolation = %v, want %v", tt.isolation, p.isolation, tt.want)
+		}
+	}
+}
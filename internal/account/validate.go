@@ -0,0 +1,116 @@
+package account
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxAmount is the largest amount of a single transaction
+	MaxAmount = 1_000_000_000
+
+	// AmountDecimals is the precision of the amounts, in decimals
+	AmountDecimals = 2
+
+	// MaxUsernameLength matches users.username VARCHAR(50)
+	MaxUsernameLength = 50
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateAmount checks the amount of a transaction: positive, at most
+// AmountDecimals decimals and at most MaxAmount
+func ValidateAmount(amount float64) error {
+	msg := amountMessage(amount)
+	if msg == "" {
+		return nil
+	}
+
+	return &ValidationError{Fields: []FieldError{{Field: "amount", Message: msg}}}
+}
+
+// ValidateUsername checks the username: 1 to MaxUsernameLength letters,
+// digits, "_", "." or "-"
+func ValidateUsername(username string) error {
+	msg := usernameMessage(username)
+	if msg == "" {
+		return nil
+	}
+
+	return &ValidationError{Fields: []FieldError{{Field: "username", Message: msg}}}
+}
+
+// ValidateBalanceTime checks the time of a balance query, which cannot be
+// after now
+func ValidateBalanceTime(at time.Time, now time.Time) error {
+	msg := ""
+	switch {
+	case at.IsZero():
+		msg = "is required"
+	case at.After(now):
+		msg = "must not be in the future"
+	default:
+		return nil
+	}
+
+	return &ValidationError{Fields: []FieldError{{Field: "at", Message: msg}}}
+}
+
+func amountMessage(amount float64) string {
+	switch {
+	case math.IsNaN(amount) || math.IsInf(amount, 0):
+		return "must be a number"
+	case amount <= 0:
+		return "must be positive"
+	case amount > MaxAmount:
+		return fmt.Sprintf("must be at most %d", MaxAmount)
+	case decimals(amount) > AmountDecimals:
+		return fmt.Sprintf("must have at most %d decimals", AmountDecimals)
+	}
+
+	return ""
+}
+
+func usernameMessage(username string) string {
+	switch {
+	case username == "":
+		return "is required"
+	case len(username) > MaxUsernameLength:
+		return fmt.Sprintf("must be at most %d characters", MaxUsernameLength)
+	case !usernamePattern.MatchString(username):
+		return `must only contain letters, digits, "_", "." or "-"`
+	}
+
+	return ""
+}
+
+// decimals counts the decimals of the shortest representation of f, so
+// 0.1 has 1 decimal even though it is not exact in binary
+func decimals(f float64) int {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	_, frac, ok := strings.Cut(s, ".")
+	if !ok {
+		return 0
+	}
+
+	return len(frac)
+}
+
+// validateInput joins the failed checks into 1 ValidationError
+func validateInput(errs ...error) error {
+	var fields []FieldError
+	for _, err := range errs {
+		if validationErr, ok := err.(*ValidationError); ok {
+			fields = append(fields, validationErr.Fields...)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Fields: fields}
+}
@@ -0,0 +1,102 @@
+package account
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  float64
+		wantErr bool
+	}{
+		{"integer", 10, false},
+		{"2 decimals", 10.25, false},
+		{"inexact in binary", 0.1, false},
+		{"max", MaxAmount, false},
+		{"zero", 0, true},
+		{"negative", -10, true},
+		{"3 decimals", 10.255, true},
+		{"above max", MaxAmount + 0.01, true},
+		{"NaN", math.NaN(), true},
+		{"infinity", math.Inf(1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAmount(tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateAmount(%v) = %v, wantErr %v", tt.amount, err, tt.wantErr)
+			}
+			if err != nil && CodeOf(err) != CodeValidation {
+				t.Errorf("CodeOf() = %q, want %q", CodeOf(err), CodeValidation)
+			}
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		wantErr  bool
+	}{
+		{"simple", "alice", false},
+		{"with separators", "auto_user.deposit-1", false},
+		{"max length", strings.Repeat("a", MaxUsernameLength), false},
+		{"empty", "", true},
+		{"too long", strings.Repeat("a", MaxUsernameLength+1), true},
+		{"space", "alice smith", true},
+		{"quote", "alice'--", true},
+		{"non ascii", "alicé", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateUsername(%q) = %v, wantErr %v", tt.username, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	err := validateInput(ValidateUsername(""), ValidateAmount(-1), nil)
+
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("validateInput() = %v, want *ValidationError", err)
+	}
+	if len(validationErr.Fields) != 2 {
+		t.Errorf("fields = %v, want username and amount", validationErr.Fields)
+	}
+
+	if err := validateInput(ValidateUsername("alice"), nil); err != nil {
+		t.Errorf("validateInput() = %v, want nil", err)
+	}
+}
+
+func TestValidateBalanceTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		wantErr bool
+	}{
+		{"past", now.AddDate(0, -1, 0), false},
+		{"now", now, false},
+		{"future", now.Add(time.Second), true},
+		{"missing", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBalanceTime(tt.at, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateBalanceTime(%v) = %v, wantErr %v", tt.at, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package account
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+)
+
+const (
+	defaultWriteQueueLength = 64
+	defaultWriteQueueIdle   = 30 * time.Second
+)
+
+// the running account queues and the rejected writes, exported on
+// /debug/vars
+var (
+	metricWriteQueues        = expvar.NewInt("account_write_queues")
+	metricWriteQueueRejected = expvar.NewInt("account_write_queue_rejected")
+)
+
+// writeQueue runs the writes of every account one after the other on a
+// goroutine of the account, so the writers of a hot account wait in
+// memory instead of on the row lock, each holding a database connection.
+// The database still serializes the writes of the other instances.
+//
+// The reads, the account creation and the batches go straight to the
+// wrapped EWalletSystem.
+type writeQueue struct {
+	EWalletSystem
+
+	maxLength int
+	idle      time.Duration
+
+	// queueCredits is false when next coalesces the credits, queueing
+	// them would leave nothing to coalesce
+	queueCredits bool
+
+	mu     sync.Mutex
+	queues map[string]chan func()
+}
+
+// NewWriteQueue serializes the writes of next per account. A write is
+// rejected with ErrAccountBusy when the queue of its account is full.
+func NewWriteQueue(next EWalletSystem, cfg config.WriteQueueConfig) EWalletSystem {
+	q := &writeQueue{
+		EWalletSystem: next,
+		maxLength:     cfg.MaxQueueLength,
+		idle:          time.Duration(cfg.IdleTimeoutSeconds) * time.Second,
+		queues:        map[string]chan func(){},
+		queueCredits:  true,
+	}
+	if s, ok := next.(*simpleEWallet); ok && s.credits != nil {
+		q.queueCredits = false
+	}
+	if q.maxLength <= 0 {
+		q.maxLength = defaultWriteQueueLength
+	}
+	if q.idle <= 0 {
+		q.idle = defaultWriteQueueIdle
+	}
+
+	return q
+}
+
+// AddBalance implements EWalletSystem.
+func (q *writeQueue) AddBalance(acc *Account, amount float64) (*Transactions, error) {
+	if !q.queueCredits {
+		return q.EWalletSystem.AddBalance(acc, amount)
+	}
+
+	var trx *Transactions
+	err := q.do(acc.Username, func() error {
+		var err error
+		trx, err = q.EWalletSystem.AddBalance(acc, amount)
+		return err
+	})
+
+	return trx, err
+}
+
+// AddBalanceIfVersion implements EWalletSystem.
+func (q *writeQueue) AddBalanceIfVersion(acc *Account, amount float64, version int64) (*Transactions, error) {
+	var trx *Transactions
+	err := q.do(acc.Username, func() error {
+		var err error
+		trx, err = q.EWalletSystem.AddBalanceIfVersion(acc, amount, version)
+		return err
+	})
+
+	return trx, err
+}
+
+// DeductBalance implements EWalletSystem.
+func (q *writeQueue) DeductBalance(acc *Account, amount float64) (*Transactions, error) {
+	var trx *Transactions
+	err := q.do(acc.Username, func() error {
+		err := q.refresh(acc)
+		if err != nil {
+			return err
+		}
+
+		trx, err = q.EWalletSystem.DeductBalance(acc, amount)
+		return err
+	})
+
+	return trx, err
+}
+
+// DeductBalanceIfVersion implements EWalletSystem.
+func (q *writeQueue) DeductBalanceIfVersion(acc *Account, amount float64, version int64) (*Transactions, error) {
+	var trx *Transactions
+	err := q.do(acc.Username, func() error {
+		err := q.refresh(acc)
+		if err != nil {
+			return err
+		}
+
+		trx, err = q.EWalletSystem.DeductBalanceIfVersion(acc, amount, version)
+		return err
+	})
+
+	return trx, err
+}
+
+// Transfer implements EWalletSystem. The transfer is queued on the
+// sender, whose balance it checks.
+func (q *writeQueue) Transfer(from *Account, to *Account, amount float64) (*Transactions, *Transactions, error) {
+	var debit, credit *Transactions
+	err := q.do(from.Username, func() error {
+		err := q.refresh(from)
+		if err != nil {
+			return err
+		}
+
+		debit, credit, err = q.EWalletSystem.Transfer(from, to, amount)
+		return err
+	})
+
+	return debit, credit, err
+}
+
+// refresh reloads the account before a debit, the writes queued before
+// it changed the balance since the caller read it
+func (q *writeQueue) refresh(acc *Account) error {
+	fresh, err := q.EWalletSystem.GetUser(acc.Username)
+	if err != nil {
+		return err
+	}
+
+	*acc = *fresh
+	return nil
+}
+
+// do queues the write on the account and waits for its outcome
+func (q *writeQueue) do(username string, write func() error) error {
+	done := make(chan error, 1)
+	job := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("account: write panicked: %v", r)
+			}
+		}()
+
+		done <- write()
+	}
+
+	q.mu.Lock()
+	jobs, ok := q.queues[username]
+	if !ok {
+		jobs = make(chan func(), q.maxLength)
+		q.queues[username] = jobs
+		metricWriteQueues.Add(1)
+		go q.run(username, jobs)
+	}
+
+	select {
+	case jobs <- job:
+		q.mu.Unlock()
+	default:
+		q.mu.Unlock()
+		metricWriteQueueRejected.Add(1)
+		return ErrAccountBusy.Detailf("too many pending writes on the account")
+	}
+
+	return <-done
+}
+
+// run executes the jobs of the account in order, until the account is
+// idle for a while
+func (q *writeQueue) run(username string, jobs chan func()) {
+	timer := time.NewTimer(q.idle)
+	defer timer.Stop()
+
+	for {
+		select {
+		case job := <-jobs:
+			job()
+			timer.Reset(q.idle)
+
+		case <-timer.C:
+			// the jobs are queued under the lock, none can be lost
+			// between the check and the removal
+			q.mu.Lock()
+			if len(jobs) > 0 {
+				q.mu.Unlock()
+				timer.Reset(q.idle)
+				continue
+			}
+			delete(q.queues, username)
+			metricWriteQueues.Add(-1)
+			q.mu.Unlock()
+			return
+		}
+	}
+}
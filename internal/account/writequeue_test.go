@@ -0,0 +1,209 @@
+package account
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+)
+
+// stubWallet records how many writes of every account run at once
+type stubWallet struct {
+	EWalletSystem
+
+	balance float64
+	block   chan struct{}
+	started chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[string]int
+	maxSeen  map[string]int
+}
+
+func newStubWallet() *stubWallet {
+	return &stubWallet{inFlight: map[string]int{}, maxSeen: map[string]int{}}
+}
+
+func (s *stubWallet) GetUser(username string) (*Account, error) {
+	return &Account{Username: username, Balance: s.balance}, nil
+}
+
+func (s *stubWallet) AddBalance(acc *Account, amount float64) (*Transactions, error) {
+	s.mu.Lock()
+	s.inFlight[acc.Username]++
+	s.maxSeen[acc.Username] = max(s.maxSeen[acc.Username], s.inFlight[acc.Username])
+	s.mu.Unlock()
+
+	if s.started != nil {
+		s.started <- struct{}{}
+	}
+	if s.block != nil {
+		<-s.block
+	} else {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.mu.Lock()
+	s.inFlight[acc.Username]--
+	s.mu.Unlock()
+
+	return &Transactions{Amount: amount, TrxType: TrxTypeCredit}, nil
+}
+
+func (s *stubWallet) DeductBalance(acc *Account, amount float64) (*Transactions, error) {
+	if !canDeductFund(acc, amount) {
+		return nil, ErrInsufficient
+	}
+	return &Transactions{Amount: amount, TrxType: TrxTypeDebit}, nil
+}
+
+func TestWriteQueueSerializesPerAccount(t *testing.T) {
+	stub := newStubWallet()
+	q := NewWriteQueue(stub, config.WriteQueueConfig{MaxQueueLength: 100})
+
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < 40; i++ {
+		username := "alice"
+		if i%2 == 0 {
+			username = "bob"
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := q.AddBalance(&Account{Username: username}, 10)
+			if err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed.Load() != 0 {
+		t.Errorf("%d writes failed", failed.Load())
+	}
+	for _, username := range []string{"alice", "bob"} {
+		if stub.maxSeen[username] != 1 {
+			t.Errorf("%d writes of %s ran at once, want 1", stub.maxSeen[username], username)
+		}
+	}
+}
+
+func TestWriteQueueRejectsWhenFull(t *testing.T) {
+	stub := newStubWallet()
+	stub.block = make(chan struct{})
+	stub.started = make(chan struct{}, 10)
+	q := NewWriteQueue(stub, config.WriteQueueConfig{MaxQueueLength: 2}).(*writeQueue)
+
+	// 1 running write, then 2 queued ones fill the queue
+	errs := make(chan error, 4)
+	write := func() {
+		_, err := q.AddBalance(&Account{Username: "alice"}, 10)
+		errs <- err
+	}
+	go write()
+	<-stub.started
+	go write()
+	go write()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		queued := len(q.queues["alice"])
+		q.mu.Unlock()
+		if queued == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d writes queued, want 2", queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := q.AddBalance(&Account{Username: "alice"}, 10)
+	if !errors.Is(err, ErrAccountBusy) {
+		t.Errorf("err = %v, want ErrAccountBusy", err)
+	}
+
+	// the other accounts are not affected
+	go func() {
+		_, err := q.AddBalance(&Account{Username: "bob"}, 10)
+		errs <- err
+	}()
+
+	close(stub.block)
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("queued write failed: %v", err)
+		}
+	}
+}
+
+func TestWriteQueueDebitRefreshesBalance(t *testing.T) {
+	stub := newStubWallet()
+	stub.balance = 50
+	q := NewWriteQueue(stub, config.WriteQueueConfig{})
+
+	// the caller read the balance before an earlier debit of the queue
+	acc := &Account{Username: "alice", Balance: 1000}
+	_, err := q.DeductBalance(acc, 100)
+	if !errors.Is(err, ErrInsufficient) {
+		t.Errorf("err = %v, want ErrInsufficient", err)
+	}
+	if acc.Balance != 50 {
+		t.Errorf("balance = %v, want the refreshed 50", acc.Balance)
+	}
+}
+
+// BenchmarkHotAccountCredit credits 1 account from many goroutines,
+// directly, through the write queue and with the group commit. It needs
+// the database of the tests.
+func BenchmarkHotAccountCredit(b *testing.B) {
+	db, err := connectDatabase()
+	if err != nil {
+		b.Fatal("precondition:", err)
+	}
+	db.SetMaxOpenConns(50)
+	db.SetMaxIdleConns(25)
+	b.Cleanup(func() {
+		deleteTestTrx(db)
+		deleteTestUsers(db)
+	})
+
+	benchmarks := []struct {
+		name    string
+		ewallet EWalletSystem
+	}{
+		{"direct", NewSimpleEWalletSystem(db)},
+		{"write_queue", NewWriteQueue(NewSimpleEWalletSystem(db), config.WriteQueueConfig{MaxQueueLength: 10000})},
+		{"group_commit", NewSimpleEWalletSystem(db, WithGroupCommit(config.GroupCommitConfig{}))},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			username := "test_user_bench_" + bm.name + "_" + time.Now().Format("150405.000")
+			err := bm.ewallet.CreateNewAccount(0, username)
+			if err != nil {
+				b.Fatal("precondition:", err)
+			}
+			acc, err := bm.ewallet.GetUser(username)
+			if err != nil {
+				b.Fatal("precondition:", err)
+			}
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := bm.ewallet.AddBalance(acc, 1)
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -1,65 +1,85 @@
 package api
 
 import (
-	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/logging"
 )
 
+const defaultTransactionsLimit = 50
+
 // GetBalance gin handler
 // @Summary Get balance info
 // @Tags API
 // @Param username query string false "Username"
+// @Param at query string false "RFC 3339 time to get the past balance at, instead of the current one"
+// @Param Cache-Control header string false "no-cache reads the primary, for a strong ETag"
 // @Produce json
 // @Success 200 {object} GetBalanceResponse "Successful response"
-// @Success 400 {object} APIBaseResponse "Bad Request"
-// @Success 404 {object} APIBaseResponse "User Not Found"
-// @Success 500 {object} APIBaseResponse "Internal Server Error"
+// @Header 200 {string} ETag "Version of the account, not set with at. Weak unless Cache-Control is no-cache, only the strong one is for the If-Match of the transactions"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "User Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
 // @Router /api/balance [get]
 func (s *APIServer) GetBalance(c *gin.Context) {
 	logger := slog.Default().
 		With(slog.String("request_id", c.GetString("X-Request-Id"))).
 		With(slog.String("operation", "GetBalance"))
 
-		// Validate the request
+	// Validate the request
 	username := c.Query("username")
 	if username == "" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, APIBaseResponse{
-			Status:  "error",
-			Message: "Bad Request",
-		})
+		abortWithError(c, errMissingField("username"))
+		return
+	}
+
+	at, err := parseBalanceTime(c.Query("at"))
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
 	logger = logger.With(slog.Any("request_data", map[string]any{
 		"username": username,
+		"at":       c.Query("at"),
 	}))
 
-	ewallet := account.NewSimpleEWalletSystem(s.db)
-	user, err := ewallet.GetUser(username)
-	if err != nil {
-		switch {
-		case errors.Is(err, account.ErrNotFound):
-			logger.Error("failed to retrieve user", "error", err)
-			c.AbortWithStatusJSON(http.StatusNotFound, APIBaseResponse{
-				Status:  "error",
-				Message: "user " + username + " not found",
-			})
-
-		default:
-			logger.Error("failed to retrieve user", "error", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, APIBaseResponse{
-				Status:  "error",
-				Message: "internal server error",
-			})
+	// the cached or replicated account may be behind the primary, which
+	// checks If-Match, its ETag is weak
+	ewallet := s.ewallet
+	read, tag := ewallet.ReadUser, weakETag
+	if at == nil && noCache(c) {
+		read, tag = ewallet.GetUser, etag
+	}
+
+	user, err := read(username)
+	if err != nil {
+		abortWithError(c, err)
+
+		return
+	}
+
+	if at != nil {
+		balance, err := ewallet.GetBalanceAt(user, *at)
+		if err != nil {
+			logger.Error("failed to get the balance at the time", "error", err)
+
+			abortWithError(c, err)
+			return
 		}
 
+		c.JSON(http.StatusOK, GetBalanceResponse{
+			Balance: balance,
+			At:      at,
+		})
 		return
 	}
 
+	c.Header("ETag", tag(user.Version))
 	c.JSON(http.StatusOK, GetBalanceResponse{
 		Balance: user.Balance,
 	})
@@ -69,11 +89,15 @@ func (s *APIServer) GetBalance(c *gin.Context) {
 // @Summary Adds balance to the account
 // @Tags API
 // @Param request body DepositRequest true "JSON body"
+// @Param If-Match header string false "strong ETag of GET /api/balance with Cache-Control: no-cache, the transaction fails when the account changed since"
 // @Produce json
 // @Consume json
 // @Success 200 {object} DepositResponse "Successful response"
-// @Success 400 {object} APIBaseResponse "Bad Request"
-// @Success 500 {object} APIBaseResponse "Internal Server Error"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "User Not Found"
+// @Success 412 {object} Problem "Account changed"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Success 503 {object} Problem "Too many pending writes on the account"
 // @Router /api/transactions/credit [post]
 func (s *APIServer) DepositRequest(c *gin.Context) {
 	logger := slog.Default().
@@ -82,56 +106,57 @@ func (s *APIServer) DepositRequest(c *gin.Context) {
 
 	// Validate the request
 	req := new(DepositRequest)
-	err := c.ShouldBindJSON(req)
+	err := c.ShouldBindWith(req, strictJSON)
 	if err != nil {
 		logger.Error("validation failed on request", "error", err)
 
-		c.AbortWithStatusJSON(http.StatusBadRequest, APIBaseResponse{
-			Status:  "error",
-			Message: "Bad Request",
-		})
+		abortWithBindError(c, err)
 		return
 	}
 
-	ewallet := account.NewSimpleEWalletSystem(s.db)
+	ewallet := s.ewallet
 	user, err := ewallet.GetUser(req.Username)
 	if err != nil {
 		logger.Error("failed to retrieve user", "error", err)
 
-		switch {
-		case errors.Is(err, account.ErrNotFound):
-			c.AbortWithStatusJSON(http.StatusNotFound, APIBaseResponse{
-				Status:  "error",
-				Message: "user " + req.Username + " not found",
-			})
-
-		default:
-			c.AbortWithStatusJSON(http.StatusInternalServerError, APIBaseResponse{
-				Status:  "error",
-				Message: "internal server error",
-			})
-		}
+		abortWithError(c, err)
+
+		return
+	}
 
+	version, checked, err := ifMatch(c, user)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	trxResult, err := ewallet.AddBalance(user, req.Amount)
+	var trxResult *account.Transactions
+	if checked {
+		trxResult, err = ewallet.AddBalanceIfVersion(user, req.Amount, version)
+	} else {
+		trxResult, err = ewallet.AddBalance(user, req.Amount)
+	}
 	if err != nil {
 		logger.Error("failed to add balance", "error", err)
 
-		c.AbortWithStatusJSON(http.StatusInternalServerError, APIBaseResponse{
-			Status:  "error",
-			Message: "internal server error",
-		})
+		abortWithError(c, err)
 		return
 	}
 
+	logging.Audit(logger, logging.MoneyMovement{
+		Actor:   logging.ActorHTTP,
+		Account: user.Username,
+		TrxType: account.TrxTypeCredit,
+		Amount:  trxResult.Amount,
+		TrxID:   trxResult.ID,
+	})
+
 	c.JSON(http.StatusOK, DepositResponse{
 		APIBaseResponse: APIBaseResponse{
 			Status: "success",
 		},
 		TransactionID: trxResult.ID,
-		NewBalance:    user.Balance + req.Amount,
+		NewBalance:    newBalance(logger, ewallet, user, trxResult),
 	})
 }
 
@@ -139,11 +164,16 @@ func (s *APIServer) DepositRequest(c *gin.Context) {
 // @Summary Deducts balance from the account
 // @Tags API
 // @Param request body WithdrawRequest true "JSON body"
+// @Param If-Match header string false "strong ETag of GET /api/balance with Cache-Control: no-cache, the transaction fails when the account changed since"
 // @Produce json
 // @Consume json
 // @Success 200 {object} WithdrawResponse "Successful response"
-// @Success 400 {object} APIBaseResponse "Bad Request"
-// @Success 500 {object} APIBaseResponse "Internal Server Error"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "User Not Found"
+// @Success 412 {object} Problem "Account changed"
+// @Success 422 {object} Problem "Insufficient funds"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Success 503 {object} Problem "Too many pending writes on the account"
 // @Router /api/transactions/debit [post]
 func (s *APIServer) WithdrawRequest(c *gin.Context) {
 	logger := slog.Default().
@@ -151,65 +181,214 @@ func (s *APIServer) WithdrawRequest(c *gin.Context) {
 		With(slog.String("operation", "WithdrawRequest"))
 
 	// Validate the request
-	req := new(DepositRequest)
-	err := c.ShouldBindJSON(req)
+	req := new(WithdrawRequest)
+	err := c.ShouldBindWith(req, strictJSON)
 	if err != nil {
 		logger.Error("validation failed on request", "error", err)
 
-		c.AbortWithStatusJSON(http.StatusBadRequest, APIBaseResponse{
-			Status:  "error",
-			Message: "Bad Request",
-		})
+		abortWithBindError(c, err)
 		return
 	}
 
-	ewallet := account.NewSimpleEWalletSystem(s.db)
+	ewallet := s.ewallet
 	user, err := ewallet.GetUser(req.Username)
 	if err != nil {
 		logger.Error("failed to retrieve user", "error", err)
-		switch {
-		case errors.Is(err, account.ErrNotFound):
-			c.AbortWithStatusJSON(http.StatusNotFound, APIBaseResponse{
-				Status:  "error",
-				Message: "user " + req.Username + " not found",
-			})
-
-		default:
-			c.AbortWithStatusJSON(http.StatusInternalServerError, APIBaseResponse{
-				Status:  "error",
-				Message: "internal server error",
-			})
-		}
+		abortWithError(c, err)
 
 		return
 	}
 
-	trxResult, err := ewallet.DeductBalance(user, req.Amount)
+	version, checked, err := ifMatch(c, user)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	var trxResult *account.Transactions
+	if checked {
+		trxResult, err = ewallet.DeductBalanceIfVersion(user, req.Amount, version)
+	} else {
+		trxResult, err = ewallet.DeductBalance(user, req.Amount)
+	}
 	if err != nil {
 		logger.Error("failed to deduct balance", "error", err)
 
-		switch {
-		case errors.Is(err, account.ErrInsufficient):
-			c.AbortWithStatusJSON(http.StatusBadRequest, APIBaseResponse{
-				Status:  "error",
-				Message: "Insufficient funds",
-			})
-
-		default:
-			c.AbortWithStatusJSON(http.StatusInternalServerError, APIBaseResponse{
-				Status:  "error",
-				Message: "internal server error",
-			})
-		}
+		abortWithError(c, err)
 
 		return
 	}
 
-	c.JSON(http.StatusOK, DepositResponse{
+	logging.Audit(logger, logging.MoneyMovement{
+		Actor:   logging.ActorHTTP,
+		Account: user.Username,
+		TrxType: account.TrxTypeDebit,
+		Amount:  trxResult.Amount,
+		Fee:     trxResult.Fee,
+		TrxID:   trxResult.ID,
+	})
+
+	c.JSON(http.StatusOK, WithdrawResponse{
 		APIBaseResponse: APIBaseResponse{
 			Status: "success",
 		},
+		UserID:        user.ID,
 		TransactionID: trxResult.ID,
-		NewBalance:    user.Balance - req.Amount,
+		Fee:           trxResult.Fee,
+		NewBalance:    newBalance(logger, ewallet, user, trxResult),
 	})
 }
+
+// newBalance is the balance of the account after the transaction. The
+// money already moved, a failed read omits it instead of failing.
+func newBalance(logger *slog.Logger, ewallet account.EWalletSystem, user *account.Account, trx *account.Transactions) float64 {
+	balance, err := account.BalanceAfter(ewallet, user, trx)
+	if err != nil {
+		logger.Warn("failed to read the balance after the write", "error", err)
+	}
+
+	return balance
+}
+
+// QuoteFee gin handler
+// @Summary Shows the fee that would be charged for a debit, before executing it
+// @Tags API
+// @Param username query string true "Username"
+// @Param type query string true "Transaction type" Enums(withdrawal, transfer)
+// @Param amount query number true "Amount"
+// @Produce json
+// @Success 200 {object} QuoteFeeResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "User Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/fees/quote [get]
+func (s *APIServer) QuoteFee(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "QuoteFee"))
+
+	// Validate the request
+	req := new(QuoteFeeRequest)
+	err := c.ShouldBindQuery(req)
+	if err != nil {
+		logger.Error("validation failed on request", "error", err)
+
+		abortWithBindError(c, err)
+		return
+	}
+
+	ewallet := s.ewallet
+	user, err := ewallet.GetUser(req.Username)
+	if err != nil {
+		logger.Error("failed to retrieve user", "error", err)
+
+		abortWithError(c, err)
+
+		return
+	}
+
+	fee := ewallet.QuoteFee(user, req.Type, req.Amount)
+	c.JSON(http.StatusOK, QuoteFeeResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Amount: req.Amount,
+		Fee:    fee,
+		Total:  req.Amount + fee,
+	})
+}
+
+// ListTransactions gin handler
+// @Summary Lists the latest transactions of the account, newest first
+// @Tags API
+// @Param username query string true "Username"
+// @Param limit query int false "Max number of transactions (default 50, max 100)"
+// @Param before_id query int false "Only the transactions older than this one, for paging"
+// @Produce json
+// @Success 200 {object} ListTransactionsResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "User Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/transactions [get]
+func (s *APIServer) ListTransactions(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ListTransactions"))
+
+	// Validate the request
+	req := new(ListTransactionsRequest)
+	err := c.ShouldBindQuery(req)
+	if err != nil {
+		logger.Error("validation failed on request", "error", err)
+
+		abortWithBindError(c, err)
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultTransactionsLimit
+	}
+
+	ewallet := s.ewallet
+	user, err := ewallet.ReadUser(req.Username)
+	if err != nil {
+		logger.Error("failed to retrieve user", "error", err)
+
+		abortWithError(c, err)
+
+		return
+	}
+
+	trxs, err := ewallet.ListTransactions(user, req.Limit, req.BeforeID)
+	if err != nil {
+		logger.Error("failed to list transactions", "error", err)
+
+		abortWithError(c, err)
+		return
+	}
+
+	resp := ListTransactionsResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Transactions: make([]Transaction, 0, len(trxs)),
+	}
+	for _, trx := range trxs {
+		resp.Transactions = append(resp.Transactions, toTransaction(&trx))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func toTransaction(trx *account.Transactions) Transaction {
+	t := Transaction{
+		ID:        trx.ID,
+		Amount:    trx.Amount,
+		Type:      trx.TrxType,
+		CreatedAt: trx.CreatedAt.Time,
+	}
+	if trx.ParentID.Valid {
+		parentID := int(trx.ParentID.Int64)
+		t.ParentID = &parentID
+	}
+
+	return t
+}
+
+// parseBalanceTime parses the at query of GetBalance, nil when absent
+func parseBalanceTime(raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, &account.ValidationError{Fields: []account.FieldError{{Field: "at", Message: "must be an RFC 3339 time"}}}
+	}
+
+	err = account.ValidateBalanceTime(at, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &at, nil
+}
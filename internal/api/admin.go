@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/logging"
+)
+
+// AdminServer serves the operational endpoints, the metrics and the log
+// level, on their own listener. It has no authentication, the listener
+// must only be reachable by the operators.
+type AdminServer struct {
+	listener   string
+	httpServer *http.Server
+}
+
+func NewAdminServer(cfg *config.Config) *AdminServer {
+	return &AdminServer{
+		listener: cfg.Server.AdminListener,
+	}
+}
+
+// Handler returns the routes of the admin listener
+func (s *AdminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// runtime & reconciliation metrics
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// log level, changed without restart
+	mux.Handle("/debug/log-level", logging.LevelHandler())
+
+	return mux
+}
+
+// Run runs the server, the returned channel receives the error it
+// stopped with
+func (s *AdminServer) Run() <-chan error {
+	errChan := make(chan error)
+	s.httpServer = &http.Server{
+		Addr:              s.listener,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		fmt.Println("Admin server listening at:", s.httpServer.Addr)
+		errChan <- s.httpServer.ListenAndServe()
+	}()
+
+	return errChan
+}
+
+// Shutdown stops the server
+func (s *AdminServer) Shutdown() error {
+	return s.httpServer.Shutdown(context.Background())
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/config"
+)
+
+func TestDebugEndpointsOnAdminListenerOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+
+	public := NewAPIServer(cfg, nil, nil)
+	public.RegisterEndpoints()
+	admin := NewAdminServer(cfg).Handler()
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/debug/vars", ""},
+		{http.MethodGet, "/debug/log-level", ""},
+		{http.MethodPut, "/debug/log-level", `{"level":"info"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			public.gin.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("public listener status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+
+			w = httptest.NewRecorder()
+			admin.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != http.StatusOK {
+				t.Errorf("admin listener status = %d, want %d", w.Code, http.StatusOK)
+			}
+		})
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/yeyee2901/test/config"
 	"github.com/yeyee2901/test/docs"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/tlsconfig"
 
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -19,6 +21,10 @@ import (
 type APIConfig struct {
 	Listener             string
 	ServerTimeoutSeconds int
+	ImportMaxRows        int
+	AccessLog            config.AccessLogConfig
+	CORS                 config.CORSConfig
+	TLS                  config.TLSConfig
 }
 
 type APIServer struct {
@@ -26,10 +32,11 @@ type APIServer struct {
 
 	gin        *gin.Engine
 	db         *sqlx.DB
+	ewallet    account.EWalletSystem
 	httpServer *http.Server
 }
 
-func NewAPIServer(cfg *config.Config, db *sqlx.DB) *APIServer {
+func NewAPIServer(cfg *config.Config, db *sqlx.DB, ewallet account.EWalletSystem) *APIServer {
 	if strings.ToLower(cfg.Server.Mode) == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -38,17 +45,29 @@ func NewAPIServer(cfg *config.Config, db *sqlx.DB) *APIServer {
 		config: &APIConfig{
 			Listener:             cfg.Server.Listener,
 			ServerTimeoutSeconds: cfg.Server.ServerTimeoutSeconds,
+			ImportMaxRows:        cfg.Import.MaxRows,
+			AccessLog:            cfg.Logging.AccessLog,
+			CORS:                 cfg.CORS,
+			TLS:                  cfg.Server.TLS,
 		},
 		gin:        gin.New(),
 		db:         db,
+		ewallet:    ewallet,
 		httpServer: nil,
 	}
 }
 
 func (api *APIServer) RegisterMiddlewares() {
+	if api.config.AccessLog.Enabled {
+		api.gin.Use(AccessLog(api.config.AccessLog))
+	}
 	api.gin.Use(gin.Recovery())
-	api.gin.Use(CORSMiddleware())
+	api.gin.Use(CORSMiddleware(api.config.CORS))
 	api.gin.Use(AttachRequestID())
+	api.gin.Use(ErrorHandler())
+	if api.config.TLS.ClientCAFile != "" {
+		api.gin.Use(ClientIdentity(api.config.TLS.ClientIdentities))
+	}
 }
 
 func (api *APIServer) RegisterEndpoints() {
@@ -56,10 +75,41 @@ func (api *APIServer) RegisterEndpoints() {
 	api.gin.GET("/api/balance", api.GetBalance)
 	api.gin.POST("/api/transactions/credit", api.DepositRequest)
 	api.gin.POST("/api/transactions/debit", api.WithdrawRequest)
+	api.gin.GET("/api/transactions", api.ListTransactions)
+	api.gin.POST("/api/transactions/batch", api.ExecuteBatch)
+	api.gin.GET("/api/transactions/batch/:id", api.GetBatch)
+	api.gin.GET("/api/fees/quote", api.QuoteFee)
+
+	// CSV imports
+	api.gin.POST("/api/imports", api.CreateImport)
+	api.gin.GET("/api/imports/:id", api.GetImport)
+	api.gin.GET("/api/imports/:id/result", api.GetImportResult)
+
+	// webhook subscriptions
+	api.gin.POST("/api/webhooks", api.CreateWebhook)
+	api.gin.GET("/api/webhooks", api.ListWebhooks)
+	api.gin.GET("/api/webhooks/:id", api.GetWebhook)
+	api.gin.DELETE("/api/webhooks/:id", api.DeleteWebhook)
+	api.gin.GET("/api/webhooks/:id/deliveries", api.ListWebhookDeliveries)
+	api.gin.GET("/api/webhooks/:id/deliveries/:delivery_id/attempts", api.ListWebhookDeliveryAttempts)
+	api.gin.POST("/api/webhooks/:id/deliveries/:delivery_id/replay", api.ReplayWebhookDelivery)
+
+	// standing orders
+	api.gin.POST("/api/schedules", api.CreateScheduledTransfer)
+	api.gin.GET("/api/schedules", api.ListScheduledTransfers)
+	api.gin.GET("/api/schedules/:id", api.GetScheduledTransfer)
+	api.gin.PUT("/api/schedules/:id", api.UpdateScheduledTransfer)
+	api.gin.DELETE("/api/schedules/:id", api.DeleteScheduledTransfer)
+	api.gin.GET("/api/schedules/:id/runs", api.ListScheduledTransferRuns)
+
+	// the metrics and the log level are served by the AdminServer
 
 	// register swagger
 	docs.SwaggerInfo.Host = api.config.Listener
-	docs.SwaggerInfo.Schemes = []string{"http", "https"}
+	docs.SwaggerInfo.Schemes = []string{"http"}
+	if api.config.TLS.Enabled {
+		docs.SwaggerInfo.Schemes = []string{"https"}
+	}
 	api.gin.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 }
 
@@ -77,9 +127,20 @@ func (api *APIServer) Run() <-chan error {
 
 	go func() {
 		fmt.Println("Server listening at:", httpServer.Addr)
-		if err := httpServer.ListenAndServe(); err != nil {
+		if !api.config.TLS.Enabled {
+			errChan <- httpServer.ListenAndServe()
+			return
+		}
+
+		tlsCfg, err := tlsconfig.New(api.config.TLS)
+		if err != nil {
 			errChan <- err
+			return
 		}
+
+		// the certificates are served by tlsCfg, so they can be reloaded
+		httpServer.TLSConfig = tlsCfg
+		errChan <- httpServer.ListenAndServeTLS("", "")
 	}()
 
 	api.httpServer = httpServer
@@ -47,7 +47,8 @@ func TestAddBalance(t *testing.T) {
 	srv := gin.New()
 	srv.Use(AttachRequestID())
 	apiSrv := APIServer{
-		db: db,
+		db:      db,
+		ewallet: account.NewSimpleEWalletSystem(db),
 	}
 
 	srv.Handle(http.MethodPost, testURL, apiSrv.DepositRequest)
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/batch"
+)
+
+// ExecuteBatch gin handler
+// @Summary Executes many credits, debits and transfers at once
+// @Description In atomic mode the items are executed in 1 database transaction, all or nothing.
+// @Description In best_effort mode every item is executed on its own. The outcome of every item is returned.
+// @Tags API
+// @Param request body BatchRequest true "JSON body"
+// @Produce json
+// @Consume json
+// @Success 200 {object} BatchResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/transactions/batch [post]
+func (s *APIServer) ExecuteBatch(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ExecuteBatch"))
+
+	// Validate the request
+	req := new(BatchRequest)
+	err := c.ShouldBindWith(req, strictJSON)
+	if err != nil {
+		logger.Error("validation failed on request", "error", err)
+
+		abortWithBindError(c, err)
+		return
+	}
+
+	items := make([]account.BatchItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		items = append(items, account.BatchItem{
+			Type:           item.Type,
+			Username:       item.Username,
+			TargetUsername: item.TargetUsername,
+			Amount:         item.Amount,
+		})
+	}
+
+	manager := batch.NewManager(s.db, s.ewallet)
+	b, err := manager.Execute(req.Mode, items)
+	if err != nil {
+		logger.Error("failed to execute batch", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	logger.Info("batch executed", "batch_id", b.ID, "status", b.Status, "succeeded", b.Succeeded, "failed", b.Failed)
+	c.JSON(http.StatusOK, BatchResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Batch: toBatch(b),
+	})
+}
+
+// GetBatch gin handler
+// @Summary Gets the outcome of a batch
+// @Tags API
+// @Param id path string true "Batch ID"
+// @Produce json
+// @Success 200 {object} BatchResponse "Successful response"
+// @Success 404 {object} Problem "Batch Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/transactions/batch/{id} [get]
+func (s *APIServer) GetBatch(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "GetBatch"))
+
+	manager := batch.NewManager(s.db, s.ewallet)
+	b, err := manager.Get(c.Param("id"))
+	if err != nil {
+		logger.Error("failed to get batch", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, BatchResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Batch: toBatch(b),
+	})
+}
+
+func toBatch(b *batch.Batch) *Batch {
+	out := &Batch{
+		ID:        b.ID,
+		Mode:      b.Mode,
+		Status:    b.Status,
+		ItemCount: b.ItemCount,
+		Succeeded: b.Succeeded,
+		Failed:    b.Failed,
+		CreatedAt: b.CreatedAt,
+		Items:     make([]BatchItem, 0, len(b.Items)),
+	}
+	if b.CompletedAt.Valid {
+		out.CompletedAt = &b.CompletedAt.Time
+	}
+
+	for _, item := range b.Items {
+		out.Items = append(out.Items, BatchItem{
+			Index:               item.Index,
+			Type:                item.Type,
+			Username:            item.Username,
+			TargetUsername:      item.TargetUsername.String,
+			Amount:              item.Amount,
+			Status:              item.Status,
+			DebitTransactionID:  item.DebitTrxID.Int64,
+			CreditTransactionID: item.CreditTrxID.Int64,
+			Error:               item.Error.String,
+		})
+	}
+
+	return out
+}
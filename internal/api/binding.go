@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/yeyee2901/test/internal/account"
+)
+
+var errTrailingData = errors.New("api: unexpected data after the JSON body")
+
+// strictJSON is binding.JSON that also rejects the unknown fields and any
+// data after the JSON value, so a typo in a field name is not silently
+// ignored
+var strictJSON = strictJSONBinding{}
+
+type strictJSONBinding struct{}
+
+func (strictJSONBinding) Name() string {
+	return "strict_json"
+}
+
+func (b strictJSONBinding) Bind(req *http.Request, obj any) error {
+	if req == nil || req.Body == nil {
+		return io.EOF
+	}
+	return decodeStrictJSON(req.Body, obj)
+}
+
+func (strictJSONBinding) BindBody(body []byte, obj any) error {
+	return decodeStrictJSON(bytes.NewReader(body), obj)
+}
+
+func decodeStrictJSON(r io.Reader, obj any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(obj)
+	if err != nil {
+		// the decoder has no typed error for the unknown fields
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return &account.ValidationError{Fields: []account.FieldError{{
+				Field:   strings.Trim(field, `"`),
+				Message: "is not a known field",
+			}}}
+		}
+		return err
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errTrailingData
+	}
+
+	return binding.Validator.ValidateStruct(obj)
+}
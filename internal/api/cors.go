@@ -0,0 +1,146 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/config"
+)
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization", "X-Request-Id", "If-Match"}
+)
+
+// corsPolicy is a config.CORSPolicy with the header values joined once
+type corsPolicy struct {
+	pathPrefix  string
+	origins     []string
+	methods     string
+	headers     string
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+func newCORSPolicy(pathPrefix string, p config.CORSPolicy) corsPolicy {
+	methods, headers := p.AllowedMethods, p.AllowedHeaders
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	policy := corsPolicy{
+		pathPrefix:  pathPrefix,
+		origins:     p.AllowedOrigins,
+		methods:     strings.Join(methods, ", "),
+		headers:     strings.Join(headers, ", "),
+		exposed:     strings.Join(p.ExposedHeaders, ", "),
+		credentials: p.AllowCredentials,
+	}
+	if p.MaxAgeSeconds > 0 {
+		policy.maxAge = strconv.Itoa(p.MaxAgeSeconds)
+	}
+
+	return policy
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin of the origin, ""
+// when it is not allowed
+func (p *corsPolicy) allowOrigin(origin string) string {
+	for _, pattern := range p.origins {
+		switch {
+		case pattern == "*":
+			return "*"
+		case matchOrigin(pattern, origin):
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// matchOrigin matches the origin against an exact origin or a pattern
+// with 1 "*", e.g. https://*.example.com
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return pattern == origin
+	}
+
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) &&
+		strings.HasSuffix(origin, suffix)
+}
+
+// CORSMiddleware applies the CORS policy of the request path. The
+// preflight requests are answered here, without reaching the routes.
+func CORSMiddleware(cfg config.CORSConfig) gin.HandlerFunc {
+	policies := make([]corsPolicy, 0, len(cfg.Routes)+1)
+	for _, route := range cfg.Routes {
+		policies = append(policies, newCORSPolicy(route.PathPrefix, route.CORSPolicy))
+	}
+	// the longest prefix first, the default policy matches any path
+	sort.SliceStable(policies, func(i, j int) bool {
+		return len(policies[i].pathPrefix) > len(policies[j].pathPrefix)
+	})
+	policies = append(policies, newCORSPolicy("", cfg.CORSPolicy))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		var policy *corsPolicy
+		for i := range policies {
+			if strings.HasPrefix(c.Request.URL.Path, policies[i].pathPrefix) {
+				policy = &policies[i]
+				break
+			}
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		allowOrigin := policy.allowOrigin(origin)
+		if allowOrigin != "" {
+			header.Set("Access-Control-Allow-Origin", allowOrigin)
+			// browsers reject the credentials of a wildcard origin
+			if policy.credentials && allowOrigin != "*" {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if policy.exposed != "" && !preflight {
+				header.Set("Access-Control-Expose-Headers", policy.exposed)
+			}
+		}
+
+		if !preflight {
+			c.Next()
+			return
+		}
+
+		if allowOrigin != "" {
+			header.Set("Access-Control-Allow-Methods", policy.methods)
+			header.Set("Access-Control-Allow-Headers", policy.headers)
+			if policy.maxAge != "" {
+				header.Set("Access-Control-Max-Age", policy.maxAge)
+			}
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/internal/account"
+)
+
+// etag is the strong ETag of the account version
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// weakETag is the ETag of an account read from the cache or a replica, its
+// version may be behind the primary so it never matches If-Match
+func weakETag(version int64) string {
+	return "W/" + etag(version)
+}
+
+// noCache reports whether the request asks for a response validated by
+// the origin, i.e. read from the primary
+func noCache(c *gin.Context) bool {
+	for _, directive := range strings.Split(c.GetHeader("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+
+	return strings.EqualFold(strings.TrimSpace(c.GetHeader("Pragma")), "no-cache")
+}
+
+// ifMatch returns the account version required by the If-Match header,
+// ok is false when the request has no precondition. A header matching no
+// version of the account fails right away, without touching the account.
+func ifMatch(c *gin.Context, acc *account.Account) (version int64, ok bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	// weak ETags never match, If-Match uses the strong comparison
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag(acc.Version) {
+			return acc.Version, true, nil
+		}
+	}
+
+	return 0, false, account.ErrVersionMismatch.Detailf("account is at %s, If-Match is %s", etag(acc.Version), header)
+}
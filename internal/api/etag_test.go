@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/internal/account"
+)
+
+func TestIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	acc := &account.Account{Username: "alice", Version: 7}
+
+	tests := []struct {
+		name        string
+		header      string
+		wantChecked bool
+		wantErr     bool
+	}{
+		{"no precondition", "", false, false},
+		{"any version", "*", false, false},
+		{"current version", `"7"`, true, false},
+		{"1 of the listed versions", `"6", "7"`, true, false},
+		{"stale version", `"6"`, false, true},
+		{"weak ETag", `W/"7"`, false, true},
+		{"unquoted", `7`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/transactions/debit", nil)
+			if tt.header != "" {
+				c.Request.Header.Set("If-Match", tt.header)
+			}
+
+			version, checked, err := ifMatch(c, acc)
+			if checked != tt.wantChecked || (checked && version != 7) {
+				t.Errorf("ifMatch() = %d, %v, want checked %v", version, checked, tt.wantChecked)
+			}
+			if tt.wantErr != errors.Is(err, account.ErrVersionMismatch) {
+				t.Errorf("ifMatch() err = %v, want mismatch %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   bool
+	}{
+		{"no header", "", "", false},
+		{"no-cache", "Cache-Control", "no-cache", true},
+		{"among other directives", "Cache-Control", "max-age=0, No-Cache", true},
+		{"other directive", "Cache-Control", "no-store", false},
+		{"pragma", "Pragma", "no-cache", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/balance", nil)
+			if tt.header != "" {
+				c.Request.Header.Set(tt.header, tt.value)
+			}
+
+			if got := noCache(c); got != tt.want {
+				t.Errorf("noCache() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeakETagNeverMatches(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	acc := &account.Account{Username: "alice", Version: 7}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/transactions/debit", nil)
+	c.Request.Header.Set("If-Match", weakETag(acc.Version))
+
+	_, _, err := ifMatch(c, acc)
+	if !errors.Is(err, account.ErrVersionMismatch) {
+		t.Errorf("ifMatch() err = %v, want a mismatch", err)
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/internal/importer"
+)
+
+// maxImportFileSize caps the size of an uploaded import file
+const maxImportFileSize = 10 << 20
+
+// CreateImport gin handler
+// @Summary Uploads a CSV of adjustments to be imported asynchronously
+// @Description The file has the header "username,amount,type,reference", type being credit or debit.
+// @Description Every line is validated up front, an invalid file is rejected with the errors of every line.
+// @Description Uploading the same file again returns the job of the first upload, a reference is only ever imported once.
+// @Tags Import
+// @Accept multipart/form-data
+// @Param file formData file true "CSV file"
+// @Produce json
+// @Success 202 {object} ImportJobResponse "Accepted, the job is pending"
+// @Success 200 {object} ImportJobResponse "Already uploaded"
+// @Success 400 {object} Problem "Invalid file"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/imports [post]
+func (s *APIServer) CreateImport(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "CreateImport"))
+
+	// Validate the request
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		logger.Error("validation failed on request", "error", err)
+
+		abortWithBindError(c, err)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		logger.Error("failed to open uploaded file", "error", err)
+
+		abortWithError(c, err)
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		logger.Error("failed to read uploaded file", "error", err)
+
+		abortWithError(c, err)
+		return
+	}
+
+	manager := importer.NewManager(s.db, s.config.ImportMaxRows)
+	job, duplicate, err := manager.Create(fileHeader.Filename, content)
+	if err != nil {
+		logger.Error("failed to create import", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	code := http.StatusAccepted
+	if duplicate {
+		code = http.StatusOK
+	}
+
+	logger.Info("import accepted", "import_job_id", job.ID, "duplicate", duplicate)
+	c.JSON(code, ImportJobResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Job:       toImportJob(job),
+		Duplicate: duplicate,
+	})
+}
+
+// GetImport gin handler
+// @Summary Gets the status and progress of an import
+// @Tags Import
+// @Param id path string true "Import job ID"
+// @Produce json
+// @Success 200 {object} ImportJobResponse "Successful response"
+// @Success 404 {object} Problem "Import Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/imports/{id} [get]
+func (s *APIServer) GetImport(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "GetImport"))
+
+	manager := importer.NewManager(s.db, s.config.ImportMaxRows)
+	job, err := manager.Get(c.Param("id"))
+	if err != nil {
+		logger.Error("failed to get import", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ImportJobResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Job: toImportJob(job),
+	})
+}
+
+// GetImportResult gin handler
+// @Summary Downloads the outcome of every row of an import as CSV
+// @Tags Import
+// @Param id path string true "Import job ID"
+// @Produce text/csv
+// @Success 200 {file} file "Result file"
+// @Success 404 {object} Problem "Import Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/imports/{id}/result [get]
+func (s *APIServer) GetImportResult(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "GetImportResult"))
+
+	id := c.Param("id")
+	manager := importer.NewManager(s.db, s.config.ImportMaxRows)
+
+	buf := new(bytes.Buffer)
+	err := manager.WriteResult(id, buf)
+	if err != nil {
+		logger.Error("failed to write import result", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="import-`+id+`-result.csv"`)
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
+
+func toImportJob(job *importer.Job) *ImportJob {
+	out := &ImportJob{
+		ID:            job.ID,
+		Filename:      job.Filename,
+		Checksum:      job.Checksum,
+		Status:        job.Status,
+		TotalRows:     job.TotalRows,
+		ProcessedRows: job.ProcessedRows,
+		Succeeded:     job.Succeeded,
+		Failed:        job.Failed,
+		CreatedAt:     job.CreatedAt,
+	}
+	if job.StartedAt.Valid {
+		out.StartedAt = &job.StartedAt.Time
+	}
+	if job.CompletedAt.Valid {
+		out.CompletedAt = &job.CompletedAt.Time
+	}
+
+	return out
+}
@@ -1,37 +1,92 @@
 package api
 
 import (
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/tlsconfig"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
+// ContextKeyClient is the context key of the authenticated client, it is
+// set by the authentication of the request, if any
+const ContextKeyClient = "Client-Id"
+
+func AttachRequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, x-www-form-urlencoded")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		// check in header
+		reqID := c.GetHeader("X-Request-Id")
+		if reqID == "" {
+			reqID = uuid.NewString()
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
+		c.Set("X-Request-Id", reqID)
+		c.Header("X-Request-Id", reqID)
+		c.Next()
+	}
+}
+
+// AccessLog writes 1 record per request. The successful requests are
+// sampled, the failed and the slow ones are always logged. It must be the
+// first middleware, so that the panics recovered are logged as well.
+func AccessLog(cfg config.AccessLogConfig) gin.HandlerFunc {
+	slow := time.Duration(cfg.SlowThresholdMs) * time.Millisecond
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		latency := time.Since(start)
+
+		status := c.Writer.Status()
+		isSlow := slow > 0 && latency >= slow
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest, isSlow:
+			level = slog.LevelWarn
+		case rand.Float64() >= cfg.SampleRate:
 			return
 		}
 
-		c.Next()
+		attrs := []slog.Attr{
+			slog.String("request_id", c.GetString("X-Request-Id")),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(latency.Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if client := c.GetString(ContextKeyClient); client != "" {
+			attrs = append(attrs, slog.String("client", client))
+		}
+		if isSlow {
+			attrs = append(attrs, slog.Bool("slow", true))
+		}
+
+		slog.Default().LogAttrs(c.Request.Context(), level, "http request", attrs...)
 	}
 }
 
-func AttachRequestID() gin.HandlerFunc {
+// ClientIdentity maps the verified client certificate of the caller to
+// its API identity, set under ContextKeyClient. The certificates without
+// an identity are rejected.
+func ClientIdentity(identities map[string]string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// check in header
-		reqID := c.GetHeader("X-Request-Id")
-		if reqID == "" {
-			reqID = uuid.NewString()
+		identity, err := tlsconfig.Identity(c.Request.TLS, identities)
+		if err != nil {
+			abortWithError(c, err)
+			return
 		}
 
-		c.Set("X-Request-Id", reqID)
+		if identity != "" {
+			c.Set(ContextKeyClient, identity)
+		}
 		c.Next()
 	}
 }
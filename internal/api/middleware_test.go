@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/config"
+)
+
+func TestAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	buf := new(bytes.Buffer)
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	// no successful request is sampled, only the failed & slow ones are
+	// logged
+	r := gin.New()
+	r.Use(AccessLog(config.AccessLogConfig{SampleRate: 0, SlowThresholdMs: 20}))
+	r.Use(gin.Recovery())
+	r.Use(AttachRequestID())
+	r.GET("/ok/:id", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/slow", func(c *gin.Context) {
+		time.Sleep(25 * time.Millisecond)
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/missing", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	tests := []struct {
+		path      string
+		wantLevel string
+		wantRoute string
+	}{
+		{"/ok/1", "", ""},
+		{"/slow", "WARN", "/slow"},
+		{"/missing", "WARN", "/missing"},
+		{"/panic", "ERROR", "/panic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-Request-Id", "req-1")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			if tt.wantLevel == "" {
+				if buf.Len() != 0 {
+					t.Errorf("logged %s, want the request sampled out", buf.String())
+				}
+				return
+			}
+
+			record := map[string]any{}
+			err := json.Unmarshal(buf.Bytes(), &record)
+			if err != nil {
+				t.Fatalf("invalid record %q: %v", buf.String(), err)
+			}
+			if record["level"] != tt.wantLevel || record["route"] != tt.wantRoute || record["request_id"] != "req-1" {
+				t.Errorf("record = %v", record)
+			}
+		})
+	}
+
+	// every successful request is logged at full sampling
+	buf.Reset()
+	r = gin.New()
+	r.Use(AccessLog(config.AccessLogConfig{SampleRate: 1}))
+	r.GET("/ok/:id", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok/1", nil))
+
+	record := map[string]any{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	if err != nil {
+		t.Fatalf("invalid record %q: %v", buf.String(), err)
+	}
+	if record["route"] != "/ok/:id" || record["status"] != float64(200) || record["bytes"] != float64(2) {
+		t.Errorf("record = %v", record)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(CORSMiddleware(config.CORSConfig{
+		CORSPolicy: config.CORSPolicy{
+			AllowedOrigins:   []string{"http://localhost:3000", "https://*.example.com"},
+			AllowedMethods:   []string{"GET", "POST", "PUT"},
+			ExposedHeaders:   []string{"X-Request-Id"},
+			AllowCredentials: true,
+			MaxAgeSeconds:    600,
+		},
+		Routes: []config.CORSRoute{
+			{PathPrefix: "/swagger/", CORSPolicy: config.CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}},
+		},
+	}))
+	r.GET("/api/balance", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/swagger/index.html", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		origin          string
+		preflight       bool
+		wantStatus      int
+		wantOrigin      string
+		wantCredentials string
+		wantMaxAge      string
+		wantExposed     string
+	}{
+		{"exact origin", http.MethodGet, "/api/balance", "http://localhost:3000", false, http.StatusOK, "http://localhost:3000", "true", "", "X-Request-Id"},
+		{"pattern origin", http.MethodGet, "/api/balance", "https://app.example.com", false, http.StatusOK, "https://app.example.com", "true", "", "X-Request-Id"},
+		{"pattern needs a subdomain", http.MethodGet, "/api/balance", "https://.example.com", false, http.StatusOK, "", "", "", ""},
+		{"unknown origin", http.MethodGet, "/api/balance", "https://evil.com", false, http.StatusOK, "", "", "", ""},
+		{"preflight", http.MethodOptions, "/api/balance", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com", "true", "600", ""},
+		{"preflight of unknown origin", http.MethodOptions, "/api/balance", "https://evil.com", true, http.StatusNoContent, "", "", "", ""},
+		{"route override never sends credentials with *", http.MethodGet, "/swagger/index.html", "https://evil.com", false, http.StatusOK, "*", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			h := resp.Header()
+			if resp.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.Code, tt.wantStatus)
+			}
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			if got := h.Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Errorf("Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+			if got := h.Get("Access-Control-Expose-Headers"); got != tt.wantExposed {
+				t.Errorf("Expose-Headers = %q, want %q", got, tt.wantExposed)
+			}
+			if h.Get("Vary") != "Origin" {
+				t.Errorf("Vary = %v, want Origin first", h.Values("Vary"))
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "time"
+
 type APIBaseResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message,omitempty"`
@@ -7,11 +9,14 @@ type APIBaseResponse struct {
 
 type GetBalanceResponse struct {
 	Balance float64 `json:"balance"`
+
+	// At is the time of a past balance
+	At *time.Time `json:"at,omitempty"`
 }
 
 type DepositRequest struct {
 	Username string  `json:"username" binding:"required"`
-	Amount   float64 `json:"amount"  binding:"required"`
+	Amount   float64 `json:"amount" binding:"required,gt=0"`
 }
 
 type DepositResponse struct {
@@ -22,12 +27,227 @@ type DepositResponse struct {
 
 type WithdrawRequest struct {
 	Username string  `json:"username" binding:"required"`
-	Amount   float64 `json:"amount"  binding:"required"`
+	Amount   float64 `json:"amount" binding:"required,gt=0"`
 }
 
 type WithdrawResponse struct {
 	APIBaseResponse
 	UserID        int     `json:"user_id,omitempty"`
 	TransactionID int     `json:"transaction_id,omitempty"`
+	Fee           float64 `json:"fee,omitempty"`
 	NewBalance    float64 `json:"new_balance,omitempty"`
 }
+
+type QuoteFeeRequest struct {
+	Username string  `form:"username" binding:"required"`
+	Type     string  `form:"type" binding:"required,oneof=withdrawal transfer"`
+	Amount   float64 `form:"amount" binding:"required,gt=0"`
+}
+
+type QuoteFeeResponse struct {
+	APIBaseResponse
+	Amount float64 `json:"amount"`
+	Fee    float64 `json:"fee"`
+	Total  float64 `json:"total"`
+}
+
+type ListTransactionsRequest struct {
+	Username string `form:"username" binding:"required"`
+	Limit    int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	BeforeID int    `form:"before_id" binding:"omitempty,min=1"`
+}
+
+type Transaction struct {
+	ID        int       `json:"id"`
+	Amount    float64   `json:"amount"`
+	Type      string    `json:"type"`
+	ParentID  *int      `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ListTransactionsResponse struct {
+	APIBaseResponse
+	Transactions []Transaction `json:"transactions"`
+}
+
+type BatchRequest struct {
+	Mode  string             `json:"mode" binding:"required,oneof=atomic best_effort"`
+	Items []BatchItemRequest `json:"items" binding:"required,min=1,max=10000,dive"`
+}
+
+type BatchItemRequest struct {
+	Type           string  `json:"type" binding:"required,oneof=credit debit transfer"`
+	Username       string  `json:"username" binding:"required"`
+	TargetUsername string  `json:"target_username" binding:"required_if=Type transfer"`
+	Amount         float64 `json:"amount" binding:"required,gt=0"`
+}
+
+type Batch struct {
+	ID          string      `json:"id"`
+	Mode        string      `json:"mode"`
+	Status      string      `json:"status"`
+	ItemCount   int         `json:"item_count"`
+	Succeeded   int         `json:"succeeded"`
+	Failed      int         `json:"failed"`
+	CreatedAt   time.Time   `json:"created_at"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+	Items       []BatchItem `json:"items"`
+}
+
+type BatchItem struct {
+	Index               int     `json:"index"`
+	Type                string  `json:"type"`
+	Username            string  `json:"username"`
+	TargetUsername      string  `json:"target_username,omitempty"`
+	Amount              float64 `json:"amount"`
+	Status              string  `json:"status"`
+	DebitTransactionID  int64   `json:"debit_transaction_id,omitempty"`
+	CreditTransactionID int64   `json:"credit_transaction_id,omitempty"`
+	Error               string  `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	APIBaseResponse
+	Batch *Batch `json:"batch,omitempty"`
+}
+
+type ImportJob struct {
+	ID            string     `json:"id"`
+	Filename      string     `json:"filename"`
+	Checksum      string     `json:"checksum"`
+	Status        string     `json:"status"`
+	TotalRows     int        `json:"total_rows"`
+	ProcessedRows int        `json:"processed_rows"`
+	Succeeded     int        `json:"succeeded"`
+	Failed        int        `json:"failed"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
+
+type ImportJobResponse struct {
+	APIBaseResponse
+	Job *ImportJob `json:"job,omitempty"`
+
+	// Duplicate is set when the file was already uploaded, Job is then
+	// the job of the first upload
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required,url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+}
+
+type WebhookSubscription struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CreateWebhookResponse struct {
+	APIBaseResponse
+	Subscription *WebhookSubscription `json:"subscription,omitempty"`
+
+	// Secret is only returned once, on creation
+	Secret string `json:"secret,omitempty"`
+}
+
+type GetWebhookResponse struct {
+	APIBaseResponse
+	Subscription *WebhookSubscription `json:"subscription,omitempty"`
+}
+
+type ListWebhooksResponse struct {
+	APIBaseResponse
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+}
+
+type WebhookDelivery struct {
+	ID            int64     `json:"id"`
+	EventID       int64     `json:"event_id"`
+	EventType     string    `json:"event_type"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type ListWebhookDeliveriesResponse struct {
+	APIBaseResponse
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+type WebhookDeliveryAttempt struct {
+	ID         int64     `json:"id"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int       `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ListWebhookDeliveryAttemptsResponse struct {
+	APIBaseResponse
+	Attempts []WebhookDeliveryAttempt `json:"attempts"`
+}
+
+type CreateScheduledTransferRequest struct {
+	SourceUsername       string  `json:"source_username" binding:"required"`
+	TargetUsername       string  `json:"target_username" binding:"required_if=Type transfer"`
+	Type                 string  `json:"type" binding:"required,oneof=transfer debit"`
+	Amount               float64 `json:"amount" binding:"required,gt=0"`
+	Schedule             string  `json:"schedule" binding:"required"`
+	MaxRetries           int     `json:"max_retries" binding:"gte=0"`
+	RetryIntervalSeconds int     `json:"retry_interval_seconds" binding:"gte=0"`
+}
+
+type UpdateScheduledTransferRequest struct {
+	Amount               *float64 `json:"amount" binding:"omitempty,gt=0"`
+	Schedule             *string  `json:"schedule"`
+	Active               *bool    `json:"active"`
+	MaxRetries           *int     `json:"max_retries" binding:"omitempty,gte=0"`
+	RetryIntervalSeconds *int     `json:"retry_interval_seconds" binding:"omitempty,gte=0"`
+}
+
+type ScheduledTransfer struct {
+	ID                   int       `json:"id"`
+	SourceUsername       string    `json:"source_username"`
+	TargetUsername       string    `json:"target_username,omitempty"`
+	Type                 string    `json:"type"`
+	Amount               float64   `json:"amount"`
+	Schedule             string    `json:"schedule"`
+	Active               bool      `json:"active"`
+	MaxRetries           int       `json:"max_retries"`
+	RetryIntervalSeconds int       `json:"retry_interval_seconds"`
+	NextRunAt            time.Time `json:"next_run_at"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+type ScheduledTransferResponse struct {
+	APIBaseResponse
+	ScheduledTransfer *ScheduledTransfer `json:"scheduled_transfer,omitempty"`
+}
+
+type ListScheduledTransfersResponse struct {
+	APIBaseResponse
+	ScheduledTransfers []ScheduledTransfer `json:"scheduled_transfers"`
+}
+
+type ScheduledTransferRun struct {
+	ID                  int64     `json:"id"`
+	OccurrenceAt        time.Time `json:"occurrence_at"`
+	Attempt             int       `json:"attempt"`
+	Status              string    `json:"status"`
+	Error               string    `json:"error,omitempty"`
+	DebitTransactionID  int64     `json:"debit_transaction_id,omitempty"`
+	CreditTransactionID int64     `json:"credit_transaction_id,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+type ListScheduledTransferRunsResponse struct {
+	APIBaseResponse
+	Runs []ScheduledTransferRun `json:"runs"`
+}
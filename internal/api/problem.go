@@ -0,0 +1,288 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/batch"
+	"github.com/yeyee2901/test/internal/importer"
+	"github.com/yeyee2901/test/internal/scheduler"
+	"github.com/yeyee2901/test/internal/tlsconfig"
+	"github.com/yeyee2901/test/internal/webhook"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes the code into the problem type URI
+const problemTypePrefix = "urn:simple-account:problem:"
+
+// codes of the failures that are not account domain errors, they are as
+// stable as the account codes
+const (
+	codeInternal  = "internal_error"
+	codeForbidden = "forbidden"
+)
+
+// Problem is an RFC 7807 problem details response. Code is the stable,
+// machine readable error code, the other members are for humans.
+type Problem struct {
+	Type      string         `json:"type"`
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Instance  string         `json:"instance,omitempty"`
+	Code      string         `json:"code"`
+	RequestID string         `json:"request_id,omitempty"`
+	Errors    []ProblemField `json:"errors,omitempty"`
+}
+
+// ProblemField is 1 invalid field of a validation problem. Line is set
+// for the errors of an uploaded file.
+type ProblemField struct {
+	Field   string `json:"field,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+var problemTitles = map[string]string{
+	string(account.CodeNotFound):          "Resource not found",
+	string(account.CodeInsufficientFunds): "Insufficient funds",
+	string(account.CodeValidation):        "Validation failed",
+	string(account.CodeVersionMismatch):   "Account changed",
+	string(account.CodeBusy):              "Account busy",
+	codeInternal:                          "Internal server error",
+	codeForbidden:                         "Forbidden",
+}
+
+var problemStatuses = map[account.Code]int{
+	account.CodeNotFound:          http.StatusNotFound,
+	account.CodeInsufficientFunds: http.StatusUnprocessableEntity,
+	account.CodeValidation:        http.StatusBadRequest,
+	account.CodeVersionMismatch:   http.StatusPreconditionFailed,
+	account.CodeBusy:              http.StatusServiceUnavailable,
+}
+
+func newProblem(status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypePrefix + code,
+		Title:  problemTitles[code],
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// abortWithError aborts the request, the error is rendered by ErrorHandler
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
+
+// abortWithBindError aborts the request on an invalid input, the error
+// is rendered as a validation problem by ErrorHandler
+func abortWithBindError(c *gin.Context, err error) {
+	c.Error(err).SetType(gin.ErrorTypeBind)
+	c.Abort()
+}
+
+// errMissingField is the validation error of a required field that is
+// read by hand, e.g. from the query
+func errMissingField(field string) error {
+	return &account.ValidationError{Fields: []account.FieldError{{Field: field, Message: "is required"}}}
+}
+
+// errInvalidField is the validation error of a field read by hand
+func errInvalidField(field, message string) error {
+	return &account.ValidationError{Fields: []account.FieldError{{Field: field, Message: message}}}
+}
+
+// ErrorHandler renders the last error of the request as a problem
+// details response, unless the handler already responded
+func ErrorHandler() gin.HandlerFunc {
+	registerJSONFieldNames()
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		ginErr := c.Errors.Last()
+		var problem *Problem
+		if ginErr.IsType(gin.ErrorTypeBind) {
+			problem = bindProblem(ginErr.Err)
+		} else {
+			problem = errorProblem(ginErr.Err)
+		}
+
+		problem.Instance = c.Request.URL.Path
+		problem.RequestID = c.GetString("X-Request-Id")
+		if problem.Status == http.StatusInternalServerError {
+			slog.Error("request failed", "request_id", problem.RequestID, "path", problem.Instance, "error", ginErr.Err)
+		}
+
+		c.Header("Content-Type", problemContentType)
+		c.JSON(problem.Status, problem)
+	}
+}
+
+// errorProblem maps the errors of the domain packages
+func errorProblem(err error) *Problem {
+	var importErr *importer.ValidationError
+	if errors.As(err, &importErr) {
+		problem := newProblem(http.StatusBadRequest, string(account.CodeValidation), "invalid import file")
+		for _, rowErr := range importErr.Errors {
+			problem.Errors = append(problem.Errors, ProblemField{Line: rowErr.Line, Message: rowErr.Message})
+		}
+		return problem
+	}
+
+	var validationErr *account.ValidationError
+	if errors.As(err, &validationErr) {
+		problem := newProblem(http.StatusBadRequest, string(account.CodeValidation), "invalid request")
+		for _, f := range validationErr.Fields {
+			problem.Errors = append(problem.Errors, ProblemField{Field: f.Field, Message: f.Message})
+		}
+		return problem
+	}
+
+	var domainErr *account.Error
+	if errors.As(err, &domainErr) {
+		return newProblem(problemStatuses[domainErr.Code], string(domainErr.Code), domainErr.Message)
+	}
+
+	switch {
+	case errors.Is(err, webhook.ErrNotFound):
+		return newProblem(http.StatusNotFound, string(account.CodeNotFound), "webhook data not found")
+
+	case errors.Is(err, scheduler.ErrNotFound):
+		return newProblem(http.StatusNotFound, string(account.CodeNotFound), "scheduled transfer not found")
+
+	case errors.Is(err, batch.ErrNotFound):
+		return newProblem(http.StatusNotFound, string(account.CodeNotFound), "batch not found")
+
+	case errors.Is(err, importer.ErrNotFound):
+		return newProblem(http.StatusNotFound, string(account.CodeNotFound), "import not found")
+
+	case errors.Is(err, tlsconfig.ErrUnknownClient):
+		return newProblem(http.StatusForbidden, codeForbidden, "the client certificate is not allowed")
+
+	case errors.Is(err, scheduler.ErrInvalidSchedule),
+		errors.Is(err, scheduler.ErrInvalidType),
+		errors.Is(err, batch.ErrInvalidMode):
+		return newProblem(http.StatusBadRequest, string(account.CodeValidation), err.Error())
+	}
+
+	return newProblem(http.StatusInternalServerError, codeInternal, "internal server error")
+}
+
+// bindProblem maps the errors of ShouldBind*, with the invalid fields
+func bindProblem(err error) *Problem {
+	problem := newProblem(http.StatusBadRequest, string(account.CodeValidation), "invalid request")
+
+	var fieldErrs validator.ValidationErrors
+	var validationErr *account.ValidationError
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &validationErr):
+		for _, f := range validationErr.Fields {
+			problem.Errors = append(problem.Errors, ProblemField{Field: f.Field, Message: f.Message})
+		}
+
+	case errors.As(err, &fieldErrs):
+		for _, fieldErr := range fieldErrs {
+			problem.Errors = append(problem.Errors, ProblemField{
+				Field:   fieldPath(fieldErr.Namespace()),
+				Message: fieldMessage(fieldErr),
+			})
+		}
+
+	case errors.As(err, &typeErr):
+		problem.Errors = append(problem.Errors, ProblemField{
+			Field:   typeErr.Field,
+			Message: "must be a " + typeErr.Type.String(),
+		})
+
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		problem.Detail = "malformed JSON body"
+
+	case errors.Is(err, io.EOF):
+		problem.Detail = "the request body is empty"
+
+	case errors.Is(err, errTrailingData):
+		problem.Detail = "unexpected data after the JSON body"
+
+	default:
+		problem.Detail = err.Error()
+	}
+
+	return problem
+}
+
+// fieldPath drops the struct name of the validator namespace, e.g.
+// "BatchRequest.items[0].amount" becomes "items[0].amount"
+func fieldPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
+
+func fieldMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "required_if":
+		return "is required here"
+	case "oneof":
+		return "must be one of: " + fieldErr.Param()
+	case "gt":
+		return "must be greater than " + fieldErr.Param()
+	case "gte", "min":
+		return "must be at least " + fieldErr.Param()
+	case "lte", "max":
+		return "must be at most " + fieldErr.Param()
+	case "url":
+		return "must be a URL"
+	default:
+		return "failed the " + fieldErr.Tag() + " rule"
+	}
+}
+
+var registerFieldNamesOnce sync.Once
+
+// registerJSONFieldNames makes the validator report the JSON (or query)
+// names of the fields instead of the Go names
+func registerJSONFieldNames() {
+	registerFieldNamesOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			for _, tag := range []string{"json", "form", "uri"} {
+				name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+				if name == "-" {
+					return ""
+				}
+				if name != "" {
+					return name
+				}
+			}
+			return f.Name
+		})
+	})
+}
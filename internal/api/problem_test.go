@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/internal/account"
+)
+
+func newProblemTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(AttachRequestID())
+	r.Use(ErrorHandler())
+	r.POST("/deposit", func(c *gin.Context) {
+		req := new(DepositRequest)
+		err := c.ShouldBindWith(req, strictJSON)
+		if err != nil {
+			abortWithBindError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, APIBaseResponse{Status: "success"})
+	})
+	r.GET("/fail", func(c *gin.Context) {
+		switch c.Query("kind") {
+		case "not_found":
+			abortWithError(c, account.ErrNotFound.Detailf("user not found"))
+		case "insufficient":
+			abortWithError(c, fmt.Errorf("debit: %w", account.ErrInsufficient))
+		case "missing":
+			abortWithError(c, errMissingField("username"))
+		default:
+			abortWithError(c, fmt.Errorf("connection refused"))
+		}
+	})
+
+	return r
+}
+
+func TestErrorHandler(t *testing.T) {
+	r := newProblemTestRouter()
+
+	cases := []struct {
+		name       string
+		req        *http.Request
+		wantStatus int
+		wantCode   string
+		wantDetail string
+		wantFields []string
+	}{
+		{
+			name:       "not found",
+			req:        httptest.NewRequest(http.MethodGet, "/fail?kind=not_found", nil),
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+			wantDetail: "user not found",
+		},
+		{
+			name:       "insufficient funds",
+			req:        httptest.NewRequest(http.MethodGet, "/fail?kind=insufficient", nil),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   "insufficient_funds",
+			wantDetail: "insufficient funds",
+		},
+		{
+			name:       "missing query field",
+			req:        httptest.NewRequest(http.MethodGet, "/fail?kind=missing", nil),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation_failed",
+			wantFields: []string{"username"},
+		},
+		{
+			name:       "internal error is not leaked",
+			req:        httptest.NewRequest(http.MethodGet, "/fail", nil),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   "internal_error",
+			wantDetail: "internal server error",
+		},
+		{
+			name:       "invalid fields",
+			req:        httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{}`)),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation_failed",
+			wantFields: []string{"username", "amount"},
+		},
+		{
+			name:       "wrong type",
+			req:        httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"username":"bob","amount":"ten"}`)),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation_failed",
+			wantFields: []string{"amount"},
+		},
+		{
+			name:       "negative amount",
+			req:        httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"username":"bob","amount":-10}`)),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation_failed",
+			wantFields: []string{"amount"},
+		},
+		{
+			name:       "unknown field",
+			req:        httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"username":"bob","amount":10,"ammount":10}`)),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation_failed",
+			wantFields: []string{"ammount"},
+		},
+		{
+			name:       "trailing data",
+			req:        httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"username":"bob","amount":10}{"amount":99}`)),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation_failed",
+			wantDetail: "unexpected data after the JSON body",
+		},
+		{
+			name:       "malformed body",
+			req:        httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"username":`)),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "validation_failed",
+			wantDetail: "malformed JSON body",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.req.Header.Set("X-Request-Id", "req-1")
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, tc.req)
+
+			if resp.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.Code, tc.wantStatus)
+			}
+			if ct := resp.Header().Get("Content-Type"); ct != problemContentType {
+				t.Fatalf("content type = %q, want %q", ct, problemContentType)
+			}
+
+			problem := new(Problem)
+			err := json.Unmarshal(resp.Body.Bytes(), problem)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if problem.Code != tc.wantCode {
+				t.Errorf("code = %q, want %q", problem.Code, tc.wantCode)
+			}
+			if problem.Type != problemTypePrefix+tc.wantCode {
+				t.Errorf("type = %q", problem.Type)
+			}
+			if problem.Status != tc.wantStatus {
+				t.Errorf("problem status = %d, want %d", problem.Status, tc.wantStatus)
+			}
+			if tc.wantDetail != "" && problem.Detail != tc.wantDetail {
+				t.Errorf("detail = %q, want %q", problem.Detail, tc.wantDetail)
+			}
+			if problem.RequestID != "req-1" {
+				t.Errorf("request id = %q", problem.RequestID)
+			}
+			if problem.Instance != tc.req.URL.Path {
+				t.Errorf("instance = %q, want %q", problem.Instance, tc.req.URL.Path)
+			}
+
+			fields := make([]string, 0, len(problem.Errors))
+			for _, f := range problem.Errors {
+				fields = append(fields, f.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tc.wantFields, ",") {
+				t.Errorf("fields = %v, want %v", fields, tc.wantFields)
+			}
+		})
+	}
+}
+
+func TestListLimitValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// the limit is rejected before the database is used
+	s := &APIServer{}
+	r := gin.New()
+	r.Use(ErrorHandler())
+	r.GET("/webhooks/:id/deliveries", s.ListWebhookDeliveries)
+	r.GET("/schedules/:id/runs", s.ListScheduledTransferRuns)
+
+	for _, path := range []string{
+		"/webhooks/1/deliveries?limit=0",
+		"/webhooks/1/deliveries?limit=-5",
+		"/schedules/1/runs?limit=0",
+		"/schedules/1/runs?limit=-5",
+	} {
+		t.Run(path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if resp.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", resp.Code, http.StatusBadRequest)
+			}
+
+			problem := new(Problem)
+			err := json.Unmarshal(resp.Body.Bytes(), problem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problem.Errors) != 1 || problem.Errors[0].Field != "limit" {
+				t.Errorf("errors = %+v, want limit", problem.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/internal/scheduler"
+)
+
+const defaultRunsLimit = 20
+
+// CreateScheduledTransfer gin handler
+// @Summary Creates a standing order (recurring transfer or debit)
+// @Description The schedule is either a 5-field cron expression, or one of
+// @Description "@daily [HH:MM]", "@weekly MON [HH:MM]", "@monthly 25 [HH:MM]" (UTC)
+// @Tags Scheduler
+// @Param request body CreateScheduledTransferRequest true "JSON body"
+// @Produce json
+// @Consume json
+// @Success 200 {object} ScheduledTransferResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "User Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/schedules [post]
+func (s *APIServer) CreateScheduledTransfer(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "CreateScheduledTransfer"))
+
+	// Validate the request
+	req := new(CreateScheduledTransferRequest)
+	err := c.ShouldBindWith(req, strictJSON)
+	if err != nil {
+		logger.Error("validation failed on request", "error", err)
+
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := scheduler.NewManager(s.db, s.ewallet)
+	st, err := manager.Create(scheduler.NewScheduledTransfer{
+		SourceUsername:       req.SourceUsername,
+		TargetUsername:       req.TargetUsername,
+		Type:                 req.Type,
+		Amount:               req.Amount,
+		Schedule:             req.Schedule,
+		MaxRetries:           req.MaxRetries,
+		RetryIntervalSeconds: req.RetryIntervalSeconds,
+	})
+	if err != nil {
+		logger.Error("failed to create scheduled transfer", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ScheduledTransferResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		ScheduledTransfer: toScheduledTransfer(st),
+	})
+}
+
+// ListScheduledTransfers gin handler
+// @Summary Lists the standing orders of a user
+// @Tags Scheduler
+// @Param username query string true "Source username"
+// @Produce json
+// @Success 200 {object} ListScheduledTransfersResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/schedules [get]
+func (s *APIServer) ListScheduledTransfers(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ListScheduledTransfers"))
+
+	username := c.Query("username")
+	if username == "" {
+		abortWithError(c, errMissingField("username"))
+		return
+	}
+
+	manager := scheduler.NewManager(s.db, s.ewallet)
+	list, err := manager.List(username)
+	if err != nil {
+		logger.Error("failed to list scheduled transfers", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	resp := ListScheduledTransfersResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		ScheduledTransfers: make([]ScheduledTransfer, 0, len(list)),
+	}
+	for i := range list {
+		resp.ScheduledTransfers = append(resp.ScheduledTransfers, *toScheduledTransfer(&list[i]))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetScheduledTransfer gin handler
+// @Summary Gets a standing order
+// @Tags Scheduler
+// @Param id path int true "Scheduled transfer ID"
+// @Produce json
+// @Success 200 {object} ScheduledTransferResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "Scheduled Transfer Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/schedules/{id} [get]
+func (s *APIServer) GetScheduledTransfer(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "GetScheduledTransfer"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := scheduler.NewManager(s.db, s.ewallet)
+	st, err := manager.Get(id)
+	if err != nil {
+		logger.Error("failed to retrieve scheduled transfer", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ScheduledTransferResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		ScheduledTransfer: toScheduledTransfer(st),
+	})
+}
+
+// UpdateScheduledTransfer gin handler
+// @Summary Updates a standing order, omitted fields are left untouched
+// @Tags Scheduler
+// @Param id path int true "Scheduled transfer ID"
+// @Param request body UpdateScheduledTransferRequest true "JSON body"
+// @Produce json
+// @Consume json
+// @Success 200 {object} ScheduledTransferResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "Scheduled Transfer Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/schedules/{id} [put]
+func (s *APIServer) UpdateScheduledTransfer(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "UpdateScheduledTransfer"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	// Validate the request
+	req := new(UpdateScheduledTransferRequest)
+	err = c.ShouldBindWith(req, strictJSON)
+	if err != nil {
+		logger.Error("validation failed on request", "error", err)
+
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := scheduler.NewManager(s.db, s.ewallet)
+	st, err := manager.Update(id, scheduler.ScheduledTransferUpdate{
+		Amount:               req.Amount,
+		Schedule:             req.Schedule,
+		Active:               req.Active,
+		MaxRetries:           req.MaxRetries,
+		RetryIntervalSeconds: req.RetryIntervalSeconds,
+	})
+	if err != nil {
+		logger.Error("failed to update scheduled transfer", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ScheduledTransferResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		ScheduledTransfer: toScheduledTransfer(st),
+	})
+}
+
+// DeleteScheduledTransfer gin handler
+// @Summary Deletes a standing order along with its runs
+// @Tags Scheduler
+// @Param id path int true "Scheduled transfer ID"
+// @Produce json
+// @Success 200 {object} APIBaseResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "Scheduled Transfer Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/schedules/{id} [delete]
+func (s *APIServer) DeleteScheduledTransfer(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "DeleteScheduledTransfer"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := scheduler.NewManager(s.db, s.ewallet)
+	err = manager.Delete(id)
+	if err != nil {
+		logger.Error("failed to delete scheduled transfer", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIBaseResponse{
+		Status: "success",
+	})
+}
+
+// ListScheduledTransferRuns gin handler
+// @Summary Lists the latest runs of a standing order
+// @Tags Scheduler
+// @Param id path int true "Scheduled transfer ID"
+// @Param limit query int false "Max number of runs"
+// @Produce json
+// @Success 200 {object} ListScheduledTransferRunsResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/schedules/{id}/runs [get]
+func (s *APIServer) ListScheduledTransferRuns(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ListScheduledTransferRuns"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	limit := defaultRunsLimit
+	if l := c.Query("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			abortWithBindError(c, err)
+			return
+		}
+		if limit <= 0 {
+			abortWithError(c, errInvalidField("limit", "must be positive"))
+			return
+		}
+	}
+
+	manager := scheduler.NewManager(s.db, s.ewallet)
+	runs, err := manager.ListRuns(id, limit)
+	if err != nil {
+		logger.Error("failed to list scheduled transfer runs", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	resp := ListScheduledTransferRunsResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Runs: make([]ScheduledTransferRun, 0, len(runs)),
+	}
+	for _, r := range runs {
+		resp.Runs = append(resp.Runs, ScheduledTransferRun{
+			ID:                  r.ID,
+			OccurrenceAt:        r.OccurrenceAt,
+			Attempt:             r.Attempt,
+			Status:              r.Status,
+			Error:               r.Error.String,
+			DebitTransactionID:  r.DebitTrxID.Int64,
+			CreditTransactionID: r.CreditTrxID.Int64,
+			CreatedAt:           r.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func toScheduledTransfer(st *scheduler.ScheduledTransfer) *ScheduledTransfer {
+	return &ScheduledTransfer{
+		ID:                   st.ID,
+		SourceUsername:       st.SourceUsername,
+		TargetUsername:       st.TargetUsername.String,
+		Type:                 st.Type,
+		Amount:               st.Amount,
+		Schedule:             st.Schedule,
+		Active:               st.Active,
+		MaxRetries:           st.MaxRetries,
+		RetryIntervalSeconds: st.RetryIntervalSeconds,
+		NextRunAt:            st.NextRunAt,
+		CreatedAt:            st.CreatedAt,
+	}
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeyee2901/test/internal/webhook"
+)
+
+const defaultDeliveriesLimit = 20
+
+// CreateWebhook gin handler
+// @Summary Registers a new webhook subscription
+// @Tags Webhook
+// @Param request body CreateWebhookRequest true "JSON body"
+// @Produce json
+// @Consume json
+// @Success 200 {object} CreateWebhookResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/webhooks [post]
+func (s *APIServer) CreateWebhook(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "CreateWebhook"))
+
+	// Validate the request
+	req := new(CreateWebhookRequest)
+	err := c.ShouldBindWith(req, strictJSON)
+	if err != nil {
+		logger.Error("validation failed on request", "error", err)
+
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := webhook.NewManager(s.db)
+	sub, err := manager.CreateSubscription(req.URL, req.Secret, req.EventTypes)
+	if err != nil {
+		logger.Error("failed to create subscription", "error", err)
+
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, CreateWebhookResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Subscription: toWebhookSubscription(sub),
+		Secret:       sub.Secret,
+	})
+}
+
+// ListWebhooks gin handler
+// @Summary Lists the webhook subscriptions
+// @Tags Webhook
+// @Produce json
+// @Success 200 {object} ListWebhooksResponse "Successful response"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/webhooks [get]
+func (s *APIServer) ListWebhooks(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ListWebhooks"))
+
+	manager := webhook.NewManager(s.db)
+	subs, err := manager.ListSubscriptions()
+	if err != nil {
+		logger.Error("failed to list subscriptions", "error", err)
+
+		abortWithError(c, err)
+		return
+	}
+
+	resp := ListWebhooksResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Subscriptions: make([]WebhookSubscription, 0, len(subs)),
+	}
+	for i := range subs {
+		resp.Subscriptions = append(resp.Subscriptions, *toWebhookSubscription(&subs[i]))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetWebhook gin handler
+// @Summary Gets a webhook subscription
+// @Tags Webhook
+// @Param id path int true "Subscription ID"
+// @Produce json
+// @Success 200 {object} GetWebhookResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "Subscription Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/webhooks/{id} [get]
+func (s *APIServer) GetWebhook(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "GetWebhook"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := webhook.NewManager(s.db)
+	sub, err := manager.GetSubscription(id)
+	if err != nil {
+		logger.Error("failed to retrieve subscription", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, GetWebhookResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Subscription: toWebhookSubscription(sub),
+	})
+}
+
+// DeleteWebhook gin handler
+// @Summary Deletes a webhook subscription along with its deliveries
+// @Tags Webhook
+// @Param id path int true "Subscription ID"
+// @Produce json
+// @Success 200 {object} APIBaseResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "Subscription Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/webhooks/{id} [delete]
+func (s *APIServer) DeleteWebhook(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "DeleteWebhook"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := webhook.NewManager(s.db)
+	err = manager.DeleteSubscription(id)
+	if err != nil {
+		logger.Error("failed to delete subscription", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIBaseResponse{
+		Status: "success",
+	})
+}
+
+// ListWebhookDeliveries gin handler
+// @Summary Lists the latest deliveries of a webhook subscription
+// @Tags Webhook
+// @Param id path int true "Subscription ID"
+// @Param limit query int false "Max number of deliveries"
+// @Produce json
+// @Success 200 {object} ListWebhookDeliveriesResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/webhooks/{id}/deliveries [get]
+func (s *APIServer) ListWebhookDeliveries(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ListWebhookDeliveries"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if l := c.Query("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			abortWithBindError(c, err)
+			return
+		}
+		if limit <= 0 {
+			abortWithError(c, errInvalidField("limit", "must be positive"))
+			return
+		}
+	}
+
+	manager := webhook.NewManager(s.db)
+	deliveries, err := manager.ListDeliveries(id, limit)
+	if err != nil {
+		logger.Error("failed to list deliveries", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	resp := ListWebhookDeliveriesResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Deliveries: make([]WebhookDelivery, 0, len(deliveries)),
+	}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, WebhookDelivery{
+			ID:            d.ID,
+			EventID:       d.EventID,
+			EventType:     d.EventType,
+			Status:        d.Status,
+			Attempts:      d.Attempts,
+			NextAttemptAt: d.NextAttemptAt,
+			CreatedAt:     d.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListWebhookDeliveryAttempts gin handler
+// @Summary Lists every attempt made for a webhook delivery
+// @Tags Webhook
+// @Param id path int true "Subscription ID"
+// @Param delivery_id path int true "Delivery ID"
+// @Produce json
+// @Success 200 {object} ListWebhookDeliveryAttemptsResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/webhooks/{id}/deliveries/{delivery_id}/attempts [get]
+func (s *APIServer) ListWebhookDeliveryAttempts(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ListWebhookDeliveryAttempts"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := webhook.NewManager(s.db)
+	attempts, err := manager.ListAttempts(id, deliveryID)
+	if err != nil {
+		logger.Error("failed to list delivery attempts", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	resp := ListWebhookDeliveryAttemptsResponse{
+		APIBaseResponse: APIBaseResponse{
+			Status: "success",
+		},
+		Attempts: make([]WebhookDeliveryAttempt, 0, len(attempts)),
+	}
+	for _, a := range attempts {
+		resp.Attempts = append(resp.Attempts, WebhookDeliveryAttempt{
+			ID:         a.ID,
+			StatusCode: a.StatusCode,
+			Error:      a.Error.String,
+			DurationMs: a.DurationMs,
+			CreatedAt:  a.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ReplayWebhookDelivery gin handler
+// @Summary Re-queues a webhook delivery, including the dead ones
+// @Tags Webhook
+// @Param id path int true "Subscription ID"
+// @Param delivery_id path int true "Delivery ID"
+// @Produce json
+// @Success 200 {object} APIBaseResponse "Successful response"
+// @Success 400 {object} Problem "Bad Request"
+// @Success 404 {object} Problem "Delivery Not Found"
+// @Success 500 {object} Problem "Internal Server Error"
+// @Router /api/webhooks/{id}/deliveries/{delivery_id}/replay [post]
+func (s *APIServer) ReplayWebhookDelivery(c *gin.Context) {
+	logger := slog.Default().
+		With(slog.String("request_id", c.GetString("X-Request-Id"))).
+		With(slog.String("operation", "ReplayWebhookDelivery"))
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	manager := webhook.NewManager(s.db)
+	err = manager.ReplayDelivery(id, deliveryID)
+	if err != nil {
+		logger.Error("failed to replay delivery", "error", err)
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIBaseResponse{
+		Status: "success",
+	})
+}
+
+func toWebhookSubscription(sub *webhook.Subscription) *WebhookSubscription {
+	return &WebhookSubscription{
+		ID:         sub.ID,
+		URL:        sub.URL,
+		EventTypes: sub.EventTypes,
+		Active:     sub.Active,
+		CreatedAt:  sub.CreatedAt,
+	}
+}
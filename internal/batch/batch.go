@@ -0,0 +1,341 @@
+package batch
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/logging"
+)
+
+var (
+	ErrNotFound    = fmt.Errorf("batch: data not found")
+	ErrInvalidMode = fmt.Errorf("batch: invalid mode")
+)
+
+const (
+	ModeAtomic     = "atomic"
+	ModeBestEffort = "best_effort"
+)
+
+const (
+	StatusProcessing         = "processing"
+	StatusSucceeded          = "succeeded"
+	StatusPartiallySucceeded = "partially_succeeded"
+	StatusFailed             = "failed"
+)
+
+const (
+	ItemStatusSucceeded = "succeeded"
+	ItemStatusFailed    = "failed"
+)
+
+type Batch struct {
+	ID          string       `db:"id"`
+	Mode        string       `db:"mode"`
+	Status      string       `db:"status"`
+	ItemCount   int          `db:"item_count"`
+	Succeeded   int          `db:"succeeded"`
+	Failed      int          `db:"failed"`
+	CreatedAt   time.Time    `db:"created_at"`
+	CompletedAt sql.NullTime `db:"completed_at"`
+	Items       []Item       `db:"-"`
+}
+
+// Item is the outcome of 1 item of the batch
+type Item struct {
+	Index          int            `db:"item_index"`
+	Type           string         `db:"type"`
+	Username       string         `db:"username"`
+	TargetUsername sql.NullString `db:"target_username"`
+	Amount         float64        `db:"amount"`
+	Status         string         `db:"status"`
+	DebitTrxID     sql.NullInt64  `db:"debit_trx_id"`
+	CreditTrxID    sql.NullInt64  `db:"credit_trx_id"`
+	Error          sql.NullString `db:"error"`
+}
+
+// Manager is the interface responsible for executing the batches and
+// keeping their outcome for later lookups
+type Manager interface {
+	// Execute executes the items in the mode and returns the batch with
+	// the outcome of every item
+	Execute(mode string, items []account.BatchItem) (*Batch, error)
+
+	// Get gets the batch with this ID along with its items
+	Get(id string) (*Batch, error)
+}
+
+type pgManager struct {
+	db      *sqlx.DB
+	ewallet account.EWalletSystem
+}
+
+func NewManager(db *sqlx.DB, ewallet account.EWalletSystem) Manager {
+	return &pgManager{
+		db:      db,
+		ewallet: ewallet,
+	}
+}
+
+// Execute implements Manager. The batch is registered as processing
+// before the money moves, a batch that stays processing was interrupted.
+func (m *pgManager) Execute(mode string, items []account.BatchItem) (*Batch, error) {
+	if mode != ModeAtomic && mode != ModeBestEffort {
+		return nil, ErrInvalidMode
+	}
+
+	b := &Batch{
+		ID:        uuid.NewString(),
+		Mode:      mode,
+		Status:    StatusProcessing,
+		ItemCount: len(items),
+	}
+	qBatch := `
+        INSERT INTO batches
+            (id, mode, status, item_count)
+        VALUES
+            ($1, $2, $3, $4)
+        RETURNING
+            created_at
+    `
+	err := m.db.Get(&b.CreatedAt, qBatch, b.ID, b.Mode, b.Status, b.ItemCount)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := m.ewallet.ExecuteBatch(items, mode == ModeAtomic)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Items = make([]Item, len(items))
+	for i, item := range items {
+		b.Items[i] = toItem(i, item, results[i])
+		if b.Items[i].Status == ItemStatusSucceeded {
+			b.Succeeded++
+		} else {
+			b.Failed++
+		}
+	}
+
+	auditResults(b.ID, items, results)
+
+	switch {
+	case b.Failed == 0:
+		b.Status = StatusSucceeded
+	case b.Succeeded == 0:
+		b.Status = StatusFailed
+	default:
+		b.Status = StatusPartiallySucceeded
+	}
+
+	err = m.complete(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// complete stores the outcome of the items and of the batch
+func (m *pgManager) complete(b *Batch) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	n := len(b.Items)
+	indexes, amounts := make([]int64, n), make([]float64, n)
+	types, usernames, statuses := make([]string, n), make([]string, n), make([]string, n)
+	targets, errMsgs := make([]sql.NullString, n), make([]sql.NullString, n)
+	debitIDs, creditIDs := make([]sql.NullInt64, n), make([]sql.NullInt64, n)
+	for i, item := range b.Items {
+		indexes[i] = int64(item.Index)
+		types[i] = item.Type
+		usernames[i] = item.Username
+		targets[i] = item.TargetUsername
+		amounts[i] = item.Amount
+		statuses[i] = item.Status
+		debitIDs[i] = item.DebitTrxID
+		creditIDs[i] = item.CreditTrxID
+		errMsgs[i] = item.Error
+	}
+
+	// the items are inserted at once, a batch can hold thousands of them
+	qItems := `
+        INSERT INTO batch_items
+            (batch_id, item_index, type, username, target_username, amount, status, debit_trx_id, credit_trx_id, error)
+        SELECT
+            $1, *
+        FROM unnest(
+            $2::INTEGER[], $3::VARCHAR[], $4::VARCHAR[], $5::VARCHAR[], $6::DECIMAL[],
+            $7::VARCHAR[], $8::INTEGER[], $9::INTEGER[], $10::TEXT[]
+        )
+    `
+	_, err = tx.Exec(qItems, b.ID,
+		pq.Array(indexes), pq.Array(types), pq.Array(usernames), pq.Array(targets), pq.Array(amounts),
+		pq.Array(statuses), pq.Array(debitIDs), pq.Array(creditIDs), pq.Array(errMsgs),
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	qBatch := `
+        UPDATE batches
+        SET
+            status = $2,
+            succeeded = $3,
+            failed = $4,
+            completed_at = CURRENT_TIMESTAMP
+        WHERE
+            id = $1
+        RETURNING
+            completed_at
+    `
+	err = tx.Get(&b.CompletedAt, qBatch, b.ID, b.Status, b.Succeeded, b.Failed)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+// Get implements Manager.
+func (m *pgManager) Get(id string) (*Batch, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	qBatch := `
+        SELECT
+            id, mode, status, item_count, succeeded, failed, created_at, completed_at
+        FROM batches
+        WHERE id = $1
+    `
+	b := new(Batch)
+	err := m.db.Get(b, qBatch, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	qItems := `
+        SELECT
+            item_index, type, username, target_username, amount, status,
+            debit_trx_id, credit_trx_id, error
+        FROM batch_items
+        WHERE batch_id = $1
+        ORDER BY item_index
+    `
+	b.Items = []Item{}
+	err = m.db.Select(&b.Items, qItems, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func toItem(index int, in account.BatchItem, result account.BatchResult) Item {
+	item := Item{
+		Index:    index,
+		Type:     in.Type,
+		Username: in.Username,
+		Amount:   in.Amount,
+		Status:   ItemStatusSucceeded,
+	}
+	if in.TargetUsername != "" {
+		item.TargetUsername = sql.NullString{String: in.TargetUsername, Valid: true}
+	}
+	if result.Debit != nil {
+		item.DebitTrxID = sql.NullInt64{Int64: int64(result.Debit.ID), Valid: true}
+	}
+	if result.Credit != nil {
+		item.CreditTrxID = sql.NullInt64{Int64: int64(result.Credit.ID), Valid: true}
+	}
+	if result.Err != nil {
+		item.Status = ItemStatusFailed
+		item.Error = sql.NullString{String: itemError(result.Err), Valid: true}
+	}
+
+	return item
+}
+
+// itemError is the error reported for the item, the unexpected errors
+// are logged instead of being exposed
+func itemError(err error) string {
+	switch {
+	case errors.Is(err, account.ErrInvalidBatchItem),
+		errors.Is(err, account.ErrBatchAborted):
+		return err.Error()
+
+	case errors.Is(err, account.ErrNotFound):
+		return "user not found"
+
+	case errors.Is(err, account.ErrInsufficient):
+		return "insufficient funds"
+
+	default:
+		slog.Error("batch item failed", "error", err)
+		return "internal error"
+	}
+}
+
+// auditResults logs the money movements of the succeeded items
+func auditResults(batchID string, items []account.BatchItem, results []account.BatchResult) {
+	logger := slog.Default().
+		With(slog.String("operation", "Batch")).
+		With(slog.String("batch_id", batchID))
+
+	for i, item := range items {
+		res := results[i]
+		if res.Err != nil {
+			continue
+		}
+
+		target := item.TargetUsername
+		if res.Debit != nil {
+			logging.Audit(logger, logging.MoneyMovement{
+				Actor:          logging.ActorBatch,
+				Account:        item.Username,
+				CounterAccount: target,
+				TrxType:        account.TrxTypeDebit,
+				Amount:         res.Debit.Amount,
+				Fee:            res.Debit.Fee,
+				TrxID:          res.Debit.ID,
+			})
+		}
+		if res.Credit != nil {
+			// the credit of a transfer goes to the target
+			holder, counter := item.Username, ""
+			if target != "" {
+				holder, counter = target, item.Username
+			}
+			logging.Audit(logger, logging.MoneyMovement{
+				Actor:          logging.ActorBatch,
+				Account:        holder,
+				CounterAccount: counter,
+				TrxType:        account.TrxTypeCredit,
+				Amount:         res.Credit.Amount,
+				TrxID:          res.Credit.ID,
+			})
+		}
+	}
+}
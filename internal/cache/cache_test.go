@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+
+	// a is used, b becomes the least recently used
+	if _, ok, _ := c.Get("a"); !ok {
+		t.Fatal("Get(a) missed")
+	}
+	c.Set("c", []byte("3"), time.Minute)
+
+	if _, ok, _ := c.Get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := c.Get(key); !ok {
+			t.Errorf("Get(%s) missed", key)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewLRU(10)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", []byte("1"), time.Second)
+	if v, ok, _ := c.Get("a"); !ok || string(v) != "1" {
+		t.Fatalf("Get(a) = %q, %v, want 1", v, ok)
+	}
+
+	now = now.Add(time.Second)
+	if _, ok, _ := c.Get("a"); ok {
+		t.Error("Get(a) hit after the TTL")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d, want the expired entry removed", c.Len())
+	}
+}
+
+func TestLRUDelete(t *testing.T) {
+	c := NewLRU(10)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+
+	c.Delete("a", "missing")
+	if _, ok, _ := c.Get("a"); ok {
+		t.Error("Get(a) hit after Delete")
+	}
+	if _, ok, _ := c.Get("b"); !ok {
+		t.Error("Get(b) missed")
+	}
+}
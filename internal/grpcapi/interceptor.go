@@ -0,0 +1,62 @@
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDHeader is the metadata key of the request ID, the same header
+// as the HTTP API
+const requestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+// RequestID returns the request ID attached by AttachRequestID
+func RequestID(ctx context.Context) string {
+	reqID, _ := ctx.Value(requestIDKey{}).(string)
+	return reqID
+}
+
+// AttachRequestID is the gRPC equivalent of api.AttachRequestID: the
+// request ID is taken from the incoming metadata or generated, attached
+// to the context and sent back in the response header.
+func AttachRequestID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		reqID := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(requestIDHeader); len(values) > 0 {
+				reqID = values[0]
+			}
+		}
+		if reqID == "" {
+			reqID = uuid.NewString()
+		}
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, reqID))
+		return handler(context.WithValue(ctx, requestIDKey{}, reqID), req)
+	}
+}
+
+// LogRequests logs every call with its status code and duration. It must
+// be chained after AttachRequestID.
+func LogRequests() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		slog.Info("grpc request",
+			"request_id", RequestID(ctx),
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+
+		return resp, err
+	}
+}
@@ -0,0 +1,238 @@
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"net"
+
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/grpcapi/accountpb"
+	"github.com/yeyee2901/test/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultTransactionsLimit = 50
+	maxTransactionsLimit     = 100
+)
+
+// Server serves the AccountService on top of the same EWalletSystem as
+// the HTTP API
+type Server struct {
+	accountpb.UnimplementedAccountServiceServer
+
+	listener string
+	ewallet  account.EWalletSystem
+	grpc     *grpc.Server
+}
+
+func NewServer(cfg *config.Config, ewallet account.EWalletSystem) *Server {
+	s := &Server{
+		listener: cfg.Server.GRPCListener,
+		ewallet:  ewallet,
+		grpc: grpc.NewServer(
+			grpc.ChainUnaryInterceptor(AttachRequestID(), LogRequests()),
+		),
+	}
+	accountpb.RegisterAccountServiceServer(s.grpc, s)
+
+	return s
+}
+
+// Run runs the server. This will return an error channel that can be
+// waited, it returns a non-nil error whenever the server is stopped.
+func (s *Server) Run() <-chan error {
+	errChan := make(chan error)
+
+	go func() {
+		lis, err := net.Listen("tcp", s.listener)
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		errChan <- s.grpc.Serve(lis)
+	}()
+
+	return errChan
+}
+
+// Stop stops the server gracefully, waiting for the pending calls
+func (s *Server) Stop() {
+	s.grpc.GracefulStop()
+}
+
+// GetBalance implements accountpb.AccountServiceServer.
+func (s *Server) GetBalance(ctx context.Context, req *accountpb.GetBalanceRequest) (*accountpb.GetBalanceResponse, error) {
+	logger := s.logger(ctx, "GetBalance")
+
+	if req.GetUsername() == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+
+	user, err := s.ewallet.ReadUser(req.GetUsername())
+	if err != nil {
+		logger.Error("failed to retrieve user", "error", err)
+		return nil, toStatus(err, "user not found")
+	}
+
+	return &accountpb.GetBalanceResponse{
+		Balance: user.Balance,
+	}, nil
+}
+
+// Credit implements accountpb.AccountServiceServer.
+func (s *Server) Credit(ctx context.Context, req *accountpb.CreditRequest) (*accountpb.CreditResponse, error) {
+	logger := s.logger(ctx, "Credit")
+
+	if req.GetUsername() == "" || req.GetAmount() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "username and amount are required")
+	}
+
+	user, err := s.ewallet.GetUser(req.GetUsername())
+	if err != nil {
+		logger.Error("failed to retrieve user", "error", err)
+		return nil, toStatus(err, "user not found")
+	}
+
+	trx, err := s.ewallet.AddBalance(user, req.GetAmount())
+	if err != nil {
+		logger.Error("failed to add balance", "error", err)
+		return nil, toStatus(err, "")
+	}
+
+	logging.Audit(logger, logging.MoneyMovement{
+		Actor:   logging.ActorGRPC,
+		Account: user.Username,
+		TrxType: account.TrxTypeCredit,
+		Amount:  trx.Amount,
+		TrxID:   trx.ID,
+	})
+
+	return &accountpb.CreditResponse{
+		TransactionId: int64(trx.ID),
+		NewBalance:    s.newBalance(logger, user, trx),
+	}, nil
+}
+
+// Debit implements accountpb.AccountServiceServer.
+func (s *Server) Debit(ctx context.Context, req *accountpb.DebitRequest) (*accountpb.DebitResponse, error) {
+	logger := s.logger(ctx, "Debit")
+
+	if req.GetUsername() == "" || req.GetAmount() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "username and amount are required")
+	}
+
+	user, err := s.ewallet.GetUser(req.GetUsername())
+	if err != nil {
+		logger.Error("failed to retrieve user", "error", err)
+		return nil, toStatus(err, "user not found")
+	}
+
+	trx, err := s.ewallet.DeductBalance(user, req.GetAmount())
+	if err != nil {
+		logger.Error("failed to deduct balance", "error", err)
+		return nil, toStatus(err, "")
+	}
+
+	logging.Audit(logger, logging.MoneyMovement{
+		Actor:   logging.ActorGRPC,
+		Account: user.Username,
+		TrxType: account.TrxTypeDebit,
+		Amount:  trx.Amount,
+		Fee:     trx.Fee,
+		TrxID:   trx.ID,
+	})
+
+	return &accountpb.DebitResponse{
+		UserId:        int64(user.ID),
+		TransactionId: int64(trx.ID),
+		Fee:           trx.Fee,
+		NewBalance:    s.newBalance(logger, user, trx),
+	}, nil
+}
+
+// newBalance is the balance of the account after the transaction. The
+// money already moved, a failed read leaves it unset instead of failing.
+func (s *Server) newBalance(logger *slog.Logger, user *account.Account, trx *account.Transactions) float64 {
+	balance, err := account.BalanceAfter(s.ewallet, user, trx)
+	if err != nil {
+		logger.Warn("failed to read the balance after the write", "error", err)
+	}
+
+	return balance
+}
+
+// ListTransactions implements accountpb.AccountServiceServer.
+func (s *Server) ListTransactions(ctx context.Context, req *accountpb.ListTransactionsRequest) (*accountpb.ListTransactionsResponse, error) {
+	logger := s.logger(ctx, "ListTransactions")
+
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultTransactionsLimit
+	}
+	if req.GetUsername() == "" || limit < 0 || limit > maxTransactionsLimit || req.GetBeforeId() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "username is required, limit must be within 1 and 100")
+	}
+
+	user, err := s.ewallet.ReadUser(req.GetUsername())
+	if err != nil {
+		logger.Error("failed to retrieve user", "error", err)
+		return nil, toStatus(err, "user not found")
+	}
+
+	trxs, err := s.ewallet.ListTransactions(user, limit, int(req.GetBeforeId()))
+	if err != nil {
+		logger.Error("failed to list transactions", "error", err)
+		return nil, toStatus(err, "")
+	}
+
+	resp := &accountpb.ListTransactionsResponse{
+		Transactions: make([]*accountpb.Transaction, 0, len(trxs)),
+	}
+	for _, trx := range trxs {
+		resp.Transactions = append(resp.Transactions, &accountpb.Transaction{
+			Id:        int64(trx.ID),
+			Amount:    trx.Amount,
+			Type:      trx.TrxType,
+			ParentId:  trx.ParentID.Int64,
+			CreatedAt: timestamppb.New(trx.CreatedAt.Time),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *Server) logger(ctx context.Context, operation string) *slog.Logger {
+	return slog.Default().
+		With(slog.String("request_id", RequestID(ctx))).
+		With(slog.String("operation", operation))
+}
+
+// toStatus maps the account errors to the status codes matching the HTTP
+// API, notFoundMsg is the message of ErrNotFound
+func toStatus(err error, notFoundMsg string) error {
+	switch account.CodeOf(err) {
+	case account.CodeNotFound:
+		return status.Error(codes.NotFound, notFoundMsg)
+
+	case account.CodeInsufficientFunds:
+		return status.Error(codes.FailedPrecondition, "Insufficient funds")
+
+	case account.CodeVersionMismatch:
+		return status.Error(codes.Aborted, "Account changed")
+
+	case account.CodeBusy:
+		return status.Error(codes.Unavailable, "Account busy")
+
+	case account.CodeValidation:
+		return status.Error(codes.InvalidArgument, err.Error())
+
+	default:
+		return status.Error(codes.Internal, "internal server error")
+	}
+}
@@ -0,0 +1,197 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/grpcapi/accountpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// stubEWallet has 1 user, alice, with a balance of 100
+type stubEWallet struct {
+	account.EWalletSystem
+}
+
+func (stubEWallet) GetUser(username string) (*account.Account, error) {
+	if username != "alice" {
+		return nil, account.ErrNotFound
+	}
+	return &account.Account{ID: 1, Username: "alice", Balance: 100}, nil
+}
+
+func (s stubEWallet) ReadUser(username string) (*account.Account, error) {
+	return s.GetUser(username)
+}
+
+func (stubEWallet) DeductBalance(acc *account.Account, amount float64) (*account.Transactions, error) {
+	if amount > acc.Balance {
+		return nil, account.ErrInsufficient
+	}
+	return &account.Transactions{ID: 10, UserID: acc.ID, Amount: amount, TrxType: account.TrxTypeDebit}, nil
+}
+
+func newTestClient(t *testing.T) accountpb.AccountServiceClient {
+	return newTestClientOf(t, stubEWallet{})
+}
+
+func newTestClientOf(t *testing.T, ewallet account.EWalletSystem) accountpb.AccountServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(&config.Config{}, ewallet)
+	go srv.grpc.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return accountpb.NewAccountServiceClient(conn)
+}
+
+func TestServerErrorMapping(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{
+			name: "missing username",
+			call: func() error {
+				_, err := client.GetBalance(ctx, &accountpb.GetBalanceRequest{})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "unknown user",
+			call: func() error {
+				_, err := client.GetBalance(ctx, &accountpb.GetBalanceRequest{Username: "bob"})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		{
+			name: "insufficient funds",
+			call: func() error {
+				_, err := client.Debit(ctx, &accountpb.DebitRequest{Username: "alice", Amount: 500})
+				return err
+			},
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "debit",
+			call: func() error {
+				_, err := client.Debit(ctx, &accountpb.DebitRequest{Username: "alice", Amount: 50})
+				return err
+			},
+			want: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.call()); got != tt.want {
+				t.Errorf("code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// racingEWallet credits alice with 70 more, from another client, right
+// after the credit of the test is written. Only the credits of a hot
+// account have no balance after recorded.
+type racingEWallet struct {
+	stubEWallet
+	balance *float64
+	hot     bool
+}
+
+func (r racingEWallet) GetUser(username string) (*account.Account, error) {
+	acc, err := r.stubEWallet.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	acc.Balance = *r.balance
+	return acc, nil
+}
+
+func (r racingEWallet) AddBalance(acc *account.Account, amount float64) (*account.Transactions, error) {
+	*r.balance += amount
+	trx := &account.Transactions{ID: 11, UserID: acc.ID, Amount: amount, TrxType: account.TrxTypeCredit}
+	if !r.hot {
+		trx.BalanceAfter = sql.NullFloat64{Float64: *r.balance, Valid: true}
+	}
+
+	*r.balance += 70
+	return trx, nil
+}
+
+func TestCreditNewBalance(t *testing.T) {
+	tests := []struct {
+		name string
+		hot  bool
+		want float64
+	}{
+		{
+			name: "test_balance_after_of_the_row",
+			want: 130,
+		},
+		{
+			name: "test_hot_account_read_back",
+			hot:  true,
+			want: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balance := 100.0
+			client := newTestClientOf(t, racingEWallet{balance: &balance, hot: tt.hot})
+
+			resp, err := client.Credit(context.Background(), &accountpb.CreditRequest{Username: "alice", Amount: 30})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetNewBalance() != tt.want {
+				t.Errorf("NewBalance = %v, want %v", resp.GetNewBalance(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachRequestID(t *testing.T) {
+	client := newTestClient(t)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestIDHeader, "req-1")
+	_, err := client.GetBalance(ctx, &accountpb.GetBalanceRequest{Username: "alice"}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get(requestIDHeader); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("request ID header = %v, want [req-1]", got)
+	}
+
+	_, err = client.GetBalance(context.Background(), &accountpb.GetBalanceRequest{Username: "alice"}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get(requestIDHeader); len(got) != 1 || got[0] == "" {
+		t.Errorf("generated request ID header = %v, want 1 ID", got)
+	}
+}
@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yeyee2901/test/internal/account"
+)
+
+// the columns of an import file, the header line is required
+var columns = []string{"username", "amount", "type", "reference"}
+
+const (
+	maxReferenceLength = 100
+)
+
+// Row is 1 adjustment of the import file
+type Row struct {
+	Line      int     `db:"line"`
+	Username  string  `db:"username"`
+	Amount    float64 `db:"amount"`
+	Type      string  `db:"type"`
+	Reference string  `db:"reference"`
+}
+
+// RowError reports why a line of the file is invalid
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// ValidationError lists every invalid line of a file
+type ValidationError struct {
+	Errors []RowError
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("importer: %d invalid lines, first: %s", len(e.Errors), e.Errors[0])
+}
+
+// Parse reads and validates the whole file. All the invalid lines are
+// reported at once in a *ValidationError.
+func Parse(r io.Reader, maxRows int) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(columns)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("the file is empty")
+		}
+		return nil, &ValidationError{Errors: []RowError{{Line: 1, Message: err.Error()}}}
+	}
+	for i, col := range columns {
+		if strings.ToLower(strings.TrimSpace(header[i])) != col {
+			msg := "the header must be " + strings.Join(columns, ",")
+			return nil, &ValidationError{Errors: []RowError{{Line: 1, Message: msg}}}
+		}
+	}
+
+	rows := []Row{}
+	rowErrs := []RowError{}
+	references := map[string]int{}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.Line
+				err = parseErr.Err
+			}
+			rowErrs = append(rowErrs, RowError{Line: line, Message: err.Error()})
+			continue
+		}
+
+		row, msg := parseRow(line, record)
+		if msg == "" {
+			if first, ok := references[row.Reference]; ok {
+				msg = fmt.Sprintf("duplicate reference %q, already used on line %d", row.Reference, first)
+			} else {
+				references[row.Reference] = line
+			}
+		}
+		if msg != "" {
+			rowErrs = append(rowErrs, RowError{Line: line, Message: msg})
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rowErrs) > 0 {
+		return nil, &ValidationError{Errors: rowErrs}
+	}
+
+	switch {
+	case len(rows) == 0:
+		return nil, &ValidationError{Errors: []RowError{{Line: 2, Message: "the file has no rows"}}}
+	case maxRows > 0 && len(rows) > maxRows:
+		msg := fmt.Sprintf("the file has %d rows, at most %d are allowed", len(rows), maxRows)
+		return nil, &ValidationError{Errors: []RowError{{Line: rows[maxRows].Line, Message: msg}}}
+	}
+
+	return rows, nil
+}
+
+// parseRow returns the row, or the reason it is invalid
+func parseRow(line int, record []string) (Row, string) {
+	row := Row{
+		Line:      line,
+		Username:  strings.TrimSpace(record[0]),
+		Type:      strings.ToLower(strings.TrimSpace(record[2])),
+		Reference: strings.TrimSpace(record[3]),
+	}
+
+	err := account.ValidateUsername(row.Username)
+	if err != nil {
+		return row, fieldMessage(err)
+	}
+
+	switch {
+	case row.Type != account.TrxTypeCredit && row.Type != account.TrxTypeDebit:
+		return row, fmt.Sprintf("type must be %s or %s", account.TrxTypeCredit, account.TrxTypeDebit)
+	case row.Reference == "":
+		return row, "reference is required"
+	case len(row.Reference) > maxReferenceLength:
+		return row, fmt.Sprintf("reference is longer than %d characters", maxReferenceLength)
+	}
+
+	amount, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+	if err != nil {
+		return row, "amount is not a number"
+	}
+	err = account.ValidateAmount(amount)
+	if err != nil {
+		return row, fieldMessage(err)
+	}
+	row.Amount = amount
+
+	return row, ""
+}
+
+// fieldMessage formats the invalid field of an account validation error
+// as a row message, e.g. "amount must be positive"
+func fieldMessage(err error) string {
+	var validationErr *account.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Fields) == 0 {
+		return err.Error()
+	}
+
+	f := validationErr.Fields[0]
+	return f.Field + " " + f.Message
+}
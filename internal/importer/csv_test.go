@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	valid := "username,amount,type,reference\n" +
+		"alice,100.50,credit,ref-1\n" +
+		"bob, 20 ,DEBIT,ref-2\n"
+
+	rows, err := Parse(strings.NewReader(valid), 0)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	want := []Row{
+		{Line: 2, Username: "alice", Amount: 100.5, Type: "credit", Reference: "ref-1"},
+		{Line: 3, Username: "bob", Amount: 20, Type: "debit", Reference: "ref-2"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Parse() = %+v, want %+v", rows, want)
+	}
+
+	tests := []struct {
+		name      string
+		content   string
+		maxRows   int
+		wantLines []int
+	}{
+		{
+			name:      "empty file",
+			content:   "",
+			wantLines: []int{1},
+		},
+		{
+			name:      "wrong header",
+			content:   "user,amount,type,reference\nalice,1,credit,a\n",
+			wantLines: []int{1},
+		},
+		{
+			name:      "no rows",
+			content:   "username,amount,type,reference\n",
+			wantLines: []int{2},
+		},
+		{
+			name: "every invalid line is reported",
+			content: "username,amount,type,reference\n" +
+				"alice,-1,credit,a\n" +
+				"alice,1.005,credit,b\n" +
+				"alice,abc,credit,c\n" +
+				"alice,1,refund,d\n" +
+				",1,credit,e\n" +
+				"alice,1,credit,\n" +
+				"alice,1,credit\n" +
+				"alice,1,credit,f\n" +
+				"bob,2,debit,f\n",
+			wantLines: []int{2, 3, 4, 5, 6, 7, 8, 10},
+		},
+		{
+			name:      "too many rows",
+			content:   "username,amount,type,reference\nalice,1,credit,a\nalice,1,credit,b\n",
+			maxRows:   1,
+			wantLines: []int{3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.content), tt.maxRows)
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Parse() = %v, want a *ValidationError", err)
+			}
+
+			lines := []int{}
+			for _, rowErr := range validationErr.Errors {
+				lines = append(lines, rowErr.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.wantLines) {
+				t.Errorf("invalid lines = %v, want %v (%v)", lines, tt.wantLines, validationErr.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrNotFound = fmt.Errorf("importer: data not found")
+
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+)
+
+const (
+	RowStatusPending   = "pending"
+	RowStatusRunning   = "running"
+	RowStatusSucceeded = "succeeded"
+	RowStatusFailed    = "failed"
+)
+
+// Job is the asynchronous execution of 1 import file
+type Job struct {
+	ID            string       `db:"id"`
+	Filename      string       `db:"filename"`
+	Checksum      string       `db:"checksum"`
+	Status        string       `db:"status"`
+	TotalRows     int          `db:"total_rows"`
+	ProcessedRows int          `db:"processed_rows"`
+	Succeeded     int          `db:"succeeded"`
+	Failed        int          `db:"failed"`
+	CreatedAt     time.Time    `db:"created_at"`
+	StartedAt     sql.NullTime `db:"started_at"`
+	CompletedAt   sql.NullTime `db:"completed_at"`
+}
+
+// Manager is the interface responsible for registering the imports and
+// reporting their progress, the rows are executed by the Worker
+type Manager interface {
+	// Create validates the file and registers its job. A file that was
+	// already uploaded is not imported twice, its existing job is
+	// returned with duplicate set instead. Invalid files are reported
+	// with a *ValidationError.
+	Create(filename string, content []byte) (job *Job, duplicate bool, err error)
+
+	// Get gets the job with this ID
+	Get(id string) (*Job, error)
+
+	// WriteResult writes the outcome of every row of the job as CSV
+	WriteResult(id string, w io.Writer) error
+}
+
+const defaultMaxRows = 10000
+
+type pgManager struct {
+	db      *sqlx.DB
+	maxRows int
+}
+
+func NewManager(db *sqlx.DB, maxRows int) Manager {
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+
+	return &pgManager{
+		db:      db,
+		maxRows: maxRows,
+	}
+}
+
+const selectJob = `
+        SELECT
+            id, filename, checksum, status, total_rows, processed_rows,
+            succeeded, failed, created_at, started_at, completed_at
+        FROM import_jobs
+    `
+
+// Create implements Manager.
+func (m *pgManager) Create(filename string, content []byte) (*Job, bool, error) {
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	job, err := m.getByChecksum(checksum)
+	if err == nil {
+		return job, true, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+
+	rows, err := Parse(bytes.NewReader(content), m.maxRows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = m.checkRows(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	job, err = m.insert(filename, checksum, rows)
+	if err != nil {
+		// the same file uploaded concurrently
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "import_jobs_checksum_key" {
+			job, err = m.getByChecksum(checksum)
+			return job, err == nil, err
+		}
+		return nil, false, err
+	}
+
+	return job, false, nil
+}
+
+// checkRows validates the rows against the database: the users must
+// exist and the references must not be imported yet
+func (m *pgManager) checkRows(rows []Row) error {
+	usernames := make([]string, 0, len(rows))
+	references := make([]string, 0, len(rows))
+	for _, row := range rows {
+		usernames = append(usernames, row.Username)
+		references = append(references, row.Reference)
+	}
+
+	known := []string{}
+	err := m.db.Select(&known, `SELECT username FROM users WHERE username = ANY($1)`, pq.Array(usernames))
+	if err != nil {
+		return err
+	}
+	knownUsers := make(map[string]bool, len(known))
+	for _, username := range known {
+		knownUsers[username] = true
+	}
+
+	type imported struct {
+		Reference string `db:"reference"`
+		JobID     string `db:"job_id"`
+	}
+	used := []imported{}
+	err = m.db.Select(&used, `SELECT reference, job_id FROM import_rows WHERE reference = ANY($1)`, pq.Array(references))
+	if err != nil {
+		return err
+	}
+	usedRefs := make(map[string]string, len(used))
+	for _, u := range used {
+		usedRefs[u.Reference] = u.JobID
+	}
+
+	rowErrs := []RowError{}
+	for _, row := range rows {
+		switch {
+		case !knownUsers[row.Username]:
+			rowErrs = append(rowErrs, RowError{Line: row.Line, Message: "user " + row.Username + " not found"})
+		case usedRefs[row.Reference] != "":
+			msg := fmt.Sprintf("reference %q was already imported by job %s", row.Reference, usedRefs[row.Reference])
+			rowErrs = append(rowErrs, RowError{Line: row.Line, Message: msg})
+		}
+	}
+	if len(rowErrs) > 0 {
+		return &ValidationError{Errors: rowErrs}
+	}
+
+	return nil
+}
+
+func (m *pgManager) insert(filename, checksum string, rows []Row) (*Job, error) {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	qJob := `
+        INSERT INTO import_jobs
+            (id, filename, checksum, status, total_rows)
+        VALUES
+            ($1, $2, $3, $4, $5)
+    `
+	id := uuid.NewString()
+	_, err = tx.Exec(qJob, id, filename, checksum, JobStatusPending, len(rows))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	lines, amounts := make([]int64, len(rows)), make([]float64, len(rows))
+	usernames, types, references := make([]string, len(rows)), make([]string, len(rows)), make([]string, len(rows))
+	for i, row := range rows {
+		lines[i] = int64(row.Line)
+		usernames[i] = row.Username
+		amounts[i] = row.Amount
+		types[i] = row.Type
+		references[i] = row.Reference
+	}
+
+	qRows := `
+        INSERT INTO import_rows
+            (job_id, line, username, amount, type, reference)
+        SELECT
+            $1, *
+        FROM unnest($2::INTEGER[], $3::VARCHAR[], $4::DECIMAL[], $5::VARCHAR[], $6::VARCHAR[])
+    `
+	_, err = tx.Exec(qRows, id, pq.Array(lines), pq.Array(usernames), pq.Array(amounts), pq.Array(types), pq.Array(references))
+	if err != nil {
+		tx.Rollback()
+
+		// a reference imported concurrently by another file
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "import_rows_reference_key" {
+			return nil, &ValidationError{Errors: []RowError{{Line: rows[0].Line, Message: "some references were just imported by another file"}}}
+		}
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return m.Get(id)
+}
+
+// Get implements Manager.
+func (m *pgManager) Get(id string) (*Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	job := new(Job)
+	err := m.db.Get(job, selectJob+`WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (m *pgManager) getByChecksum(checksum string) (*Job, error) {
+	job := new(Job)
+	err := m.db.Get(job, selectJob+`WHERE checksum = $1`, checksum)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// WriteResult implements Manager.
+func (m *pgManager) WriteResult(id string, w io.Writer) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+
+	type result struct {
+		Row
+		Status        string         `db:"status"`
+		TransactionID sql.NullInt64  `db:"transaction_id"`
+		Error         sql.NullString `db:"error"`
+	}
+	q := `
+        SELECT
+            line, username, amount, type, reference, status, transaction_id, error
+        FROM import_rows
+        WHERE job_id = $1
+        ORDER BY line
+    `
+	results := []result{}
+	err := m.db.Select(&results, q, id)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	err = cw.Write([]string{"line", "username", "amount", "type", "reference", "status", "transaction_id", "error"})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		trxID := ""
+		if r.TransactionID.Valid {
+			trxID = strconv.FormatInt(r.TransactionID.Int64, 10)
+		}
+
+		err = cw.Write([]string{
+			strconv.Itoa(r.Line),
+			r.Username,
+			strconv.FormatFloat(r.Amount, 'f', 2, 64),
+			r.Type,
+			r.Reference,
+			r.Status,
+			trxID,
+			r.Error.String,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
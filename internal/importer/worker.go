@@ -0,0 +1,294 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/leader"
+	"github.com/yeyee2901/test/internal/logging"
+)
+
+// leaderLockKey is the advisory lock key of the import worker leader
+const leaderLockKey = 35_000_001
+
+const defaultPollInterval = 5 * time.Second
+
+// errInterrupted is recorded on the rows that were being executed when
+// the worker stopped, whether their money moved must be checked by hand
+const errInterrupted = "interrupted while executing, check the account before importing the reference again"
+
+// Worker executes the pending imports, 1 job and 1 row at a time, through
+// the EWalletSystem. Only the elected leader executes.
+//
+// Like the standing orders, every row is claimed (marked running) before
+// its money moves, so a crash never applies a row twice.
+type Worker struct {
+	db           *sqlx.DB
+	txs          *account.TxPolicy
+	ewallet      account.EWalletSystem
+	elector      *leader.Elector
+	pollInterval time.Duration
+}
+
+func NewWorker(cfg *config.Config, db *sqlx.DB, ewallet account.EWalletSystem, txs *account.TxPolicy) *Worker {
+	pollInterval := time.Duration(cfg.Import.PollIntervalSeconds) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	return &Worker{
+		db:           db,
+		txs:          txs,
+		ewallet:      ewallet,
+		elector:      leader.NewElector(db, leaderLockKey, "importer"),
+		pollInterval: pollInterval,
+	}
+}
+
+// Run executes the imports until the context is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	defer w.elector.Release()
+
+	for {
+		if w.elector.IsLeader(ctx) {
+			if err := w.RunOnce(ctx); err != nil {
+				slog.Error("import worker cycle failed", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce executes the oldest unfinished job to its end
+func (w *Worker) RunOnce(ctx context.Context) error {
+	var jobID string
+	q := `
+        SELECT id
+        FROM import_jobs
+        WHERE status <> 'completed'
+        ORDER BY created_at
+        LIMIT 1
+    `
+	err := w.db.GetContext(ctx, &jobID, q)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	return w.execute(ctx, jobID)
+}
+
+func (w *Worker) execute(ctx context.Context, jobID string) error {
+	logger := slog.Default().
+		With(slog.String("operation", "Import")).
+		With(slog.String("import_job_id", jobID))
+
+	qStart := `
+        UPDATE import_jobs
+        SET
+            status = 'running',
+            started_at = COALESCE(started_at, CURRENT_TIMESTAMP)
+        WHERE
+            id = $1
+    `
+	_, err := w.db.ExecContext(ctx, qStart, jobID)
+	if err != nil {
+		return err
+	}
+
+	// rows left running by a previous leader
+	res, err := w.db.ExecContext(ctx, `
+        UPDATE import_rows
+        SET
+            status = 'failed',
+            error = $2
+        WHERE
+            job_id = $1 AND status = 'running'
+    `, jobID, errInterrupted)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		logger.Warn("import rows were interrupted", "rows", n)
+		err = w.updateProgress(ctx, jobID)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows := []Row{}
+	qRows := `
+        SELECT
+            line, username, amount, type, reference
+        FROM import_rows
+        WHERE job_id = $1 AND status = 'pending'
+        ORDER BY line
+    `
+	err = w.db.SelectContext(ctx, &rows, qRows, jobID)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err = w.executeRow(ctx, logger, jobID, row)
+		if err != nil {
+			return err
+		}
+	}
+
+	qComplete := `
+        UPDATE import_jobs
+        SET
+            status = 'completed',
+            completed_at = CURRENT_TIMESTAMP
+        WHERE
+            id = $1
+    `
+	_, err = w.db.ExecContext(ctx, qComplete, jobID)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("import completed", "rows", len(rows))
+	return nil
+}
+
+// executeRow claims the row, moves its money and records the outcome
+func (w *Worker) executeRow(ctx context.Context, logger *slog.Logger, jobID string, row Row) error {
+	qClaim := `
+        UPDATE import_rows
+        SET
+            status = 'running'
+        WHERE
+            job_id = $1 AND line = $2 AND status = 'pending'
+    `
+	res, err := w.db.ExecContext(ctx, qClaim, jobID, row.Line)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	trx, execErr := w.move(row)
+
+	status := RowStatusSucceeded
+	var trxID sql.NullInt64
+	var errMsg sql.NullString
+	if execErr != nil {
+		status = RowStatusFailed
+		errMsg = sql.NullString{String: rowError(execErr), Valid: true}
+		logger.Warn("import row failed", "line", row.Line, "reference", row.Reference, "error", execErr)
+	} else {
+		trxID = sql.NullInt64{Int64: int64(trx.ID), Valid: true}
+		logging.Audit(logger.With("reference", row.Reference), logging.MoneyMovement{
+			Actor:   logging.ActorImporter,
+			Account: row.Username,
+			TrxType: row.Type,
+			Amount:  trx.Amount,
+			Fee:     trx.Fee,
+			TrxID:   trx.ID,
+		})
+	}
+
+	succeeded, failed := 1, 0
+	if execErr != nil {
+		succeeded, failed = 0, 1
+	}
+
+	return w.txs.InTx(ctx, w.db, "ImportRow", func(tx *sqlx.Tx) error {
+		qRow := `
+            UPDATE import_rows
+            SET
+                status = $3,
+                transaction_id = $4,
+                error = $5
+            WHERE
+                job_id = $1 AND line = $2
+        `
+		_, err := tx.Exec(qRow, jobID, row.Line, status, trxID, errMsg)
+		if err != nil {
+			return err
+		}
+
+		qProgress := `
+            UPDATE import_jobs
+            SET
+                processed_rows = processed_rows + 1,
+                succeeded = succeeded + $2,
+                failed = failed + $3
+            WHERE
+                id = $1
+        `
+		_, err = tx.Exec(qProgress, jobID, succeeded, failed)
+		return err
+	})
+}
+
+func (w *Worker) move(row Row) (*account.Transactions, error) {
+	acc, err := w.ewallet.GetUser(row.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if row.Type == account.TrxTypeDebit {
+		return w.ewallet.DeductBalance(acc, row.Amount)
+	}
+
+	return w.ewallet.AddBalance(acc, row.Amount)
+}
+
+// updateProgress recounts the processed rows of the job, after rows were
+// failed in bulk
+func (w *Worker) updateProgress(ctx context.Context, jobID string) error {
+	q := `
+        UPDATE import_jobs j
+        SET
+            processed_rows = c.succeeded + c.failed,
+            succeeded = c.succeeded,
+            failed = c.failed
+        FROM (
+            SELECT
+                COUNT(*) FILTER (WHERE status = 'succeeded') AS succeeded,
+                COUNT(*) FILTER (WHERE status = 'failed') AS failed
+            FROM import_rows
+            WHERE job_id = $1
+        ) c
+        WHERE
+            j.id = $1
+    `
+	_, err := w.db.ExecContext(ctx, q, jobID)
+	return err
+}
+
+// rowError is the error reported for the row, the unexpected errors are
+// only logged
+func rowError(err error) string {
+	switch {
+	case errors.Is(err, account.ErrNotFound):
+		return "user not found"
+	case errors.Is(err, account.ErrInsufficient):
+		return "insufficient funds"
+	default:
+		return "internal error"
+	}
+}
@@ -0,0 +1,290 @@
+package interest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/logging"
+)
+
+var (
+	ErrAlreadyAccrued = fmt.Errorf("interest: day already accrued")
+	ErrPeriodNotOver  = fmt.Errorf("interest: period is not over yet")
+)
+
+// day count conventions
+const (
+	DayCountACT365 = "ACT/365"
+	DayCountACT360 = "ACT/360"
+	DayCountACTACT = "ACT/ACT"
+)
+
+// Accruer computes the daily interest accruals and pays them out. Every
+// operation is idempotent, re-running a day or a month never pays twice.
+type Accruer struct {
+	db       *sqlx.DB
+	txs      *account.TxPolicy
+	rates    map[string]float64
+	dayCount string
+}
+
+func NewAccruer(cfg *config.Config, db *sqlx.DB, txs *account.TxPolicy) *Accruer {
+	dayCount := strings.ToUpper(cfg.Interest.DayCount)
+	if dayCount == "" {
+		dayCount = DayCountACT365
+	}
+
+	return &Accruer{
+		db:       db,
+		txs:      txs,
+		rates:    cfg.Interest.AnnualRates,
+		dayCount: dayCount,
+	}
+}
+
+// DailyInterest returns the interest earned by the balance over 1 day,
+// annualRate being in percent
+func DailyInterest(balance, annualRate float64, dayCount string, date time.Time) float64 {
+	daysInYear := 365.0
+	switch dayCount {
+	case DayCountACT360:
+		daysInYear = 360
+
+	case DayCountACTACT:
+		y := date.Year()
+		if y%4 == 0 && (y%100 != 0 || y%400 == 0) {
+			daysInYear = 366
+		}
+	}
+
+	return balance * annualRate / 100 / daysInYear
+}
+
+// AccrueDay accrues the interest of every account for the given date,
+// based on the balance at the end of that day. The day must be over.
+func (a *Accruer) AccrueDay(ctx context.Context, date time.Time) (int, error) {
+	day := truncateDay(date)
+	dayEnd := day.AddDate(0, 0, 1)
+	if dayEnd.After(time.Now()) {
+		return 0, ErrPeriodNotOver
+	}
+
+	accrued := 0
+	err := a.txs.InTx(ctx, a.db, "AccrueDay", func(tx *sqlx.Tx) error {
+		accrued = 0
+
+		// claim the day first, a concurrent run of the same day waits on
+		// the primary key and then reports ErrAlreadyAccrued
+		res, err := tx.Exec(`
+            INSERT INTO interest_accrual_runs
+                (accrual_date, accounts)
+            VALUES
+                ($1, 0)
+            ON CONFLICT (accrual_date) DO NOTHING
+        `, day)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrAlreadyAccrued
+		}
+
+		// the end of day balance is the current balance with every later
+		// movement reverted, this keeps backfills of old days correct
+		q := `
+            SELECT
+                u.id, u.tier,
+                u.balance
+                + COALESCE((SELECT SUM(b.balance) FROM balance_buckets b WHERE b.user_id = u.id), 0)
+                - COALESCE(SUM(
+                    CASE WHEN t.type = 'credit' THEN t.amount ELSE -t.amount END
+                ), 0) AS balance
+            FROM users u
+            LEFT JOIN transactions t ON t.user_id = u.id AND t.created_at >= $1
+            WHERE u.created_at < $1
+            GROUP BY u.id
+        `
+		balances := []account.Account{}
+		err = tx.Select(&balances, q, dayEnd)
+		if err != nil {
+			return err
+		}
+
+		qAccrual := `
+            INSERT INTO interest_accruals
+                (user_id, accrual_date, balance, annual_rate, amount)
+            VALUES
+                ($1, $2, $3, $4, $5)
+            ON CONFLICT (user_id, accrual_date) DO NOTHING
+        `
+
+		for _, acc := range balances {
+			rate := a.rates[acc.Tier]
+			if rate <= 0 || acc.Balance <= 0 {
+				continue
+			}
+
+			amount := DailyInterest(acc.Balance, rate, a.dayCount, day)
+			_, err = tx.Exec(qAccrual, acc.ID, day, acc.Balance, rate, amount)
+			if err != nil {
+				return err
+			}
+			accrued++
+		}
+
+		_, err = tx.Exec(`UPDATE interest_accrual_runs SET accounts = $2 WHERE accrual_date = $1`, day, accrued)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return accrued, nil
+}
+
+// Backfill accrues every day within [from, to] that was not accrued yet,
+// returning the days that were accrued by this call
+func (a *Accruer) Backfill(ctx context.Context, from, to time.Time) ([]time.Time, error) {
+	done := []time.Time{}
+	for day := truncateDay(from); !day.After(truncateDay(to)); day = day.AddDate(0, 0, 1) {
+		_, err := a.AccrueDay(ctx, day)
+		switch err {
+		case nil:
+			done = append(done, day)
+		case ErrAlreadyAccrued:
+		default:
+			return done, fmt.Errorf("interest: backfill of %s: %w", day.Format(time.DateOnly), err)
+		}
+	}
+
+	return done, nil
+}
+
+// PayoutMonth credits the unpaid accruals of the month containing the
+// given date, 1 credit transaction per account. The month must be over.
+// It returns the number of accounts paid and the total paid out.
+func (a *Accruer) PayoutMonth(ctx context.Context, month time.Time) (int, float64, error) {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	if to.After(time.Now()) {
+		return 0, 0, ErrPeriodNotOver
+	}
+
+	accounts := []account.Account{}
+	err := a.db.SelectContext(ctx, &accounts, `
+        SELECT u.id, u.username
+        FROM users u
+        WHERE EXISTS (
+            SELECT 1
+            FROM interest_accruals i
+            WHERE i.user_id = u.id AND i.accrual_date >= $1 AND i.accrual_date < $2 AND i.paid_at IS NULL
+        )
+        ORDER BY u.id
+    `, from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	paid := 0
+	total := 0.0
+	for _, acc := range accounts {
+		amount, err := a.payout(ctx, acc, from, to)
+		if err != nil {
+			return paid, total, err
+		}
+
+		if amount > 0 {
+			paid++
+			total += amount
+		}
+	}
+
+	return paid, total, nil
+}
+
+// payout pays the unpaid accruals of 1 account, the credit and the
+// marking of the accruals are committed together
+func (a *Accruer) payout(ctx context.Context, acc account.Account, from, to time.Time) (float64, error) {
+	type accrual struct {
+		ID     int64   `db:"id"`
+		Amount float64 `db:"amount"`
+	}
+
+	var amount float64
+	var trxID *int
+	err := a.txs.InTx(ctx, a.db, "InterestPayout", func(tx *sqlx.Tx) error {
+		amount, trxID = 0, nil
+
+		accruals := []accrual{}
+		err := tx.Select(&accruals, `
+            SELECT id, amount
+            FROM interest_accruals
+            WHERE user_id = $1 AND accrual_date >= $2 AND accrual_date < $3 AND paid_at IS NULL
+            FOR UPDATE
+        `, acc.ID, from, to)
+		if err != nil {
+			return err
+		}
+
+		sum := 0.0
+		ids := make([]int64, 0, len(accruals))
+		for _, accrual := range accruals {
+			sum += accrual.Amount
+			ids = append(ids, accrual.ID)
+		}
+
+		// balances are stored as DECIMAL(15, 2), the sub-cent remainder is
+		// not carried over
+		amount = math.Round(sum*100) / 100
+
+		if amount > 0 {
+			trx, err := account.Credit(tx, acc.ID, amount)
+			if err != nil {
+				return err
+			}
+			trxID = &trx.ID
+		}
+
+		_, err = tx.Exec(`
+            UPDATE interest_accruals
+            SET
+                paid_at = CURRENT_TIMESTAMP,
+                payout_trx_id = $2
+            WHERE
+                id = ANY($1)
+        `, pq.Array(ids), trxID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if trxID != nil {
+		logging.Audit(slog.Default().With(slog.String("operation", "InterestPayout")), logging.MoneyMovement{
+			Actor:   logging.ActorInterest,
+			Account: acc.Username,
+			TrxType: account.TrxTypeCredit,
+			Amount:  amount,
+			TrxID:   *trxID,
+		})
+	}
+
+	return amount, nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,60 @@
+package interest
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDailyInterest(t *testing.T) {
+	tests := []struct {
+		name     string
+		balance  float64
+		rate     float64
+		dayCount string
+		date     time.Time
+		want     float64
+	}{
+		{
+			name:     "test_act_365",
+			balance:  3650000,
+			rate:     5,
+			dayCount: DayCountACT365,
+			date:     time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			want:     500,
+		},
+		{
+			name:     "test_act_360",
+			balance:  3600000,
+			rate:     5,
+			dayCount: DayCountACT360,
+			date:     time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC),
+			want:     500,
+		},
+		{
+			name:     "test_act_act_leap_year",
+			balance:  3660000,
+			rate:     5,
+			dayCount: DayCountACTACT,
+			date:     time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			want:     500,
+		},
+		{
+			name:     "test_act_act_common_year",
+			balance:  3650000,
+			rate:     5,
+			dayCount: DayCountACTACT,
+			date:     time.Date(2100, time.March, 1, 0, 0, 0, 0, time.UTC),
+			want:     500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DailyInterest(tt.balance, tt.rate, tt.dayCount, tt.date)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("DailyInterest() mismatch. Want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
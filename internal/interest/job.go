@@ -0,0 +1,89 @@
+package interest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/leader"
+)
+
+// leaderLockKey is the advisory lock key of the interest job leader
+const leaderLockKey = 30_000_001
+
+const pollInterval = time.Hour
+
+// Job accrues the previous day every day, and pays the previous month
+// out starting on the payout day. Missed days are not caught up by the
+// job, they are accrued with the backfill command.
+type Job struct {
+	accruer   *Accruer
+	elector   *leader.Elector
+	payoutDay int
+}
+
+func NewJob(cfg *config.Config, db *sqlx.DB, txs *account.TxPolicy) *Job {
+	payoutDay := cfg.Interest.PayoutDay
+	if payoutDay <= 0 {
+		payoutDay = 1
+	}
+
+	return &Job{
+		accruer:   NewAccruer(cfg, db, txs),
+		elector:   leader.NewElector(db, leaderLockKey, "interest"),
+		payoutDay: payoutDay,
+	}
+}
+
+// Run runs the job until the context is cancelled
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	defer j.elector.Release()
+
+	for {
+		if j.elector.IsLeader(ctx) {
+			j.RunOnce(ctx, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce accrues the day before now and, from the payout day on, pays
+// out the month before now. Both steps are no-ops when already done.
+func (j *Job) RunOnce(ctx context.Context, now time.Time) {
+	today := truncateDay(now)
+
+	yesterday := today.AddDate(0, 0, -1)
+	n, err := j.accruer.AccrueDay(ctx, yesterday)
+	switch {
+	case err == nil:
+		slog.Info("interest accrued", "date", yesterday.Format(time.DateOnly), "accounts", n)
+	case !errors.Is(err, ErrAlreadyAccrued):
+		slog.Error("interest accrual failed", "date", yesterday.Format(time.DateOnly), "error", err)
+	}
+
+	if today.Day() < j.payoutDay {
+		return
+	}
+
+	lastMonth := time.Date(today.Year(), today.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	paid, total, err := j.accruer.PayoutMonth(ctx, lastMonth)
+	if err != nil {
+		slog.Error("interest payout failed", "month", lastMonth.Format("2006-01"), "error", err)
+		return
+	}
+
+	if paid > 0 {
+		slog.Info("interest paid out", "month", lastMonth.Format("2006-01"), "accounts", paid, "total", total)
+	}
+}
@@ -0,0 +1,81 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Elector elects 1 leader among the replicas using a postgres session
+// level advisory lock. The lock is held on a dedicated connection, so
+// the leadership is kept across cycles until the connection is lost or
+// Release is called.
+type Elector struct {
+	db   *sqlx.DB
+	key  int64
+	name string
+
+	mu   sync.Mutex
+	conn *sqlx.Conn
+}
+
+// NewElector creates an elector for the lock key. Every job that should
+// only run on 1 replica must use its own key.
+func NewElector(db *sqlx.DB, key int64, name string) *Elector {
+	return &Elector{
+		db:   db,
+		key:  key,
+		name: name,
+	}
+}
+
+// IsLeader tries to acquire the leadership when not yet held, and checks
+// that it is still held otherwise.
+func (e *Elector) IsLeader(ctx context.Context) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn != nil {
+		// the lock is gone along with the connection
+		if err := e.conn.PingContext(ctx); err == nil {
+			return true
+		}
+
+		slog.Warn("leadership lost", "job", e.name)
+		e.conn.Close()
+		e.conn = nil
+	}
+
+	conn, err := e.db.Connx(ctx)
+	if err != nil {
+		slog.Error("leader election failed", "job", e.name, "error", err)
+		return false
+	}
+
+	var locked bool
+	err = conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1)`, e.key)
+	if err != nil || !locked {
+		conn.Close()
+		return false
+	}
+
+	slog.Info("leadership acquired", "job", e.name)
+	e.conn = conn
+	return true
+}
+
+// Release gives up the leadership, if held
+func (e *Elector) Release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		return
+	}
+
+	e.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, e.key)
+	e.conn.Close()
+	e.conn = nil
+}
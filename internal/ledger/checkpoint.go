@@ -0,0 +1,237 @@
+package ledger
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidKey       = fmt.Errorf("ledger: invalid signing key")
+	ErrInvalidSignature = fmt.Errorf("ledger: invalid checkpoint signature")
+	ErrRootMismatch     = fmt.Errorf("ledger: checkpoint root does not match its heads")
+)
+
+// Head is the latest hash of the chain of an account, or of a bucket of a
+// hot account
+type Head struct {
+	AccountID int    `db:"account_id" json:"account_id"`
+	Bucket    *int   `db:"bucket" json:"bucket,omitempty"`
+	Hash      string `db:"hash" json:"hash"`
+}
+
+// Checkpoint is a signed snapshot of the chain heads. Once exported, a
+// later rewrite of the history before the checkpoint is detected since
+// the signed heads are no longer part of the chains.
+type Checkpoint struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Heads     []Head    `json:"heads"`
+	Root      string    `json:"root"`
+
+	// PublicKey and Signature are hex encoded ed25519
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// GenerateKey returns a new hex encoded ed25519 seed
+func GenerateKey() (string, error) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(priv.Seed()), nil
+}
+
+// LoadKey reads the hex encoded ed25519 seed from the file
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidKey
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Root returns the hash over the heads, in the given order
+func Root(heads []Head) string {
+	h := sha256.New()
+	for _, head := range heads {
+		// the account heads keep their format, the older roots stay valid
+		if head.Bucket != nil {
+			fmt.Fprintf(h, "%d/%d:%s\n", head.AccountID, *head.Bucket, head.Hash)
+			continue
+		}
+		fmt.Fprintf(h, "%d:%s\n", head.AccountID, head.Hash)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func signedMessage(cp *Checkpoint) []byte {
+	return []byte(fmt.Sprintf("ledger-checkpoint|v1|%d|%s", cp.CreatedAt.UnixMicro(), cp.Root))
+}
+
+// Sign fills the root, public key and signature of the checkpoint
+func Sign(cp *Checkpoint, key ed25519.PrivateKey) {
+	cp.Root = Root(cp.Heads)
+	cp.PublicKey = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	cp.Signature = hex.EncodeToString(ed25519.Sign(key, signedMessage(cp)))
+}
+
+// VerifySignature checks that the checkpoint was signed by its public key
+// and that its root matches its heads. Whether the public key is trusted
+// is up to the caller.
+func VerifySignature(cp *Checkpoint) error {
+	if Root(cp.Heads) != cp.Root {
+		return ErrRootMismatch
+	}
+
+	pub, err := hex.DecodeString(cp.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return ErrInvalidKey
+	}
+
+	sig, err := hex.DecodeString(cp.Signature)
+	if err != nil || !ed25519.Verify(pub, signedMessage(cp), sig) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// CreateCheckpoint signs the current chain heads and stores the checkpoint
+func CreateCheckpoint(ctx context.Context, db *sqlx.DB, key ed25519.PrivateKey) (*Checkpoint, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cp := &Checkpoint{
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		Heads:     []Head{},
+	}
+	qHeads := `
+        SELECT
+            id AS account_id, NULL::INTEGER AS bucket, chain_head AS hash
+        FROM users
+        WHERE chain_head IS NOT NULL
+        UNION ALL
+        SELECT
+            user_id, bucket, chain_head
+        FROM balance_buckets
+        WHERE chain_head IS NOT NULL
+        ORDER BY account_id, bucket NULLS FIRST
+    `
+	err = tx.Select(&cp.Heads, qHeads)
+	if err != nil {
+		return nil, err
+	}
+
+	Sign(cp, key)
+
+	heads, err := json.Marshal(cp.Heads)
+	if err != nil {
+		return nil, err
+	}
+
+	qCheckpoint := `
+        INSERT INTO ledger_checkpoints
+            (root, heads, public_key, signature, created_at)
+        VALUES
+            ($1, $2, $3, $4, $5)
+        RETURNING
+            id
+    `
+	err = tx.Get(&cp.ID, qCheckpoint, cp.Root, heads, cp.PublicKey, cp.Signature, cp.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// ListCheckpoints returns the stored checkpoints with an ID above afterID
+func ListCheckpoints(ctx context.Context, db *sqlx.DB, afterID int) ([]Checkpoint, error) {
+	type row struct {
+		ID        int       `db:"id"`
+		Root      string    `db:"root"`
+		Heads     []byte    `db:"heads"`
+		PublicKey string    `db:"public_key"`
+		Signature string    `db:"signature"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	q := `
+        SELECT
+            id, root, heads, public_key, signature, created_at
+        FROM ledger_checkpoints
+        WHERE id > $1
+        ORDER BY id
+    `
+	rows := []row{}
+	err := db.SelectContext(ctx, &rows, q, afterID)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := make([]Checkpoint, 0, len(rows))
+	for _, r := range rows {
+		cp := Checkpoint{
+			ID:        r.ID,
+			CreatedAt: r.CreatedAt.UTC(),
+			Root:      r.Root,
+			PublicKey: r.PublicKey,
+			Signature: r.Signature,
+		}
+		if err := json.Unmarshal(r.Heads, &cp.Heads); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+
+	return checkpoints, nil
+}
+
+// CheckHeads reports the heads of the checkpoint that are no longer part
+// of the chain of their account
+func CheckHeads(ctx context.Context, db *sqlx.DB, cp *Checkpoint) ([]Head, error) {
+	q := `
+        SELECT EXISTS (
+            SELECT 1 FROM transactions WHERE user_id = $1 AND hash = $2
+        )
+    `
+
+	missing := []Head{}
+	for _, head := range cp.Heads {
+		var found bool
+		err := db.GetContext(ctx, &found, q, head.AccountID, head.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			missing = append(missing, head)
+		}
+	}
+
+	return missing, nil
+}
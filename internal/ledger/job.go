@@ -0,0 +1,68 @@
+package ledger
+
+import (
+	"context"
+	"crypto/ed25519"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/leader"
+)
+
+// leaderLockKey is the advisory lock key of the checkpoint job leader
+const leaderLockKey = 32_000_001
+
+const defaultCheckpointInterval = time.Hour
+
+// CheckpointJob signs the chain heads periodically
+type CheckpointJob struct {
+	db       *sqlx.DB
+	key      ed25519.PrivateKey
+	elector  *leader.Elector
+	interval time.Duration
+}
+
+func NewCheckpointJob(cfg *config.Config, db *sqlx.DB) (*CheckpointJob, error) {
+	key, err := LoadKey(cfg.Ledger.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(cfg.Ledger.CheckpointIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	return &CheckpointJob{
+		db:       db,
+		key:      key,
+		elector:  leader.NewElector(db, leaderLockKey, "ledger-checkpoint"),
+		interval: interval,
+	}, nil
+}
+
+// Run runs the job until the context is cancelled
+func (j *CheckpointJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	defer j.elector.Release()
+
+	for {
+		if j.elector.IsLeader(ctx) {
+			cp, err := CreateCheckpoint(ctx, j.db, j.key)
+			if err != nil {
+				slog.Error("ledger checkpoint failed", "error", err)
+			} else {
+				slog.Info("ledger checkpoint signed", "checkpoint_id", cp.ID, "root", cp.Root, "accounts", len(cp.Heads))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
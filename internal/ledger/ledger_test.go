@@ -0,0 +1,129 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/yeyee2901/test/internal/account"
+)
+
+func buildChain(accountID int, n int) []chainedTrx {
+	trxs := []chainedTrx{}
+	prevHash := ""
+	for i := 1; i <= n; i++ {
+		trx := chainedTrx{
+			Transactions: account.Transactions{
+				ID:        i,
+				UserID:    accountID,
+				Amount:    float64(i) * 10,
+				TrxType:   account.TrxTypeCredit,
+				CreatedAt: sql.NullTime{Time: time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC), Valid: true},
+			},
+		}
+		hash := account.ChainHash(&trx.Transactions, prevHash)
+		trx.StoredPrevHash = sql.NullString{String: prevHash, Valid: prevHash != ""}
+		trx.StoredHash = sql.NullString{String: hash, Valid: true}
+		trxs = append(trxs, trx)
+		prevHash = hash
+	}
+
+	return trxs
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(trxs []chainedTrx) ([]chainedTrx, string)
+		wantTrx int
+		wantOK  bool
+	}{
+		{
+			name:   "intact",
+			tamper: func(trxs []chainedTrx) ([]chainedTrx, string) { return trxs, trxs[2].StoredHash.String },
+			wantOK: true,
+		},
+		{
+			name: "altered amount",
+			tamper: func(trxs []chainedTrx) ([]chainedTrx, string) {
+				trxs[1].Amount = 1000
+				return trxs, trxs[2].StoredHash.String
+			},
+			wantTrx: 2,
+		},
+		{
+			name: "removed transaction",
+			tamper: func(trxs []chainedTrx) ([]chainedTrx, string) {
+				return append(trxs[:1:1], trxs[2]), trxs[2].StoredHash.String
+			},
+			wantTrx: 3,
+		},
+		{
+			name: "removed tail",
+			tamper: func(trxs []chainedTrx) ([]chainedTrx, string) {
+				return trxs[:2], trxs[2].StoredHash.String
+			},
+			wantTrx: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trxs, head := tt.tamper(buildChain(7, 3))
+			brk := verifyChain(7, head, trxs)
+			if tt.wantOK {
+				if brk != nil {
+					t.Fatalf("verifyChain() = %+v, want no break", brk)
+				}
+				return
+			}
+			if brk == nil || brk.TransactionID != tt.wantTrx {
+				t.Fatalf("verifyChain() = %+v, want break at transaction %d", brk, tt.wantTrx)
+			}
+		})
+	}
+}
+
+func TestCheckpointSignature(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := &Checkpoint{
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Heads:     []Head{{AccountID: 1, Hash: "aa"}, {AccountID: 2, Hash: "bb"}},
+	}
+	Sign(cp, key)
+	if err := VerifySignature(cp); err != nil {
+		t.Fatalf("VerifySignature() = %v", err)
+	}
+
+	cp.Heads[1].Hash = "cc"
+	if err := VerifySignature(cp); err != ErrRootMismatch {
+		t.Fatalf("VerifySignature() with altered head = %v, want %v", err, ErrRootMismatch)
+	}
+
+	cp.Root = Root(cp.Heads)
+	if err := VerifySignature(cp); err != ErrInvalidSignature {
+		t.Fatalf("VerifySignature() with re-rooted heads = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestRootBucketHeads(t *testing.T) {
+	// the roots exported before the buckets existed stay valid
+	sum := sha256.Sum256([]byte("1:aa\n"))
+	heads := []Head{{AccountID: 1, Hash: "aa"}}
+	if got := Root(heads); got != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Root() = %s, want the format of the account heads unchanged", got)
+	}
+
+	bucket := 0
+	withBucket := []Head{heads[0], {AccountID: 1, Bucket: &bucket, Hash: "bb"}}
+	withAccount := []Head{heads[0], {AccountID: 1, Hash: "bb"}}
+	if Root(withBucket) == Root(withAccount) {
+		t.Error("Root() does not tell a bucket head from an account head")
+	}
+}
@@ -0,0 +1,107 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/internal/account"
+)
+
+// Break is the first broken link found in the chain of an account
+type Break struct {
+	AccountID int `json:"account_id"`
+
+	// Bucket is set when the chain of a bucket of a hot account is broken
+	Bucket *int `json:"bucket,omitempty"`
+
+	// TransactionID is 0 when the chain head of the account is broken
+	TransactionID int    `json:"transaction_id"`
+	Reason        string `json:"reason"`
+}
+
+type VerifyResult struct {
+	AccountsChecked     int     `json:"accounts_checked"`
+	TransactionsChecked int     `json:"transactions_checked"`
+	Breaks              []Break `json:"breaks"`
+}
+
+type chainedTrx struct {
+	account.Transactions
+	StoredPrevHash sql.NullString `db:"prev_hash"`
+	StoredHash     sql.NullString `db:"hash"`
+}
+
+// Verify walks the hash chain of every account, and of every bucket of
+// the hot accounts, and reports the first broken link of each broken chain
+func Verify(ctx context.Context, db *sqlx.DB) (*VerifyResult, error) {
+	type chain struct {
+		ID     int            `db:"id"`
+		Bucket *int           `db:"bucket"`
+		Head   sql.NullString `db:"chain_head"`
+	}
+	qChains := `
+        SELECT id, NULL::INTEGER AS bucket, chain_head FROM users
+        UNION ALL
+        SELECT user_id, bucket, chain_head FROM balance_buckets
+        ORDER BY id, bucket NULLS FIRST
+    `
+	chains := []chain{}
+	err := db.SelectContext(ctx, &chains, qChains)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+        SELECT
+            id, user_id, amount, type, parent_id, created_at, bucket, prev_hash, hash
+        FROM transactions
+        WHERE user_id = $1 AND bucket IS NOT DISTINCT FROM $2
+        ORDER BY id
+    `
+
+	result := &VerifyResult{
+		Breaks: []Break{},
+	}
+	for _, c := range chains {
+		trxs := []chainedTrx{}
+		err = db.SelectContext(ctx, &trxs, q, c.ID, c.Bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.Bucket == nil {
+			result.AccountsChecked++
+		}
+		result.TransactionsChecked += len(trxs)
+		if brk := verifyChain(c.ID, c.Head.String, trxs); brk != nil {
+			brk.Bucket = c.Bucket
+			result.Breaks = append(result.Breaks, *brk)
+		}
+	}
+
+	return result, nil
+}
+
+// verifyChain checks the transactions of 1 account, ordered by ID,
+// against each other and against the chain head of the account
+func verifyChain(accountID int, head string, trxs []chainedTrx) *Break {
+	prevHash := ""
+	for _, trx := range trxs {
+		if trx.StoredPrevHash.String != prevHash {
+			return &Break{AccountID: accountID, TransactionID: trx.ID, Reason: "previous hash mismatch, a transaction was removed or reordered"}
+		}
+
+		if account.ChainHash(&trx.Transactions, prevHash) != trx.StoredHash.String {
+			return &Break{AccountID: accountID, TransactionID: trx.ID, Reason: "hash mismatch, the transaction was altered"}
+		}
+
+		prevHash = trx.StoredHash.String
+	}
+
+	if head != prevHash {
+		return &Break{AccountID: accountID, Reason: "chain head mismatch, the latest transactions were removed"}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// the attributes of the audit records, every money movement is logged
+// with the same keys so the movements can be queried across the sources
+const (
+	KeyAudit          = "audit"
+	KeyActor          = "actor"
+	KeyAccount        = "account"
+	KeyCounterAccount = "counter_account"
+	KeyAmount         = "amount"
+	KeyFee            = "fee"
+	KeyTrxID          = "trx_id"
+	KeyTrxType        = "trx_type"
+)
+
+// the actors of the money movements. The reconciliation adjustments are
+// attributed to ActorReconcile and the approving operator, see ReconcileActor.
+const (
+	ActorHTTP      = "http"
+	ActorGRPC      = "grpc"
+	ActorScheduler = "scheduler"
+	ActorImporter  = "importer"
+	ActorInterest  = "interest"
+	ActorReconcile = "reconcile"
+	ActorBatch     = "batch"
+)
+
+const auditMessage = "money movement"
+
+// ReconcileActor is the actor of the adjustments approved by the operator
+func ReconcileActor(approvedBy string) string {
+	return ActorReconcile + ":" + approvedBy
+}
+
+// MoneyMovement is the audit record of 1 credit or debit. Account is the
+// username of the account, so the records of every source hash to the same
+// value in the RedactHandler. CounterAccount is empty without one.
+type MoneyMovement struct {
+	Actor          string
+	Account        string
+	CounterAccount string
+	TrxType        string
+	Amount         float64
+	Fee            float64
+	TrxID          int
+}
+
+// Audit emits the audit record of a money movement on the logger, which
+// carries the request attributes, e.g. the request ID
+func Audit(logger *slog.Logger, m MoneyMovement) {
+	attrs := []slog.Attr{
+		slog.Bool(KeyAudit, true),
+		slog.String(KeyActor, m.Actor),
+		slog.String(KeyAccount, m.Account),
+		slog.String(KeyTrxType, m.TrxType),
+		slog.Float64(KeyAmount, m.Amount),
+		slog.Int(KeyTrxID, m.TrxID),
+	}
+	if m.CounterAccount != "" {
+		attrs = append(attrs, slog.String(KeyCounterAccount, m.CounterAccount))
+	}
+	if m.Fee != 0 {
+		attrs = append(attrs, slog.Float64(KeyFee, m.Fee))
+	}
+
+	logger.LogAttrs(context.Background(), slog.LevelInfo, auditMessage, attrs...)
+}
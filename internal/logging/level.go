@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler serves the level of the logger built by New: GET returns
+// it, PUT changes it, e.g. {"level": "debug"}
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPut:
+			body := new(levelBody)
+			err := json.NewDecoder(r.Body).Decode(body)
+			if err != nil {
+				http.Error(w, "invalid body", http.StatusBadRequest)
+				return
+			}
+
+			lvl, err := ParseLevel(body.Level)
+			if err != nil || body.Level == "" {
+				http.Error(w, "level must be one of debug, info, warn, error", http.StatusBadRequest)
+				return
+			}
+
+			previous := Level()
+			SetLevel(lvl)
+			slog.Warn("log level changed", "from", previous.String(), "to", lvl.String())
+
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(levelBody{Level: Level().String()})
+	})
+}
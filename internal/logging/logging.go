@@ -1,21 +1,177 @@
 package logging
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"strings"
 
+	"github.com/yeyee2901/test/config"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func NewFileLogger(filepath string, serviceName string, level slog.Leveler) *slog.Logger {
-	lj := &lumberjack.Logger{
-		Filename: filepath,
-		Compress: true,
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	SinkStdout = "stdout"
+	SinkStderr = "stderr"
+	SinkFile   = "file"
+)
+
+// level is the level of the logger built by New, like expvar it is
+// process wide so it can be served by LevelHandler
+var level = new(slog.LevelVar)
+
+// New builds the logger of the config: 1 handler per sink, all of them
+// behind the redaction and the runtime level
+func New(cfg *config.Config) (*slog.Logger, error) {
+	lvl, err := ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		return nil, err
+	}
+	level.Set(lvl)
+
+	sinks := cfg.Logging.Sinks
+	if len(sinks) == 0 {
+		sinks = []config.LogSinkConfig{{Type: SinkFile, Path: cfg.Server.Logfile, Compress: true}}
 	}
 
-	logger := slog.New(slog.NewJSONHandler(lj, &slog.HandlerOptions{Level: level}))
+	handlers := make([]slog.Handler, 0, len(sinks))
+	for _, sink := range sinks {
+		h, err := newSinkHandler(sink, cfg.Logging.Format)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, h)
+	}
+
+	var handler slog.Handler = multiHandler(handlers)
+	if len(handlers) == 1 {
+		handler = handlers[0]
+	}
+
+	logger := slog.New(NewRedactHandler(handler, NewRedactOptions(cfg)))
 	logger = logger.With(
-		slog.String("service", serviceName),
+		slog.String("service", cfg.Server.Name),
 	)
 
-	return logger
+	return logger, nil
+}
+
+// NewRedactOptions returns the redaction of the config on top of the
+// defaults
+func NewRedactOptions(cfg *config.Config) RedactOptions {
+	return RedactOptions{
+		RedactKeys: append(append([]string{}, DefaultRedactKeys...), cfg.Logging.RedactKeys...),
+		HashKeys:   append(append([]string{}, DefaultHashKeys...), cfg.Logging.HashKeys...),
+		HashSalt:   cfg.Logging.HashSalt,
+	}
+}
+
+// ParseLevel parses debug, info, warn or error, "" is info
+func ParseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+
+	err := lvl.UnmarshalText([]byte(s))
+	if err != nil {
+		return 0, fmt.Errorf("logging: invalid level %q", s)
+	}
+
+	return lvl, nil
+}
+
+// Level returns the current level of the logger built by New
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel changes the level of the logger built by New, without restart
+func SetLevel(lvl slog.Level) {
+	level.Set(lvl)
+}
+
+func newSinkHandler(sink config.LogSinkConfig, defaultFormat string) (slog.Handler, error) {
+	var w io.Writer
+	switch sink.Type {
+	case SinkStdout:
+		w = os.Stdout
+
+	case SinkStderr:
+		w = os.Stderr
+
+	case SinkFile:
+		if sink.Path == "" {
+			return nil, errors.New("logging: file sink without path")
+		}
+		w = &lumberjack.Logger{
+			Filename:   sink.Path,
+			MaxSize:    sink.MaxSizeMB,
+			MaxAge:     sink.MaxAgeDays,
+			MaxBackups: sink.MaxBackups,
+			Compress:   sink.Compress,
+		}
+
+	default:
+		return nil, fmt.Errorf("logging: unknown sink type %q", sink.Type)
+	}
+
+	format := sink.Format
+	if format == "" {
+		format = defaultFormat
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	case FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+}
+
+// multiHandler writes every record to all the handlers
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, lvl) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
 }
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yeyee2901/test/config"
+)
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Server: config.ServerConfig{Name: "test"},
+		Logging: config.LoggingConfig{
+			Level:  "warn",
+			Format: FormatJSON,
+			Sinks: []config.LogSinkConfig{
+				{Type: SinkFile, Path: filepath.Join(dir, "app.log")},
+				{Type: SinkFile, Path: filepath.Join(dir, "app.txt"), Format: FormatText},
+			},
+		},
+	}
+	t.Cleanup(func() { SetLevel(slog.LevelInfo) })
+
+	logger, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("dropped")
+	logger.Warn("kept", "password", "s3cr3t")
+
+	for name, prefix := range map[string]string{"app.log": `{"time"`, "app.txt": "time="} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := string(b)
+
+		if !strings.HasPrefix(out, prefix) {
+			t.Errorf("%s = %q, want the %q format", name, out, prefix)
+		}
+		if strings.Contains(out, "dropped") || !strings.Contains(out, "kept") {
+			t.Errorf("%s = %q, want only the warn record", name, out)
+		}
+		if strings.Contains(out, "s3cr3t") {
+			t.Errorf("%s = %q, want the password redacted", name, out)
+		}
+	}
+
+	SetLevel(slog.LevelDebug)
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("SetLevel() did not apply to the logger")
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		logging config.LoggingConfig
+	}{
+		{"level", config.LoggingConfig{Level: "verbose"}},
+		{"format", config.LoggingConfig{Format: "xml", Sinks: []config.LogSinkConfig{{Type: SinkStdout}}}},
+		{"sink type", config.LoggingConfig{Sinks: []config.LogSinkConfig{{Type: "syslog"}}}},
+		{"file without path", config.LoggingConfig{Sinks: []config.LogSinkConfig{{Type: SinkFile}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(&config.Config{Logging: tt.logging})
+			if err == nil {
+				t.Errorf("New() succeeded unexpectedly")
+			}
+		})
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	t.Cleanup(func() { SetLevel(slog.LevelInfo) })
+	SetLevel(slog.LevelInfo)
+	handler := LevelHandler()
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, "/debug/log-level", strings.NewReader(`{"level":"debug"}`)))
+	if resp.Code != http.StatusOK || Level() != slog.LevelDebug {
+		t.Fatalf("PUT = %d, level %s", resp.Code, Level())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/log-level", nil))
+	if !strings.Contains(resp.Body.String(), `"DEBUG"`) {
+		t.Errorf("GET = %s", resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, "/debug/log-level", strings.NewReader(`{"level":"loud"}`)))
+	if resp.Code != http.StatusBadRequest || Level() != slog.LevelDebug {
+		t.Errorf("invalid PUT = %d, level %s", resp.Code, Level())
+	}
+}
@@ -0,0 +1,40 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Event is a single row of the outbox_events table. Events are written
+// in the same database transaction as the change they describe, and
+// later delivered to the sinks by the Relay.
+type Event struct {
+	ID          int64           `db:"id" json:"id"`
+	AggregateID int             `db:"aggregate_id" json:"aggregate_id"`
+	EventType   string          `db:"event_type" json:"event_type"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+
+	// Attempts is how many times the delivery failed so far
+	Attempts int `db:"attempts" json:"-"`
+}
+
+// Write stores a new event inside the given transaction. The event only
+// becomes visible to the Relay once the transaction is committed.
+func Write(tx *sqlx.Tx, aggregateID int, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	q := `
+        INSERT INTO outbox_events
+            (aggregate_id, event_type, payload)
+        VALUES
+            ($1, $2, $3)
+    `
+	_, err = tx.Exec(q, aggregateID, eventType, data)
+	return err
+}
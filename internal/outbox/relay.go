@@ -0,0 +1,320 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/leader"
+)
+
+// relayLockKey is the advisory lock key of the relay leader, so that only
+// 1 replica delivers events at a time. This is what keeps the per-account
+// ordering intact when multiple replicas run.
+const relayLockKey = 26_000_001
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	defaultMaxAttempts  = 20
+	defaultBackoffBase  = time.Second
+	defaultBackoffMax   = 5 * time.Minute
+
+	// claimLease is how long the claimed events are hidden from another
+	// relay taking over, e.g. after a crash in the middle of a batch
+	claimLease = 5 * time.Minute
+)
+
+// Relay delivers undelivered outbox events to the sinks. Delivery is
+// at-least-once: an event is only marked as delivered after every sink
+// accepted it, so a crash in between will cause a redelivery.
+//
+// Events of the same account are delivered in the order they were
+// written. Once an event fails, only that event of the account is retried
+// (with exponential backoff) until it is delivered or dead, so a failing
+// account never holds back the others. A dead event is kept for
+// inspection and the account moves on to its next event.
+//
+// The events are claimed and committed before they are published, and
+// their outcome is recorded afterwards, so a slow sink holds no lock.
+type Relay struct {
+	db           *sqlx.DB
+	sinks        []Sink
+	elector      *leader.Elector
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+}
+
+func NewRelay(cfg *config.Config, db *sqlx.DB, sinks []Sink) *Relay {
+	r := &Relay{
+		db:           db,
+		sinks:        sinks,
+		elector:      leader.NewElector(db, relayLockKey, "outbox"),
+		pollInterval: defaultPollInterval,
+		batchSize:    defaultBatchSize,
+		maxAttempts:  defaultMaxAttempts,
+		backoffBase:  defaultBackoffBase,
+		backoffMax:   defaultBackoffMax,
+	}
+
+	if cfg.Outbox.PollIntervalMs > 0 {
+		r.pollInterval = time.Duration(cfg.Outbox.PollIntervalMs) * time.Millisecond
+	}
+	if cfg.Outbox.BatchSize > 0 {
+		r.batchSize = cfg.Outbox.BatchSize
+	}
+	if cfg.Outbox.MaxAttempts > 0 {
+		r.maxAttempts = cfg.Outbox.MaxAttempts
+	}
+	if cfg.Outbox.BackoffBaseSeconds > 0 {
+		r.backoffBase = time.Duration(cfg.Outbox.BackoffBaseSeconds) * time.Second
+	}
+	if cfg.Outbox.BackoffMaxSeconds > 0 {
+		r.backoffMax = time.Duration(cfg.Outbox.BackoffMaxSeconds) * time.Second
+	}
+
+	return r
+}
+
+// Run polls the outbox until the context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	defer r.elector.Release()
+
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			slog.Error("outbox relay cycle failed", "error", err)
+		}
+
+		// keep draining while the batches are full
+		if err == nil && n == r.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce delivers 1 batch of events and returns how many of them were
+// claimed. Nothing is relayed when another replica is the leader.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	if !r.elector.IsLeader(ctx) {
+		return 0, nil
+	}
+
+	events, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	res := relayBatch(ctx, events, r.publish)
+
+	err = r.record(ctx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// claim leases the next events in 1 statement. Every account contributes
+// its events in order from its oldest undelivered one, unless that one
+// already failed: then it is the only event taken, once its retry is due.
+func (r *Relay) claim(ctx context.Context) ([]Event, error) {
+	q := `
+        WITH heads AS (
+            SELECT DISTINCT ON (aggregate_id)
+                id, aggregate_id, attempts, next_attempt_at
+            FROM outbox_events
+            WHERE delivered_at IS NULL AND dead_at IS NULL
+            ORDER BY aggregate_id, id
+        ),
+        claimable AS (
+            SELECT
+                e.id
+            FROM outbox_events e
+            JOIN heads h ON h.aggregate_id = e.aggregate_id
+            WHERE
+                e.delivered_at IS NULL AND e.dead_at IS NULL
+                AND h.next_attempt_at <= CURRENT_TIMESTAMP
+                AND (e.id = h.id OR h.attempts = 0)
+            ORDER BY e.id
+            LIMIT $1
+        )
+        UPDATE outbox_events
+        SET
+            next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+        WHERE
+            id IN (SELECT id FROM claimable)
+        RETURNING
+            id, aggregate_id, event_type, payload, attempts, created_at
+    `
+	events := []Event{}
+	err := r.db.SelectContext(ctx, &events, q, r.batchSize, claimLease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING has no order
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+// failedEvent is an event the sinks did not accept
+type failedEvent struct {
+	Event *Event
+	Err   error
+}
+
+// relayResult is the outcome of publishing a batch
+type relayResult struct {
+	Delivered []int64
+	Failed    []failedEvent
+
+	// Held are the events following a failed one of the same account,
+	// they were not published
+	Held []int64
+}
+
+// relayBatch publishes the events in order. After a failure, the
+// remaining events of that account are held back.
+func relayBatch(ctx context.Context, events []Event, publish func(context.Context, *Event) error) relayResult {
+	res := relayResult{}
+	blocked := map[int]bool{}
+	for i := range events {
+		evt := &events[i]
+		if blocked[evt.AggregateID] {
+			res.Held = append(res.Held, evt.ID)
+			continue
+		}
+
+		err := publish(ctx, evt)
+		if err != nil {
+			slog.Warn("outbox event delivery failed",
+				"event_id", evt.ID,
+				"event_type", evt.EventType,
+				"attempt", evt.Attempts+1,
+				"error", err,
+			)
+
+			blocked[evt.AggregateID] = true
+			res.Failed = append(res.Failed, failedEvent{Event: evt, Err: err})
+			continue
+		}
+
+		res.Delivered = append(res.Delivered, evt.ID)
+	}
+
+	return res
+}
+
+// record stores the outcome of a batch in 1 short transaction
+func (r *Relay) record(ctx context.Context, res relayResult) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qDelivered := `
+        UPDATE outbox_events
+        SET
+            delivered_at = CURRENT_TIMESTAMP,
+            attempts = attempts + 1,
+            last_error = NULL
+        WHERE
+            id = ANY($1)
+    `
+	_, err = tx.ExecContext(ctx, qDelivered, pq.Array(res.Delivered))
+	if err != nil {
+		return err
+	}
+
+	// the held events were never attempted, release their lease
+	qHeld := `
+        UPDATE outbox_events
+        SET
+            next_attempt_at = CURRENT_TIMESTAMP
+        WHERE
+            id = ANY($1)
+    `
+	_, err = tx.ExecContext(ctx, qHeld, pq.Array(res.Held))
+	if err != nil {
+		return err
+	}
+
+	qFailed := `
+        UPDATE outbox_events
+        SET
+            attempts = attempts + 1,
+            last_error = $2,
+            next_attempt_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second',
+            dead_at = CASE WHEN $4 THEN CURRENT_TIMESTAMP END
+        WHERE
+            id = $1
+    `
+	for _, f := range res.Failed {
+		dead, delay := r.retry(f.Event.Attempts + 1)
+		if dead {
+			slog.Error("outbox event is dead",
+				"event_id", f.Event.ID,
+				"event_type", f.Event.EventType,
+				"aggregate_id", f.Event.AggregateID,
+			)
+		}
+
+		_, err = tx.ExecContext(ctx, qFailed, f.Event.ID, f.Err.Error(), delay.Seconds(), dead)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// retry decides what happens to an event after its failed attempt: it is
+// either dead, or retried after the delay
+func (r *Relay) retry(attempts int) (dead bool, delay time.Duration) {
+	if attempts >= r.maxAttempts {
+		return true, 0
+	}
+
+	return false, backoff(attempts, r.backoffBase, r.backoffMax)
+}
+
+// backoff doubles the delay on every attempt, from base up to max
+func backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	return min(delay, max)
+}
+
+func (r *Relay) publish(ctx context.Context, evt *Event) error {
+	for _, s := range r.sinks {
+		if err := s.Publish(ctx, evt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/utils"
+)
+
+// failingSink rejects the events of 1 account
+type failingSink struct {
+	aggregateID int
+}
+
+func (s *failingSink) Publish(ctx context.Context, evt *Event) error {
+	if evt.AggregateID == s.aggregateID {
+		return errors.New("rejected")
+	}
+
+	return nil
+}
+
+func Test_relayBatch(t *testing.T) {
+	events := []Event{
+		{ID: 1, AggregateID: 10},
+		{ID: 2, AggregateID: 20},
+		{ID: 3, AggregateID: 10},
+		{ID: 4, AggregateID: 20},
+		{ID: 5, AggregateID: 10},
+	}
+	sink := &failingSink{aggregateID: 10}
+
+	res := relayBatch(context.Background(), events, sink.Publish)
+
+	if want := []int64{2, 4}; !slices.Equal(res.Delivered, want) {
+		t.Errorf("Delivered mismatch. Want %v; got %v", want, res.Delivered)
+	}
+	if len(res.Failed) != 1 || res.Failed[0].Event.ID != 1 {
+		t.Errorf("Failed mismatch. Want event 1; got %+v", res.Failed)
+	}
+	if want := []int64{3, 5}; !slices.Equal(res.Held, want) {
+		t.Errorf("Held mismatch. Want %v; got %v", want, res.Held)
+	}
+}
+
+func TestRelay_retry(t *testing.T) {
+	r := &Relay{
+		maxAttempts: 3,
+		backoffBase: time.Second,
+		backoffMax:  time.Minute,
+	}
+
+	tests := []struct {
+		name      string
+		attempts  int
+		wantDead  bool
+		wantDelay time.Duration
+	}{
+		{
+			name:      "test_first_failure",
+			attempts:  1,
+			wantDelay: time.Second,
+		},
+		{
+			name:      "test_second_failure",
+			attempts:  2,
+			wantDelay: 2 * time.Second,
+		},
+		{
+			name:     "test_out_of_attempts",
+			attempts: 3,
+			wantDead: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dead, delay := r.retry(tt.attempts)
+			if dead != tt.wantDead || delay != tt.wantDelay {
+				t.Fatalf("retry() mismatch. Want %v, %v; got %v, %v", tt.wantDead, tt.wantDelay, dead, delay)
+			}
+		})
+	}
+}
+
+// A failing account with a full batch of events must not hold back the
+// other accounts, and its failed event must die after the max attempts.
+func TestRelay_RelayOnce_failingAccount(t *testing.T) {
+	db, err := connectDatabase()
+	if err != nil {
+		t.Skip("precondition:", err)
+	}
+
+	const failing, healthy = -26_001, -26_002
+	t.Cleanup(func() {
+		_, err := db.Exec(`DELETE FROM outbox_events WHERE aggregate_id IN ($1, $2)`, failing, healthy)
+		if err != nil {
+			t.Log("post-test:", err)
+		}
+	})
+
+	cfg := &config.Config{}
+	cfg.Outbox.BatchSize = 3
+	cfg.Outbox.MaxAttempts = 2
+	cfg.Outbox.BackoffBaseSeconds = 60
+	relay := NewRelay(cfg, db, []Sink{&failingSink{aggregateID: failing}})
+	defer relay.elector.Release()
+
+	// deliver what is left over, the batches below are ours only
+	for {
+		n, err := relay.RelayOnce(context.Background())
+		if err != nil {
+			t.Fatal("precondition:", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	insert := func(aggregateID int) int64 {
+		var id int64
+		q := `INSERT INTO outbox_events (aggregate_id, event_type, payload) VALUES ($1, 'Test', '{}') RETURNING id`
+		if err := db.Get(&id, q, aggregateID); err != nil {
+			t.Fatal("precondition:", err)
+		}
+		return id
+	}
+	head := insert(failing)
+	insert(failing)
+	insert(failing)
+	healthyID := insert(healthy)
+
+	// the failing account fills the 1st batch, and only its head is
+	// retried from then on
+	for range 2 {
+		if _, err := relay.RelayOnce(context.Background()); err != nil {
+			t.Fatal("RelayOnce() failed:", err)
+		}
+	}
+
+	var delivered bool
+	err = db.Get(&delivered, `SELECT delivered_at IS NOT NULL FROM outbox_events WHERE id = $1`, healthyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delivered {
+		t.Fatal("the event of the healthy account was not delivered")
+	}
+
+	// the retry of the head is due, it dies on its 2nd failure
+	_, err = db.Exec(`UPDATE outbox_events SET next_attempt_at = CURRENT_TIMESTAMP WHERE id = $1`, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.RelayOnce(context.Background()); err != nil {
+		t.Fatal("RelayOnce() failed:", err)
+	}
+
+	var got struct {
+		Attempts int  `db:"attempts"`
+		Dead     bool `db:"dead"`
+	}
+	err = db.Get(&got, `SELECT attempts, dead_at IS NOT NULL AS dead FROM outbox_events WHERE id = $1`, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Attempts != 2 || !got.Dead {
+		t.Fatalf("head mismatch. Want 2 attempts and dead; got %+v", got)
+	}
+}
+
+func connectDatabase() (*sqlx.DB, error) {
+	dsn := utils.BuildDatasourceName(utils.DataSource{
+		User:     "postgres",
+		Password: "your_password",
+		Host:     "127.0.0.1:5432",
+		Database: "simple_account",
+	})
+
+	return sqlx.Connect("postgres", dsn)
+}
@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+)
+
+const (
+	SinkTypeStdout = "stdout"
+	SinkTypeFile   = "file"
+	SinkTypeHTTP   = "http"
+)
+
+// Sink is the destination of the outbox events. Publish must only
+// return nil when the event has been accepted by the destination,
+// otherwise the event will be retried on the next relay cycle.
+type Sink interface {
+	Publish(ctx context.Context, evt *Event) error
+}
+
+// WriterSink writes each event as a single JSON line to the writer
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Publish implements Sink.
+func (s *WriterSink) Publish(_ context.Context, evt *Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// NewFileSink opens (or creates) the file at path in append mode and
+// returns a sink writing JSON lines into it.
+func NewFileSink(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterSink(f), nil
+}
+
+// HTTPSink POSTs each event as JSON to the configured URL. Any non-2xx
+// response is treated as a failed delivery.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Publish implements Sink.
+func (s *HTTPSink) Publish(ctx context.Context, evt *Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", fmt.Sprint(evt.ID))
+	req.Header.Set("X-Event-Type", evt.EventType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("outbox: http sink responded with %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// NewSinksFromConfig builds the sinks listed in the outbox config
+func NewSinksFromConfig(cfg *config.Config) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(cfg.Outbox.Sinks))
+	for _, sc := range cfg.Outbox.Sinks {
+		switch sc.Type {
+		case SinkTypeStdout:
+			sinks = append(sinks, NewWriterSink(os.Stdout))
+
+		case SinkTypeFile:
+			s, err := NewFileSink(sc.Path)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+
+		case SinkTypeHTTP:
+			sinks = append(sinks, NewHTTPSink(sc.URL, time.Duration(sc.TimeoutSeconds)*time.Second))
+
+		default:
+			return nil, fmt.Errorf("outbox: unknown sink type %q", sc.Type)
+		}
+	}
+
+	return sinks, nil
+}
@@ -0,0 +1,89 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriterSink_Publish(t *testing.T) {
+	buf := new(bytes.Buffer)
+	sink := NewWriterSink(buf)
+
+	evt := &Event{
+		ID:          1,
+		AggregateID: 10,
+		EventType:   "BalanceCredited",
+		Payload:     json.RawMessage(`{"amount":1000}`),
+		CreatedAt:   time.Now(),
+	}
+
+	err := sink.Publish(context.Background(), evt)
+	if err != nil {
+		t.Fatal("Publish() failed:", err)
+	}
+
+	got := new(Event)
+	err = json.Unmarshal(bytes.TrimSpace(buf.Bytes()), got)
+	if err != nil {
+		t.Fatal("output is not a JSON line:", err)
+	}
+
+	if got.ID != evt.ID || got.EventType != evt.EventType || string(got.Payload) != string(evt.Payload) {
+		t.Fatalf("event mismatch. Want %+v; got %+v", evt, got)
+	}
+}
+
+func TestHTTPSink_Publish(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{
+			name:       "test_success",
+			statusCode: http.StatusOK,
+			wantErr:    false,
+		},
+		{
+			name:       "test_rejected",
+			statusCode: http.StatusServiceUnavailable,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotType string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotType = r.Header.Get("X-Event-Type")
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer srv.Close()
+
+			sink := NewHTTPSink(srv.URL, time.Second)
+			gotErr := sink.Publish(context.Background(), &Event{
+				ID:        1,
+				EventType: "BalanceDebited",
+				Payload:   json.RawMessage(`{}`),
+			})
+			if gotErr != nil {
+				if !tt.wantErr {
+					t.Errorf("Publish() failed: %v", gotErr)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("Publish() succeeded unexpectedly")
+			}
+
+			if gotType != "BalanceDebited" {
+				t.Fatal("event type header mismatch, got", gotType)
+			}
+		})
+	}
+}
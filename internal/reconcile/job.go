@@ -0,0 +1,116 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/leader"
+)
+
+// leaderLockKey is the advisory lock key of the reconciliation leader
+const leaderLockKey = 31_000_001
+
+const defaultInterval = time.Hour
+
+// Job reconciles the ledger periodically. It only reports, the correcting
+// adjustments are written by the reconcile command once approved.
+type Job struct {
+	reconciler *Reconciler
+	elector    *leader.Elector
+	interval   time.Duration
+	reportDir  string
+}
+
+func NewJob(cfg *config.Config, db *sqlx.DB, txs *account.TxPolicy) *Job {
+	interval := time.Duration(cfg.Reconcile.IntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Job{
+		reconciler: NewReconciler(db, txs),
+		elector:    leader.NewElector(db, leaderLockKey, "reconcile"),
+		interval:   interval,
+		reportDir:  cfg.Reconcile.ReportDir,
+	}
+}
+
+// Run runs the job until the context is cancelled
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	defer j.elector.Release()
+
+	for {
+		if j.elector.IsLeader(ctx) {
+			if err := j.RunOnce(ctx); err != nil {
+				slog.Error("reconciliation failed", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce reconciles the ledger once, logging and saving the report when
+// any account drifted
+func (j *Job) RunOnce(ctx context.Context) error {
+	report, err := j.reconciler.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(report.Drifts) == 0 {
+		slog.Info("ledger reconciled", "accounts", report.AccountsChecked)
+		return nil
+	}
+
+	for _, d := range report.Drifts {
+		slog.Warn("ledger drift",
+			"account_id", d.AccountID,
+			"stored_balance", d.StoredBalance,
+			"ledger_balance", d.LedgerBalance,
+			"drift", d.Drift,
+		)
+	}
+
+	if j.reportDir == "" {
+		return nil
+	}
+
+	path, err := j.saveReport(report)
+	if err != nil {
+		return err
+	}
+
+	slog.Warn("ledger drift report written", "accounts", len(report.Drifts), "path", path)
+	return nil
+}
+
+func (j *Job) saveReport(report *Report) (string, error) {
+	err := os.MkdirAll(j.reportDir, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("drift-%s.json", report.GeneratedAt.Format("20060102T150405Z"))
+	path := filepath.Join(j.reportDir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return path, report.WriteJSON(f)
+}
@@ -0,0 +1,243 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/logging"
+)
+
+var (
+	ErrDriftChanged = fmt.Errorf("reconcile: drift changed since the report")
+	ErrNoApprover   = fmt.Errorf("reconcile: adjustments must be approved by an operator")
+)
+
+// metrics of the last reconciliation, exported on /debug/vars
+var (
+	metricDriftAccounts = expvar.NewInt("reconcile_drift_accounts")
+	metricDriftTotal    = expvar.NewFloat("reconcile_drift_total")
+	metricLastRun       = expvar.NewInt("reconcile_last_run_unix")
+)
+
+// Drift is an account whose stored balance differs from its ledger, the
+// sum of its credits minus its debits
+type Drift struct {
+	AccountID     int     `db:"id" json:"account_id"`
+	Username      string  `db:"username" json:"username"`
+	StoredBalance float64 `db:"stored_balance" json:"stored_balance"`
+	LedgerBalance float64 `db:"ledger_balance" json:"ledger_balance"`
+
+	// Drift is StoredBalance - LedgerBalance
+	Drift float64 `db:"drift" json:"drift"`
+}
+
+type Report struct {
+	GeneratedAt     time.Time `json:"generated_at"`
+	AccountsChecked int       `json:"accounts_checked"`
+	Drifts          []Drift   `json:"drifts"`
+}
+
+// Reconciler compares the stored balances against the ledger
+type Reconciler struct {
+	db  *sqlx.DB
+	txs *account.TxPolicy
+}
+
+func NewReconciler(db *sqlx.DB, txs *account.TxPolicy) *Reconciler {
+	return &Reconciler{
+		db:  db,
+		txs: txs,
+	}
+}
+
+// Run builds the drift report. The balance and its transactions are
+// always committed together, so a single snapshot is consistent even
+// while money moves.
+func (r *Reconciler) Run(ctx context.Context) (*Report, error) {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &Report{
+		GeneratedAt: time.Now().UTC(),
+		Drifts:      []Drift{},
+	}
+
+	err = tx.Get(&report.AccountsChecked, `SELECT COUNT(*) FROM users`)
+	if err != nil {
+		return nil, err
+	}
+
+	// the stored balance of a hot account includes its buckets
+	q := `
+        SELECT
+            u.id, u.username,
+            s.stored_balance,
+            l.ledger_balance,
+            s.stored_balance - l.ledger_balance AS drift
+        FROM users u
+        CROSS JOIN LATERAL (
+            SELECT
+                u.balance + COALESCE((SELECT SUM(b.balance) FROM balance_buckets b WHERE b.user_id = u.id), 0) AS stored_balance
+        ) s
+        CROSS JOIN LATERAL (
+            SELECT
+                COALESCE(SUM(CASE WHEN t.type = 'credit' THEN t.amount ELSE -t.amount END), 0) AS ledger_balance
+            FROM transactions t
+            WHERE t.user_id = u.id
+        ) l
+        WHERE s.stored_balance <> l.ledger_balance
+        ORDER BY u.id
+    `
+	err = tx.Select(&report.Drifts, q)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0.0
+	for _, d := range report.Drifts {
+		total += math.Abs(d.Drift)
+	}
+
+	metricDriftAccounts.Set(int64(len(report.Drifts)))
+	metricDriftTotal.Set(total)
+	metricLastRun.Set(report.GeneratedAt.Unix())
+
+	return report, nil
+}
+
+// Adjust writes the correcting adjustment of every drift of an approved
+// report. An account is only adjusted when its drift is still exactly the
+// one in the report, drifts that changed since are returned in skipped.
+func (r *Reconciler) Adjust(ctx context.Context, report *Report, approvedBy string) (adjusted []Drift, skipped []Drift, err error) {
+	if approvedBy == "" {
+		return nil, nil, ErrNoApprover
+	}
+
+	for _, d := range report.Drifts {
+		err := r.adjust(ctx, d, approvedBy)
+		switch {
+		case err == nil:
+			adjusted = append(adjusted, d)
+		case errors.Is(err, ErrDriftChanged):
+			skipped = append(skipped, d)
+		default:
+			return adjusted, skipped, err
+		}
+	}
+
+	return adjusted, skipped, nil
+}
+
+func (r *Reconciler) adjust(ctx context.Context, d Drift, approvedBy string) error {
+	var trx *account.Transactions
+	err := r.txs.InTx(ctx, r.db, "ReconcileAdjust", func(tx *sqlx.Tx) error {
+		// lock the account so no money moves while re-checking the drift
+		var current float64
+		q := `
+            SELECT
+                u.balance
+                + COALESCE((SELECT SUM(b.balance) FROM balance_buckets b WHERE b.user_id = u.id), 0)
+                - COALESCE((
+                    SELECT SUM(CASE WHEN t.type = 'credit' THEN t.amount ELSE -t.amount END)
+                    FROM transactions t
+                    WHERE t.user_id = u.id
+                ), 0)
+            FROM users u
+            WHERE u.id = $1
+            FOR UPDATE OF u
+        `
+		err := tx.Get(&current, q, d.AccountID)
+		if err != nil {
+			return err
+		}
+
+		if math.Abs(current-d.Drift) >= 0.005 {
+			return ErrDriftChanged
+		}
+
+		trx, err = account.RecordAdjustment(tx, d.AccountID, d.Drift)
+		if err != nil {
+			return err
+		}
+
+		qAudit := `
+            INSERT INTO reconciliation_adjustments
+                (user_id, transaction_id, drift, approved_by)
+            VALUES
+                ($1, $2, $3, $4)
+        `
+		_, err = tx.Exec(qAudit, d.AccountID, trx.ID, d.Drift, approvedBy)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Audit(slog.Default().With(slog.String("operation", "ReconcileAdjust")), logging.MoneyMovement{
+		Actor:   logging.ReconcileActor(approvedBy),
+		Account: d.Username,
+		TrxType: trx.TrxType,
+		Amount:  trx.Amount,
+		TrxID:   trx.ID,
+	})
+
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON
+func (rep *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// WriteCSV writes the drifts as CSV, 1 row per account
+func (rep *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"account_id", "username", "stored_balance", "ledger_balance", "drift"})
+	if err != nil {
+		return err
+	}
+
+	for _, d := range rep.Drifts {
+		err = cw.Write([]string{
+			strconv.Itoa(d.AccountID),
+			d.Username,
+			strconv.FormatFloat(d.StoredBalance, 'f', 2, 64),
+			strconv.FormatFloat(d.LedgerBalance, 'f', 2, 64),
+			strconv.FormatFloat(d.Drift, 'f', 2, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadReport reads a JSON report, e.g. the one approved by the operator
+func ReadReport(r io.Reader) (*Report, error) {
+	rep := new(Report)
+	err := json.NewDecoder(r).Decode(rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return rep, nil
+}
@@ -0,0 +1,38 @@
+package reconcile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReportWriters(t *testing.T) {
+	report := &Report{
+		GeneratedAt:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		AccountsChecked: 3,
+		Drifts: []Drift{
+			{AccountID: 2, Username: "bob", StoredBalance: 100, LedgerBalance: 90.5, Drift: 9.5},
+		},
+	}
+
+	var csvBuf bytes.Buffer
+	if err := report.WriteCSV(&csvBuf); err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := "account_id,username,stored_balance,ledger_balance,drift\n2,bob,100.00,90.50,9.50\n"
+	if csvBuf.String() != wantCSV {
+		t.Errorf("WriteCSV() = %q, want %q", csvBuf.String(), wantCSV)
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := report.WriteJSON(&jsonBuf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadReport(&jsonBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.GeneratedAt.Equal(report.GeneratedAt) || got.AccountsChecked != 3 || len(got.Drifts) != 1 || got.Drifts[0] != report.Drifts[0] {
+		t.Errorf("ReadReport() = %+v, want %+v", got, report)
+	}
+}
@@ -0,0 +1,197 @@
+package replica
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+)
+
+const (
+	defaultReadYourWrites = 5 * time.Second
+	defaultMaxLag         = 2 * time.Second
+	defaultCheckInterval  = 5 * time.Second
+)
+
+// the replication lag of every replica host, exported on /debug/vars
+var metricLag = expvar.NewMap("db_replica_lag_seconds")
+
+type replica struct {
+	host string
+	db   *sqlx.DB
+
+	// healthy is false until the first lag check, the reads go to the
+	// primary meanwhile
+	healthy atomic.Bool
+}
+
+// Router routes the read-only queries to the replicas whose lag is below
+// the threshold, and everything else to the primary
+type Router struct {
+	primary  *sqlx.DB
+	replicas []*replica
+	next     atomic.Uint64
+
+	readYourWrites time.Duration
+	maxLag         time.Duration
+	checkInterval  time.Duration
+
+	// the last write of every key written within readYourWrites
+	mu      sync.Mutex
+	written map[string]time.Time
+}
+
+// NewRouter routes over the replicas, hosts[i] being the host of
+// replicas[i]. Run must be started for the replicas to be used.
+func NewRouter(cfg *config.Config, primary *sqlx.DB, hosts []string, replicas []*sqlx.DB) *Router {
+	r := &Router{
+		primary:        primary,
+		readYourWrites: time.Duration(cfg.DB.ReadYourWritesSeconds) * time.Second,
+		maxLag:         time.Duration(cfg.DB.MaxReplicaLagSeconds * float64(time.Second)),
+		checkInterval:  time.Duration(cfg.DB.ReplicaCheckIntervalSeconds) * time.Second,
+		written:        map[string]time.Time{},
+	}
+	if r.readYourWrites <= 0 {
+		r.readYourWrites = defaultReadYourWrites
+	}
+	if r.maxLag <= 0 {
+		r.maxLag = defaultMaxLag
+	}
+	if r.checkInterval <= 0 {
+		r.checkInterval = defaultCheckInterval
+	}
+
+	for i, db := range replicas {
+		r.replicas = append(r.replicas, &replica{host: hosts[i], db: db})
+	}
+
+	return r
+}
+
+// Reader returns the database to read the data of key from: the primary
+// when key was written recently or no replica is healthy, otherwise the
+// replicas in turn
+func (r *Router) Reader(key string) *sqlx.DB {
+	if r.writtenRecently(key) {
+		return r.primary
+	}
+
+	n := len(r.replicas)
+	start := r.next.Add(1)
+	for i := 0; i < n; i++ {
+		rep := r.replicas[(start+uint64(i))%uint64(n)]
+		if rep.healthy.Load() {
+			return rep.db
+		}
+	}
+
+	return r.primary
+}
+
+// Written records that key was written, its reads go to the primary for
+// the read-your-writes window. Without replicas every read goes to the
+// primary, nothing is recorded.
+func (r *Router) Written(keys ...string) {
+	if len(r.replicas) == 0 {
+		return
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		r.written[key] = now
+	}
+}
+
+func (r *Router) writtenRecently(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	at, ok := r.written[key]
+	return ok && time.Since(at) < r.readYourWrites
+}
+
+// Run checks the lag of the replicas until ctx is cancelled
+func (r *Router) Run(ctx context.Context) {
+	if len(r.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		r.checkLag(ctx)
+		r.forgetWrites()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lagCheck is the replication state of a replica
+type lagCheck struct {
+	// Streaming is false when the replica lost its WAL receiver, it then
+	// stops receiving without falling behind what it received
+	Streaming bool    `db:"streaming"`
+	Lag       float64 `db:"lag"`
+}
+
+// healthy reports whether the replica may serve the reads
+func (c lagCheck) healthy(maxLag time.Duration) bool {
+	return c.Streaming && time.Duration(c.Lag*float64(time.Second)) <= maxLag
+}
+
+// checkLag marks the replicas behind by more than maxLag, disconnected
+// from the primary, or unreachable, as unhealthy
+func (r *Router) checkLag(ctx context.Context) {
+	// a streaming replica that replayed everything it received is up to
+	// date, even when the primary has not written for a while
+	q := `
+        SELECT
+            NOT pg_is_in_recovery()
+            OR COALESCE((SELECT status = 'streaming' FROM pg_stat_wal_receiver), FALSE) AS streaming,
+            CASE
+                WHEN NOT pg_is_in_recovery() THEN 0
+                WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+                ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+            END AS lag
+    `
+
+	for _, rep := range r.replicas {
+		var check lagCheck
+		checkCtx, cancel := context.WithTimeout(ctx, r.checkInterval)
+		err := rep.db.GetContext(checkCtx, &check, q)
+		cancel()
+
+		healthy := err == nil && check.healthy(r.maxLag)
+		if rep.healthy.Swap(healthy) != healthy {
+			slog.Warn("replica health changed", "host", rep.host, "healthy", healthy, "streaming", check.Streaming, "lag_seconds", check.Lag, "error", err)
+		}
+
+		lagVar := new(expvar.Float)
+		lagVar.Set(check.Lag)
+		metricLag.Set(rep.host, lagVar)
+	}
+}
+
+// forgetWrites drops the writes older than the read-your-writes window
+func (r *Router) forgetWrites() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, at := range r.written {
+		if time.Since(at) >= r.readYourWrites {
+			delete(r.written, key)
+		}
+	}
+}
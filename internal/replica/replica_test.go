@@ -0,0 +1,105 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+)
+
+func openDB(t *testing.T, host string) *sqlx.DB {
+	t.Helper()
+
+	// sqlx.Open does not connect
+	db, err := sqlx.Open("postgres", "postgres://user@"+host+"/db?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestRouterReader(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.DB.ReadYourWritesSeconds = 60
+
+	primary := openDB(t, "primary")
+	replicas := []*sqlx.DB{openDB(t, "replica-1"), openDB(t, "replica-2")}
+	r := NewRouter(cfg, primary, []string{"replica-1", "replica-2"}, replicas)
+
+	// the replicas are unhealthy until their lag is checked
+	if got := r.Reader("alice"); got != primary {
+		t.Errorf("before the lag check, Reader() is not the primary")
+	}
+
+	r.replicas[0].healthy.Store(true)
+	r.replicas[1].healthy.Store(true)
+	seen := map[*sqlx.DB]int{}
+	for i := 0; i < 4; i++ {
+		seen[r.Reader("alice")]++
+	}
+	if seen[replicas[0]] != 2 || seen[replicas[1]] != 2 {
+		t.Errorf("reads are not spread over the replicas: %v", seen)
+	}
+
+	r.replicas[0].healthy.Store(false)
+	for i := 0; i < 2; i++ {
+		if got := r.Reader("alice"); got != replicas[1] {
+			t.Errorf("Reader() is not the healthy replica")
+		}
+	}
+
+	// the user reads its own write from the primary, the others keep
+	// reading from the replicas
+	r.Written("alice")
+	if got := r.Reader("alice"); got != primary {
+		t.Errorf("after a write, Reader() is not the primary")
+	}
+	if got := r.Reader("bob"); got != replicas[1] {
+		t.Errorf("Reader() of another user is not the replica")
+	}
+
+	r.written["alice"] = time.Now().Add(-time.Minute)
+	r.forgetWrites()
+	if got := r.Reader("alice"); got != replicas[1] {
+		t.Errorf("after the read-your-writes window, Reader() is not the replica")
+	}
+	if len(r.written) != 0 {
+		t.Errorf("the expired writes are kept: %v", r.written)
+	}
+}
+
+func TestRouterWithoutReplicas(t *testing.T) {
+	primary := openDB(t, "primary")
+	r := NewRouter(&config.Config{}, primary, nil, nil)
+
+	r.Written("alice", "bob")
+	if len(r.written) != 0 {
+		t.Errorf("the writes are recorded without replicas: %v", r.written)
+	}
+	if got := r.Reader("alice"); got != primary {
+		t.Errorf("Reader() is not the primary")
+	}
+}
+
+func TestLagCheckHealthy(t *testing.T) {
+	tests := []struct {
+		name  string
+		check lagCheck
+		want  bool
+	}{
+		{"streaming", lagCheck{Streaming: true, Lag: 0.5}, true},
+		{"lagging", lagCheck{Streaming: true, Lag: 3}, false},
+		{"disconnected", lagCheck{Streaming: false, Lag: 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.check.healthy(2 * time.Second); got != tt.want {
+				t.Errorf("healthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
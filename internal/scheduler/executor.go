@@ -0,0 +1,301 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/account"
+	"github.com/yeyee2901/test/internal/leader"
+	"github.com/yeyee2901/test/internal/logging"
+)
+
+// leaderLockKey is the advisory lock key of the scheduler leader
+const leaderLockKey = 28_000_001
+
+const (
+	defaultPollInterval = 10 * time.Second
+	defaultBatchSize    = 100
+)
+
+// Executor runs the due standing orders through the EWalletSystem. Only
+// the elected leader executes, so running multiple replicas is safe.
+//
+// Every attempt is claimed (the schedule is advanced and a "running" run
+// is recorded) before the money moves. A crash in the middle leaves the
+// run in the running state instead of paying twice on restart.
+type Executor struct {
+	db           *sqlx.DB
+	txs          *account.TxPolicy
+	ewallet      account.EWalletSystem
+	elector      *leader.Elector
+	pollInterval time.Duration
+	batchSize    int
+}
+
+func NewExecutor(cfg *config.Config, db *sqlx.DB, ewallet account.EWalletSystem, txs *account.TxPolicy) *Executor {
+	pollInterval := time.Duration(cfg.Scheduler.PollIntervalSeconds) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	batchSize := cfg.Scheduler.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	return &Executor{
+		db:           db,
+		txs:          txs,
+		ewallet:      ewallet,
+		elector:      leader.NewElector(db, leaderLockKey, "scheduler"),
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+	}
+}
+
+// Run executes the due standing orders until the context is cancelled
+func (e *Executor) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+	defer e.elector.Release()
+
+	for {
+		if e.elector.IsLeader(ctx) {
+			if _, err := e.RunOnce(ctx); err != nil {
+				slog.Error("scheduler cycle failed", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce executes 1 batch of due standing orders and returns how many
+// were attempted
+func (e *Executor) RunOnce(ctx context.Context) (int, error) {
+	q := selectScheduledTransfer + `
+        WHERE st.active AND st.next_run_at <= $1
+        ORDER BY st.next_run_at
+        LIMIT $2
+    `
+
+	due := []ScheduledTransfer{}
+	err := e.db.SelectContext(ctx, &due, q, utcNow(), e.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range due {
+		if err := e.execute(ctx, &due[i]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// execute runs 1 attempt of the current occurrence of the standing order
+func (e *Executor) execute(ctx context.Context, st *ScheduledTransfer) error {
+	logger := slog.Default().
+		With(slog.String("operation", "ScheduledTransfer")).
+		With(slog.Int("scheduled_transfer_id", st.ID))
+
+	sched, err := ParseSchedule(st.Schedule)
+	if err != nil {
+		logger.Error("invalid schedule, deactivating", "schedule", st.Schedule)
+		_, err = e.db.ExecContext(ctx, `UPDATE scheduled_transfers SET active = FALSE WHERE id = $1`, st.ID)
+		return err
+	}
+
+	now := utcNow()
+	nextOccurrence := nextOccurrenceAfter(sched, st.OccurrenceAt, now)
+
+	attempt := st.RetryCount + 1
+	runID, claimed, err := e.claim(ctx, st, nextOccurrence, attempt)
+	if err != nil || !claimed {
+		return err
+	}
+
+	debit, credit, execErr := e.move(st)
+
+	status := RunStatusSucceeded
+	var errMsg sql.NullString
+	if execErr != nil {
+		status = RunStatusFailed
+		errMsg = sql.NullString{String: execErr.Error(), Valid: true}
+		logger.Warn("scheduled transfer failed", "attempt", attempt, "error", execErr)
+	} else {
+		auditMove(logger, st, debit, credit)
+	}
+
+	qRun := `
+        UPDATE scheduled_transfer_runs
+        SET
+            status = $2,
+            error = $3,
+            debit_trx_id = $4,
+            credit_trx_id = $5
+        WHERE
+            id = $1
+    `
+	_, err = e.db.ExecContext(ctx, qRun, runID, status, errMsg, trxID(debit), trxID(credit))
+	if err != nil {
+		return err
+	}
+
+	// put the occurrence back for another attempt, unless the order was
+	// modified in the meantime
+	if execErr != nil && st.RetryCount < st.MaxRetries {
+		qRetry := `
+            UPDATE scheduled_transfers
+            SET
+                retry_count = $2,
+                occurrence_at = $3,
+                next_run_at = $4
+            WHERE
+                id = $1 AND occurrence_at = $5 AND retry_count = 0
+        `
+		retryAt := retryTime(now, st.RetryIntervalSeconds)
+		_, err = e.db.ExecContext(ctx, qRetry, st.ID, attempt, st.OccurrenceAt, retryAt, nextOccurrence)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// utcNow is the current time in UTC, the TIMESTAMP columns drop the time
+// zone of the times they are given
+func utcNow() time.Time {
+	return time.Now().UTC()
+}
+
+// nextOccurrenceAfter returns the occurrence following the current one.
+// Missed occurrences (e.g. during a downtime) are not caught up, only the
+// current one is executed.
+func nextOccurrenceAfter(sched Schedule, occurrenceAt, now time.Time) time.Time {
+	next := sched.Next(occurrenceAt)
+	if next.Before(now) {
+		next = sched.Next(now)
+	}
+
+	return next
+}
+
+// retryTime returns when a failed attempt is retried, in UTC
+func retryTime(now time.Time, intervalSeconds int) time.Time {
+	return now.UTC().Add(time.Duration(intervalSeconds) * time.Second)
+}
+
+// claim advances the standing order to its next occurrence and records
+// the running attempt, in 1 transaction. It reports false when the order
+// was modified since it was loaded.
+func (e *Executor) claim(ctx context.Context, st *ScheduledTransfer, nextOccurrence time.Time, attempt int) (int64, bool, error) {
+	var runID int64
+	claimed := false
+	err := e.txs.InTx(ctx, e.db, "ScheduledTransferClaim", func(tx *sqlx.Tx) error {
+		claimed = false
+
+		qAdvance := `
+            UPDATE scheduled_transfers
+            SET
+                retry_count = 0,
+                occurrence_at = $2,
+                next_run_at = $2
+            WHERE
+                id = $1 AND active AND next_run_at = $3 AND retry_count = $4
+        `
+		res, err := tx.Exec(qAdvance, st.ID, nextOccurrence, st.NextRunAt, st.RetryCount)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+
+		qRun := `
+            INSERT INTO scheduled_transfer_runs
+                (scheduled_transfer_id, occurrence_at, attempt, status)
+            VALUES
+                ($1, $2, $3, $4)
+            RETURNING
+                id
+        `
+		err = tx.Get(&runID, qRun, st.ID, st.OccurrenceAt, attempt, RunStatusRunning)
+		if err != nil {
+			return err
+		}
+
+		claimed = true
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return runID, claimed, nil
+}
+
+// move executes the money movement of the standing order
+func (e *Executor) move(st *ScheduledTransfer) (*account.Transactions, *account.Transactions, error) {
+	source, err := e.ewallet.GetUser(st.SourceUsername)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if st.Type == TypeDebit {
+		debit, err := e.ewallet.DeductBalance(source, st.Amount)
+		return debit, nil, err
+	}
+
+	target, err := e.ewallet.GetUser(st.TargetUsername.String)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e.ewallet.Transfer(source, target, st.Amount)
+}
+
+func trxID(trx *account.Transactions) sql.NullInt64 {
+	if trx == nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: int64(trx.ID), Valid: true}
+}
+
+// auditMove logs the money movements of a successful attempt
+func auditMove(logger *slog.Logger, st *ScheduledTransfer, debit, credit *account.Transactions) {
+	target := st.TargetUsername.String
+
+	logging.Audit(logger, logging.MoneyMovement{
+		Actor:          logging.ActorScheduler,
+		Account:        st.SourceUsername,
+		CounterAccount: target,
+		TrxType:        account.TrxTypeDebit,
+		Amount:         debit.Amount,
+		Fee:            debit.Fee,
+		TrxID:          debit.ID,
+	})
+	if credit != nil {
+		logging.Audit(logger, logging.MoneyMovement{
+			Actor:          logging.ActorScheduler,
+			Account:        target,
+			CounterAccount: st.SourceUsername,
+			TrxType:        account.TrxTypeCredit,
+			Amount:         credit.Amount,
+			TrxID:          credit.ID,
+		})
+	}
+}
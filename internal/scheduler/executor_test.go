@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// inZone runs the test with the host in another time zone than UTC
+func inZone(t *testing.T, offsetHours int) {
+	t.Helper()
+
+	local := time.Local
+	time.Local = time.FixedZone("host", offsetHours*3600)
+	t.Cleanup(func() { time.Local = local })
+}
+
+func TestExecutorTimesInUTC(t *testing.T) {
+	inZone(t, 7)
+
+	now := utcNow()
+	if now.Location() != time.UTC {
+		t.Fatalf("utcNow() is in %s, want UTC", now.Location())
+	}
+
+	// a retry in 10 minutes is stored as the UTC wall clock
+	local := time.Date(2024, 3, 1, 16, 0, 0, 0, time.Local)
+	retryAt := retryTime(local, 600)
+	want := time.Date(2024, 3, 1, 9, 10, 0, 0, time.UTC)
+	if retryAt.Location() != time.UTC || retryAt.Hour() != want.Hour() || !retryAt.Equal(want) {
+		t.Errorf("retryTime() = %v, want %v", retryAt, want)
+	}
+
+	// the daily 09:30 occurrence after 09:00 UTC (16:00 on the host)
+	sched, err := ParseSchedule("@daily 09:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrenceAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC).AddDate(0, 0, -1)
+	next := nextOccurrenceAfter(sched, occurrenceAt, local)
+	want = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	if next.Location() != time.UTC || !next.Equal(want) {
+		t.Errorf("nextOccurrenceAfter() = %v, want %v", next, want)
+	}
+}
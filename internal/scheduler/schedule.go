@@ -0,0 +1,291 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = fmt.Errorf("scheduler: invalid schedule")
+
+// Schedule computes the occurrences of a recurring instruction. Every
+// occurrence is computed in UTC.
+type Schedule interface {
+	// Next returns the first occurrence strictly after t
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses either a calendar rule or a standard 5-field cron
+// expression (minute hour day-of-month month day-of-week).
+//
+// The calendar rules are:
+//   - "@daily [HH:MM]"
+//   - "@weekly <MON..SUN> [HH:MM]"
+//   - "@monthly <1..31> [HH:MM]", the day is clamped to the last day of
+//     shorter months, so "@monthly 31" runs on the 30th in April
+func ParseSchedule(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, ErrInvalidSchedule
+	}
+
+	if strings.HasPrefix(fields[0], "@") {
+		return parseCalendar(fields)
+	}
+
+	return parseCron(fields)
+}
+
+// calendarSchedule is the human friendly "every 25th at 09:00" rule
+type calendarSchedule struct {
+	period  string
+	day     int // only for @monthly
+	hour    int
+	minute  int
+	weekday time.Weekday // only for @weekly
+}
+
+var weekdays = map[string]time.Weekday{
+	"SUN": time.Sunday,
+	"MON": time.Monday,
+	"TUE": time.Tuesday,
+	"WED": time.Wednesday,
+	"THU": time.Thursday,
+	"FRI": time.Friday,
+	"SAT": time.Saturday,
+}
+
+func parseCalendar(fields []string) (Schedule, error) {
+	cs := &calendarSchedule{period: fields[0]}
+	args := fields[1:]
+
+	switch cs.period {
+	case "@daily":
+
+	case "@weekly":
+		if len(args) == 0 {
+			return nil, ErrInvalidSchedule
+		}
+		wd, ok := weekdays[strings.ToUpper(args[0])]
+		if !ok {
+			return nil, ErrInvalidSchedule
+		}
+		cs.weekday = wd
+		args = args[1:]
+
+	case "@monthly":
+		if len(args) == 0 {
+			return nil, ErrInvalidSchedule
+		}
+		day, err := strconv.Atoi(args[0])
+		if err != nil || day < 1 || day > 31 {
+			return nil, ErrInvalidSchedule
+		}
+		cs.day = day
+		args = args[1:]
+
+	default:
+		return nil, ErrInvalidSchedule
+	}
+
+	switch len(args) {
+	case 0:
+	case 1:
+		t, err := time.Parse("15:04", args[0])
+		if err != nil {
+			return nil, ErrInvalidSchedule
+		}
+		cs.hour, cs.minute = t.Hour(), t.Minute()
+	default:
+		return nil, ErrInvalidSchedule
+	}
+
+	return cs, nil
+}
+
+// Next implements Schedule.
+func (cs *calendarSchedule) Next(t time.Time) time.Time {
+	t = t.UTC()
+
+	switch cs.period {
+	case "@weekly":
+		next := time.Date(t.Year(), t.Month(), t.Day(), cs.hour, cs.minute, 0, 0, time.UTC)
+		next = next.AddDate(0, 0, (int(cs.weekday)-int(next.Weekday())+7)%7)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+
+	case "@monthly":
+		for i := 0; ; i++ {
+			// day 1 first, so AddDate never overflows into the next month
+			month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, i, 0)
+			day := min(cs.day, daysIn(month))
+			next := time.Date(month.Year(), month.Month(), day, cs.hour, cs.minute, 0, 0, time.UTC)
+			if next.After(t) {
+				return next
+			}
+		}
+
+	default:
+		next := time.Date(t.Year(), t.Month(), t.Day(), cs.hour, cs.minute, 0, 0, time.UTC)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	}
+}
+
+func daysIn(month time.Time) int {
+	return time.Date(month.Year(), month.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// cronSchedule is a standard 5-field cron expression. Each field is a
+// bitset of the allowed values.
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// like in cron, when both the day-of-month and the day-of-week are
+	// restricted, the day matches when either of them matches
+	domRestricted bool
+	dowRestricted bool
+}
+
+func parseCron(fields []string) (Schedule, error) {
+	if len(fields) != 5 {
+		return nil, ErrInvalidSchedule
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	// 7 is an alias of sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	cs := &cronSchedule{
+		minute:        sets[0],
+		hour:          sets[1],
+		dom:           sets[2],
+		month:         sets[3],
+		dow:           sets[4],
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+	}
+
+	// reject dates that never happen, e.g. "0 0 31 2 *"
+	if cs.Next(time.Now()).IsZero() {
+		return nil, ErrInvalidSchedule
+	}
+
+	return cs, nil
+}
+
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, ErrInvalidSchedule
+			}
+		}
+
+		from, to := lo, hi
+		switch {
+		case rng == "*":
+
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			from, err1 = strconv.Atoi(a)
+			to, err2 = strconv.Atoi(b)
+			if err1 != nil || err2 != nil {
+				return 0, ErrInvalidSchedule
+			}
+
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, ErrInvalidSchedule
+			}
+			from, to = v, v
+			if hasStep {
+				to = hi
+			}
+		}
+
+		if from < lo || to > hi || from > to {
+			return 0, ErrInvalidSchedule
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+// Next implements Schedule.
+func (cs *cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	// a valid expression always matches within a few years (feb 29th
+	// being the worst case), give up afterwards for impossible dates
+	// like "0 0 31 2 *"
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !cs.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (cs *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := cs.dom&(1<<uint(t.Day())) != 0
+	dowMatch := cs.dow&(1<<uint(t.Weekday())) != 0
+
+	if cs.domRestricted && cs.dowRestricted {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
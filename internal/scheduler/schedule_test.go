@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_Next(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC) // a wednesday
+
+	tests := []struct {
+		name    string
+		spec    string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name: "test_cron_every_25th",
+			spec: "0 9 25 * *",
+			want: time.Date(2024, time.February, 25, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "test_cron_step",
+			spec: "*/15 * * * *",
+			want: time.Date(2024, time.January, 31, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "test_cron_weekdays_range",
+			spec: "0 8 * * 1-5",
+			want: time.Date(2024, time.February, 1, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "test_cron_dom_or_dow",
+			spec:    "0 0 15 * SUN",
+			wantErr: true,
+		},
+		{
+			name: "test_cron_dom_or_dow_numeric",
+			spec: "0 0 15 * 0",
+			want: time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "test_cron_impossible_date",
+			spec:    "0 0 31 2 *",
+			wantErr: true,
+		},
+		{
+			name:    "test_cron_out_of_range",
+			spec:    "60 * * * *",
+			wantErr: true,
+		},
+		{
+			name: "test_daily",
+			spec: "@daily 10:30",
+			want: time.Date(2024, time.February, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "test_weekly",
+			spec: "@weekly mon 07:00",
+			want: time.Date(2024, time.February, 5, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "test_monthly_clamped",
+			spec: "@monthly 31",
+			want: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "test_monthly_25th",
+			spec: "@monthly 25 09:00",
+			want: time.Date(2024, time.February, 25, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "test_unknown_period",
+			spec:    "@yearly",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, gotErr := ParseSchedule(tt.spec)
+			if gotErr != nil {
+				if !tt.wantErr {
+					t.Errorf("ParseSchedule() failed: %v", gotErr)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("ParseSchedule() succeeded unexpectedly")
+			}
+
+			if got := sched.Next(from); !got.Equal(tt.want) {
+				t.Fatalf("Next() mismatch. Want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/internal/account"
+)
+
+var (
+	ErrNotFound    = fmt.Errorf("scheduler: data not found")
+	ErrInvalidType = fmt.Errorf("scheduler: invalid instruction type")
+)
+
+const (
+	TypeTransfer = "transfer"
+	TypeDebit    = "debit"
+)
+
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+)
+
+// ScheduledTransfer is a standing order executed on every occurrence of
+// its schedule. A debit instruction has no target.
+type ScheduledTransfer struct {
+	ID                   int            `db:"id"`
+	SourceUserID         int            `db:"source_user_id"`
+	SourceUsername       string         `db:"source_username"`
+	TargetUserID         sql.NullInt64  `db:"target_user_id"`
+	TargetUsername       sql.NullString `db:"target_username"`
+	Type                 string         `db:"type"`
+	Amount               float64        `db:"amount"`
+	Schedule             string         `db:"schedule"`
+	Active               bool           `db:"active"`
+	MaxRetries           int            `db:"max_retries"`
+	RetryIntervalSeconds int            `db:"retry_interval_seconds"`
+	RetryCount           int            `db:"retry_count"`
+	OccurrenceAt         time.Time      `db:"occurrence_at"`
+	NextRunAt            time.Time      `db:"next_run_at"`
+	CreatedAt            time.Time      `db:"created_at"`
+}
+
+// Run is the record of 1 execution attempt of an occurrence
+type Run struct {
+	ID                  int64          `db:"id"`
+	ScheduledTransferID int            `db:"scheduled_transfer_id"`
+	OccurrenceAt        time.Time      `db:"occurrence_at"`
+	Attempt             int            `db:"attempt"`
+	Status              string         `db:"status"`
+	Error               sql.NullString `db:"error"`
+	DebitTrxID          sql.NullInt64  `db:"debit_trx_id"`
+	CreditTrxID         sql.NullInt64  `db:"credit_trx_id"`
+	CreatedAt           time.Time      `db:"created_at"`
+}
+
+// NewScheduledTransfer is the input for creating a standing order
+type NewScheduledTransfer struct {
+	SourceUsername       string
+	TargetUsername       string
+	Type                 string
+	Amount               float64
+	Schedule             string
+	MaxRetries           int
+	RetryIntervalSeconds int
+}
+
+// ScheduledTransferUpdate holds the fields to be updated, nil fields are
+// left untouched
+type ScheduledTransferUpdate struct {
+	Amount               *float64
+	Schedule             *string
+	Active               *bool
+	MaxRetries           *int
+	RetryIntervalSeconds *int
+}
+
+// Manager is the interface responsible for managing the standing orders
+type Manager interface {
+	// Create registers a new standing order, the first run is the next
+	// occurrence of the schedule from now
+	Create(in NewScheduledTransfer) (*ScheduledTransfer, error)
+
+	// List lists the standing orders of the source user
+	List(sourceUsername string) ([]ScheduledTransfer, error)
+
+	// Get gets the standing order with this ID
+	Get(id int) (*ScheduledTransfer, error)
+
+	// Update updates the standing order. Changing the schedule or
+	// re-activating the order re-computes the next run from now.
+	Update(id int, in ScheduledTransferUpdate) (*ScheduledTransfer, error)
+
+	// Delete removes the standing order along with its runs
+	Delete(id int) error
+
+	// ListRuns lists the latest runs of the standing order
+	ListRuns(id int, limit int) ([]Run, error)
+}
+
+type pgManager struct {
+	db      *sqlx.DB
+	ewallet account.EWalletSystem
+}
+
+func NewManager(db *sqlx.DB, ewallet account.EWalletSystem) Manager {
+	return &pgManager{
+		db:      db,
+		ewallet: ewallet,
+	}
+}
+
+const selectScheduledTransfer = `
+        SELECT
+            st.id, st.source_user_id, su.username AS source_username,
+            st.target_user_id, tu.username AS target_username,
+            st.type, st.amount, st.schedule, st.active,
+            st.max_retries, st.retry_interval_seconds, st.retry_count,
+            st.occurrence_at, st.next_run_at, st.created_at
+        FROM scheduled_transfers st
+        JOIN users su ON su.id = st.source_user_id
+        LEFT JOIN users tu ON tu.id = st.target_user_id
+    `
+
+// Create implements Manager.
+func (m *pgManager) Create(in NewScheduledTransfer) (*ScheduledTransfer, error) {
+	sched, err := ParseSchedule(in.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	err = account.ValidateAmount(in.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := m.ewallet.GetUser(in.SourceUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	var targetID sql.NullInt64
+	switch in.Type {
+	case TypeTransfer:
+		target, err := m.ewallet.GetUser(in.TargetUsername)
+		if err != nil {
+			return nil, err
+		}
+		if target.ID == source.ID {
+			return nil, account.ErrSameAccount
+		}
+		targetID = sql.NullInt64{Int64: int64(target.ID), Valid: true}
+
+	case TypeDebit:
+
+	default:
+		return nil, ErrInvalidType
+	}
+
+	next := sched.Next(utcNow())
+
+	q := `
+        INSERT INTO scheduled_transfers
+        (
+            source_user_id, target_user_id, type, amount, schedule,
+            max_retries, retry_interval_seconds, occurrence_at, next_run_at
+        )
+        VALUES
+            ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+        RETURNING
+            id
+    `
+
+	var id int
+	err = m.db.Get(&id, q,
+		source.ID, targetID, in.Type, in.Amount, in.Schedule,
+		in.MaxRetries, in.RetryIntervalSeconds, next,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Get(id)
+}
+
+// List implements Manager.
+func (m *pgManager) List(sourceUsername string) ([]ScheduledTransfer, error) {
+	q := selectScheduledTransfer + `
+        WHERE su.username = $1
+        ORDER BY st.id
+    `
+
+	list := []ScheduledTransfer{}
+	err := m.db.Select(&list, q, sourceUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Get implements Manager.
+func (m *pgManager) Get(id int) (*ScheduledTransfer, error) {
+	q := selectScheduledTransfer + `
+        WHERE st.id = $1
+    `
+
+	st := new(ScheduledTransfer)
+	err := m.db.Get(st, q, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(ErrNotFound, err)
+		}
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Update implements Manager.
+func (m *pgManager) Update(id int, in ScheduledTransferUpdate) (*ScheduledTransfer, error) {
+	st, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	reschedule := false
+	if in.Amount != nil {
+		err = account.ValidateAmount(*in.Amount)
+		if err != nil {
+			return nil, err
+		}
+		st.Amount = *in.Amount
+	}
+	if in.Schedule != nil && *in.Schedule != st.Schedule {
+		st.Schedule = *in.Schedule
+		reschedule = true
+	}
+	if in.Active != nil {
+		reschedule = reschedule || (*in.Active && !st.Active)
+		st.Active = *in.Active
+	}
+	if in.MaxRetries != nil {
+		st.MaxRetries = *in.MaxRetries
+	}
+	if in.RetryIntervalSeconds != nil {
+		st.RetryIntervalSeconds = *in.RetryIntervalSeconds
+	}
+
+	if reschedule {
+		sched, err := ParseSchedule(st.Schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		st.OccurrenceAt = sched.Next(utcNow())
+		st.NextRunAt = st.OccurrenceAt
+		st.RetryCount = 0
+	}
+
+	q := `
+        UPDATE scheduled_transfers
+        SET
+            amount = :amount,
+            schedule = :schedule,
+            active = :active,
+            max_retries = :max_retries,
+            retry_interval_seconds = :retry_interval_seconds,
+            retry_count = :retry_count,
+            occurrence_at = :occurrence_at,
+            next_run_at = :next_run_at
+        WHERE
+            id = :id
+    `
+	_, err = m.db.NamedExec(q, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Get(id)
+}
+
+// Delete implements Manager.
+func (m *pgManager) Delete(id int) error {
+	res, err := m.db.Exec(`DELETE FROM scheduled_transfers WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListRuns implements Manager.
+func (m *pgManager) ListRuns(id int, limit int) ([]Run, error) {
+	q := `
+        SELECT
+            id, scheduled_transfer_id, occurrence_at, attempt, status,
+            error, debit_trx_id, credit_trx_id, created_at
+        FROM scheduled_transfer_runs
+        WHERE scheduled_transfer_id = $1
+        ORDER BY id DESC
+        LIMIT $2
+    `
+
+	runs := []Run{}
+	err := m.db.Select(&runs, q, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,86 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/config"
+	"github.com/yeyee2901/test/internal/leader"
+)
+
+// leaderLockKey is the advisory lock key of the snapshot job leader
+const leaderLockKey = 36_000_001
+
+const (
+	pollInterval         = time.Hour
+	defaultMaxDaysPerRun = 31
+)
+
+// Job snapshots the days that are over. The missed days are caught up, at
+// most maxDaysPerRun of them per run, starting with the day of the first
+// transaction on a fresh database.
+type Job struct {
+	snapshotter   *Snapshotter
+	elector       *leader.Elector
+	maxDaysPerRun int
+}
+
+func NewJob(cfg *config.Config, db *sqlx.DB) *Job {
+	maxDays := cfg.Snapshots.MaxDaysPerRun
+	if maxDays <= 0 {
+		maxDays = defaultMaxDaysPerRun
+	}
+
+	return &Job{
+		snapshotter:   NewSnapshotter(db),
+		elector:       leader.NewElector(db, leaderLockKey, "balance-snapshot"),
+		maxDaysPerRun: maxDays,
+	}
+}
+
+// Run runs the job until the context is cancelled
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	defer j.elector.Release()
+
+	for {
+		if j.elector.IsLeader(ctx) {
+			j.RunOnce(ctx, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce snapshots the pending days in order, stopping at the first
+// failure so no day is skipped
+func (j *Job) RunOnce(ctx context.Context, now time.Time) {
+	next, ok, err := j.snapshotter.NextDay(ctx)
+	if err != nil {
+		slog.Error("balance snapshot failed", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	for _, day := range pendingDays(next, now, j.maxDaysPerRun) {
+		n, err := j.snapshotter.SnapshotDay(ctx, day)
+		switch {
+		case err == nil:
+			slog.Info("balances snapshotted", "date", day.Format(time.DateOnly), "accounts", n)
+		case errors.Is(err, ErrAlreadySnapshotted):
+		default:
+			slog.Error("balance snapshot failed", "date", day.Format(time.DateOnly), "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// settleDelay is how long after its end a day is snapshotted, so the
+// transactions still committing at midnight are part of it
+const settleDelay = 5 * time.Minute
+
+var (
+	ErrAlreadySnapshotted = fmt.Errorf("snapshot: day already snapshotted")
+	ErrDayNotOver         = fmt.Errorf("snapshot: day is not over yet")
+)
+
+// Snapshotter materializes the balance of every account at the end of the
+// day, so the past balances are summed from the latest snapshot instead
+// of from the first transaction
+type Snapshotter struct {
+	db *sqlx.DB
+}
+
+func NewSnapshotter(db *sqlx.DB) *Snapshotter {
+	return &Snapshotter{db: db}
+}
+
+// SnapshotDay snapshots the balance of every account existing at the end
+// of the day, from its previous snapshot and the transactions since. It
+// returns the number of accounts snapshotted.
+func (s *Snapshotter) SnapshotDay(ctx context.Context, date time.Time) (int, error) {
+	day := truncateDay(date)
+	dayEnd := day.AddDate(0, 0, 1)
+	if dayEnd.Add(settleDelay).After(time.Now()) {
+		return 0, ErrDayNotOver
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// claim the day first, a concurrent run of the same day waits on the
+	// primary key and then reports ErrAlreadySnapshotted
+	res, err := tx.Exec(`
+        INSERT INTO balance_snapshot_runs
+            (snapshot_date, accounts)
+        VALUES
+            ($1, 0)
+        ON CONFLICT (snapshot_date) DO NOTHING
+    `, day)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrAlreadySnapshotted
+	}
+
+	// the timestamps are stored in UTC, the end of the day is converted
+	// explicitly
+	q := `
+        INSERT INTO balance_snapshots
+            (user_id, snapshot_date, balance)
+        SELECT
+            u.id, $1, COALESCE(p.balance, 0) + COALESCE(d.delta, 0)
+        FROM users u
+        LEFT JOIN LATERAL (
+            SELECT
+                s.balance, (s.snapshot_date + 1)::TIMESTAMP AS day_end
+            FROM balance_snapshots s
+            WHERE s.user_id = u.id AND s.snapshot_date < $1
+            ORDER BY s.snapshot_date DESC
+            LIMIT 1
+        ) p ON true
+        CROSS JOIN LATERAL (
+            SELECT
+                SUM(CASE WHEN t.type = 'credit' THEN t.amount ELSE -t.amount END) AS delta
+            FROM transactions t
+            WHERE
+                t.user_id = u.id
+                AND t.created_at >= COALESCE(p.day_end, '-infinity'::TIMESTAMP)
+                AND t.created_at < ($2::TIMESTAMPTZ AT TIME ZONE 'UTC')
+        ) d
+        WHERE u.created_at < ($2::TIMESTAMPTZ AT TIME ZONE 'UTC')
+        ON CONFLICT (user_id, snapshot_date) DO NOTHING
+    `
+	res, err = tx.Exec(q, day, dayEnd)
+	if err != nil {
+		return 0, err
+	}
+	n, err = res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE balance_snapshot_runs SET accounts = $2 WHERE snapshot_date = $1`, day, n)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// NextDay returns the day after the latest snapshotted day, or the day of
+// the first transaction when none was snapshotted yet. ok is false when
+// there is nothing to snapshot.
+func (s *Snapshotter) NextDay(ctx context.Context) (day time.Time, ok bool, err error) {
+	var latest sql.NullTime
+	err = s.db.GetContext(ctx, &latest, `SELECT MAX(snapshot_date) FROM balance_snapshot_runs`)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if latest.Valid {
+		return truncateDay(latest.Time).AddDate(0, 0, 1), true, nil
+	}
+
+	var first sql.NullTime
+	err = s.db.GetContext(ctx, &first, `SELECT MIN(created_at) FROM transactions`)
+	if err != nil || !first.Valid {
+		return time.Time{}, false, err
+	}
+
+	return truncateDay(first.Time), true, nil
+}
+
+// pendingDays returns the days from next on that are over at now, at most
+// limit of them
+func pendingDays(next, now time.Time, limit int) []time.Time {
+	days := []time.Time{}
+	for day := truncateDay(next); len(days) < limit; day = day.AddDate(0, 0, 1) {
+		if day.AddDate(0, 0, 1).Add(settleDelay).After(now) {
+			break
+		}
+		days = append(days, day)
+	}
+
+	return days
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
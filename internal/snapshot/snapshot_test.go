@@ -0,0 +1,67 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingDays(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.March, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name  string
+		next  time.Time
+		now   time.Time
+		limit int
+		want  []time.Time
+	}{
+		{
+			name:  "test_yesterday",
+			next:  day(9),
+			now:   day(10).Add(time.Hour),
+			limit: 31,
+			want:  []time.Time{day(9)},
+		},
+		{
+			name:  "test_not_settled",
+			next:  day(9),
+			now:   day(10).Add(time.Minute),
+			limit: 31,
+			want:  []time.Time{},
+		},
+		{
+			name:  "test_catch_up",
+			next:  day(5),
+			now:   day(10).Add(time.Hour),
+			limit: 31,
+			want:  []time.Time{day(5), day(6), day(7), day(8), day(9)},
+		},
+		{
+			name:  "test_limit",
+			next:  day(1),
+			now:   day(10).Add(time.Hour),
+			limit: 2,
+			want:  []time.Time{day(1), day(2)},
+		},
+		{
+			name:  "test_up_to_date",
+			next:  day(10),
+			now:   day(10).Add(time.Hour),
+			limit: 31,
+			want:  []time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pendingDays(tt.next, tt.now, tt.limit)
+			if len(got) != len(tt.want) {
+				t.Fatalf("pendingDays() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("pendingDays()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+)
+
+const defaultReloadInterval = 30 * time.Second
+
+var ErrUnknownClient = errors.New("tlsconfig: client certificate subject has no identity")
+
+// New builds the server TLS config. The certificate and the client CA
+// bundle are loaded now, so a broken setup fails at startup.
+func New(cfg config.TLSConfig) (*tls.Config, error) {
+	minVersion, err := parseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	suites, err := parseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(cfg.ReloadIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+
+	r := &reloader{
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+		caFile:   cfg.ClientCAFile,
+		interval: interval,
+	}
+	err = r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+	}
+	if cfg.ClientCAFile != "" {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.RequireClientCert {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	// every handshake gets the current certificate & CA bundle
+	tlsCfg := base.Clone()
+	tlsCfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := r.current()
+
+		c := base.Clone()
+		c.Certificates = []tls.Certificate{*cert}
+		c.ClientCAs = pool
+		return c, nil
+	}
+
+	return tlsCfg, nil
+}
+
+// Identity returns the API identity of the verified client certificate of
+// the connection, "" when the caller has none
+func Identity(state *tls.ConnectionState, identities map[string]string) (string, error) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", nil
+	}
+
+	cn := state.VerifiedChains[0][0].Subject.CommonName
+	if len(identities) == 0 {
+		return cn, nil
+	}
+
+	identity, ok := identities[cn]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownClient, cn)
+	}
+
+	return identity, nil
+}
+
+// reloader keeps the certificate and the CA bundle in line with their
+// files. The files are checked on the handshakes, at most every interval.
+type reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (r *reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= r.interval {
+		r.checkedAt = time.Now()
+		if r.latestModTime().After(r.modTime) {
+			// keep serving the previous certificate on a broken renewal,
+			// e.g. the key is written after the certificate
+			err := r.loadLocked()
+			if err != nil {
+				slog.Error("cannot reload the TLS certificates", "error", err)
+			} else {
+				slog.Info("TLS certificates reloaded", "cert_file", r.certFile)
+			}
+		}
+	}
+
+	return r.cert, r.pool
+}
+
+func (r *reloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checkedAt = time.Now()
+	return r.loadLocked()
+}
+
+func (r *reloader) loadLocked() error {
+	modTime := r.latestModTime()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tlsconfig: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("tlsconfig: %w", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tlsconfig: no certificate in %s", r.caFile)
+		}
+	}
+
+	r.cert, r.pool, r.modTime = &cert, pool, modTime
+	return nil
+}
+
+// latestModTime is the modification time of the most recent file
+func (r *reloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
+
+func parseVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("tlsconfig: unsupported min version %q", v)
+	}
+}
+
+// parseCipherSuites only accepts the secure suites of crypto/tls
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("tlsconfig: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
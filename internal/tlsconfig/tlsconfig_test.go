@@ -0,0 +1,252 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yeyee2901/test/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate & key of cn, signed by the CA
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	t.Helper()
+
+	err := os.WriteFile(path, content, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects to a TLS listener of serverCfg, returning the server
+// certificate CN seen by the client and the client identity seen by the
+// server
+func handshake(t *testing.T, serverCfg *tls.Config, clientCfg *tls.Config, identities map[string]string) (string, string, error) {
+	t.Helper()
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	type result struct {
+		identity string
+		err      error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer conn.Close()
+
+		tlsConn := conn.(*tls.Conn)
+		err = tlsConn.Handshake()
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		state := tlsConn.ConnectionState()
+		identity, err := Identity(&state, identities)
+		results <- result{identity: identity, err: err}
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), clientCfg)
+	if err != nil {
+		<-results
+		return "", "", err
+	}
+	defer conn.Close()
+
+	res := <-results
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, res.identity, res.err
+}
+
+func TestNewMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	now := time.Now()
+
+	certPEM, keyPEM := ca.issue(t, "server v1", x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "server.crt"), certPEM, now)
+	writeFile(t, filepath.Join(dir, "server.key"), keyPEM, now)
+	writeFile(t, filepath.Join(dir, "ca.crt"), ca.pem, now)
+
+	identities := map[string]string{"payments-svc": "payments"}
+	serverCfg, err := New(config.TLSConfig{
+		CertFile:          filepath.Join(dir, "server.crt"),
+		KeyFile:           filepath.Join(dir, "server.key"),
+		ClientCAFile:      filepath.Join(dir, "ca.crt"),
+		RequireClientCert: true,
+		MinVersion:        "1.3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCfg := func(cn string) *tls.Config {
+		certPEM, keyPEM := ca.issue(t, cn, x509.ExtKeyUsageClientAuth)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13}
+	}
+
+	serverCN, identity, err := handshake(t, serverCfg, clientCfg("payments-svc"), identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverCN != "server v1" || identity != "payments" {
+		t.Errorf("server %q, identity %q", serverCN, identity)
+	}
+
+	_, _, err = handshake(t, serverCfg, clientCfg("unknown-svc"), identities)
+	if !errors.Is(err, ErrUnknownClient) {
+		t.Errorf("unknown subject: err = %v, want ErrUnknownClient", err)
+	}
+}
+
+func TestReloaderPicksUpRenewedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	past := time.Now().Add(-time.Minute)
+
+	r := &reloader{
+		certFile: filepath.Join(dir, "server.crt"),
+		keyFile:  filepath.Join(dir, "server.key"),
+		interval: time.Millisecond,
+	}
+	certPEM, keyPEM := ca.issue(t, "v1", x509.ExtKeyUsageServerAuth)
+	writeFile(t, r.certFile, certPEM, past)
+	writeFile(t, r.keyFile, keyPEM, past)
+	err := r.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commonName := func() string {
+		time.Sleep(2 * time.Millisecond)
+		cert, _ := r.current()
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	// a half written renewal keeps the previous certificate
+	certPEM, keyPEM = ca.issue(t, "v2", x509.ExtKeyUsageServerAuth)
+	writeFile(t, r.certFile, certPEM, time.Now())
+	if got := commonName(); got != "v1" {
+		t.Errorf("after a partial renewal, CN = %q, want v1", got)
+	}
+
+	writeFile(t, r.keyFile, keyPEM, time.Now().Add(time.Second))
+	if got := commonName(); got != "v2" {
+		t.Errorf("after the renewal, CN = %q, want v2", got)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.TLSConfig
+	}{
+		{"min version", config.TLSConfig{MinVersion: "1.0"}},
+		{"insecure cipher suite", config.TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}},
+		{"missing certificate", config.TLSConfig{CertFile: "/nonexistent.crt", KeyFile: "/nonexistent.key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if err == nil {
+				t.Errorf("New() succeeded unexpectedly")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/yeyee2901/test/internal/outbox"
+)
+
+// Dispatcher is an outbox.Sink that fans the events out into 1 pending
+// delivery per interested subscription. The actual HTTP calls are made
+// by the Worker, so a slow merchant never holds back the outbox relay.
+type Dispatcher struct {
+	db *sqlx.DB
+}
+
+func NewDispatcher(db *sqlx.DB) *Dispatcher {
+	return &Dispatcher{
+		db: db,
+	}
+}
+
+// Publish implements outbox.Sink.
+func (d *Dispatcher) Publish(ctx context.Context, evt *outbox.Event) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	qSubs := `
+        SELECT
+            id, url, secret, event_types, active, created_at
+        FROM webhook_subscriptions
+        WHERE active
+    `
+	subs := []Subscription{}
+	err = d.db.SelectContext(ctx, &subs, qSubs)
+	if err != nil {
+		return err
+	}
+
+	// the outbox is at-least-once, so the same event may be published
+	// more than once. The unique key makes the fan-out idempotent.
+	qDelivery := `
+        INSERT INTO webhook_deliveries
+            (subscription_id, event_id, event_type, body)
+        VALUES
+            ($1, $2, $3, $4)
+        ON CONFLICT (subscription_id, event_id) DO NOTHING
+    `
+
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for i := range subs {
+		if !subs[i].Wants(evt.EventType) {
+			continue
+		}
+
+		_, err = tx.Exec(qDelivery, subs[i].ID, evt.ID, evt.EventType, body)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headers attached to every webhook request
+const (
+	HeaderDeliveryID = "X-Webhook-Id"
+	HeaderEventType  = "X-Webhook-Event"
+	HeaderTimestamp  = "X-Webhook-Timestamp"
+	HeaderSignature  = "X-Webhook-Signature"
+)
+
+const signatureVersion = "v1"
+
+var (
+	ErrInvalidSignature = fmt.Errorf("webhook: invalid signature")
+	ErrExpiredTimestamp = fmt.Errorf("webhook: timestamp outside of tolerance")
+)
+
+// Sign computes the signature header value for the body sent at the
+// given unix timestamp. The signed content is "<timestamp>.<body>", so a
+// captured request cannot be replayed with another timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a received
+// webhook. This is what the receivers are expected to do, it is
+// exported so that it can be shared with integration tests & SDKs.
+func Verify(secret, timestampHeader, signatureHeader string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age < 0 {
+		age = -age
+	}
+	if tolerance > 0 && age > tolerance {
+		return ErrExpiredTimestamp
+	}
+
+	want := Sign(secret, ts, body)
+	for _, sig := range strings.Split(signatureHeader, ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(sig)), []byte(want)) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"id":1,"event_type":"BalanceCredited"}`)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		signature string
+		body      []byte
+		wantErr   error
+	}{
+		{
+			name:      "test_success",
+			secret:    secret,
+			timestamp: now,
+			signature: Sign(secret, now, body),
+			body:      body,
+			wantErr:   nil,
+		},
+		{
+			name:      "test_tampered_body",
+			secret:    secret,
+			timestamp: now,
+			signature: Sign(secret, now, body),
+			body:      []byte(`{"id":1,"event_type":"BalanceDebited"}`),
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "test_wrong_secret",
+			secret:    "whsec_other",
+			timestamp: now,
+			signature: Sign(secret, now, body),
+			body:      body,
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "test_expired",
+			secret:    secret,
+			timestamp: now - 3600,
+			signature: Sign(secret, now-3600, body),
+			body:      body,
+			wantErr:   ErrExpiredTimestamp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotErr := Verify(tt.secret, strconv.FormatInt(tt.timestamp, 10), tt.signature, tt.body, 5*time.Minute)
+			if !errors.Is(gotErr, tt.wantErr) {
+				t.Fatalf("Verify() error mismatch. Want %v; got %v", tt.wantErr, gotErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrNotFound = fmt.Errorf("webhook: data not found")
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusSucceeded = "succeeded"
+	DeliveryStatusDead      = "dead"
+)
+
+// Subscription is a merchant endpoint that receives the events. An empty
+// EventTypes means the subscription receives every event.
+type Subscription struct {
+	ID         int            `db:"id"`
+	URL        string         `db:"url"`
+	Secret     string         `db:"secret"`
+	EventTypes pq.StringArray `db:"event_types"`
+	Active     bool           `db:"active"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+// Wants reports whether the subscription is interested in the event type
+func (s *Subscription) Wants(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delivery is 1 event to be delivered to 1 subscription
+type Delivery struct {
+	ID             int64     `db:"id"`
+	SubscriptionID int       `db:"subscription_id"`
+	EventID        int64     `db:"event_id"`
+	EventType      string    `db:"event_type"`
+	Body           []byte    `db:"body"`
+	Status         string    `db:"status"`
+	Attempts       int       `db:"attempts"`
+	NextAttemptAt  time.Time `db:"next_attempt_at"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// Attempt is the record of a single HTTP call made for a delivery
+type Attempt struct {
+	ID         int64          `db:"id"`
+	DeliveryID int64          `db:"delivery_id"`
+	StatusCode int            `db:"status_code"`
+	Error      sql.NullString `db:"error"`
+	DurationMs int            `db:"duration_ms"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+// Manager is the interface responsible for managing the webhook
+// subscriptions and inspecting their deliveries
+type Manager interface {
+	// CreateSubscription registers a new subscription. When secret is
+	// empty, a random one is generated.
+	CreateSubscription(url, secret string, eventTypes []string) (*Subscription, error)
+
+	// ListSubscriptions lists every subscription
+	ListSubscriptions() ([]Subscription, error)
+
+	// GetSubscription gets the subscription with this ID
+	GetSubscription(id int) (*Subscription, error)
+
+	// DeleteSubscription removes the subscription along with its deliveries
+	DeleteSubscription(id int) error
+
+	// ListDeliveries lists the latest deliveries of a subscription
+	ListDeliveries(subscriptionID int, limit int) ([]Delivery, error)
+
+	// ListAttempts lists every attempt made for a delivery of the subscription
+	ListAttempts(subscriptionID int, deliveryID int64) ([]Attempt, error)
+
+	// ReplayDelivery puts the delivery of the subscription back to the
+	// pending queue, regardless of its current status
+	ReplayDelivery(subscriptionID int, deliveryID int64) error
+}
+
+type pgManager struct {
+	db *sqlx.DB
+}
+
+func NewManager(db *sqlx.DB) Manager {
+	return &pgManager{
+		db: db,
+	}
+}
+
+// CreateSubscription implements Manager.
+func (m *pgManager) CreateSubscription(url, secret string, eventTypes []string) (*Subscription, error) {
+	if secret == "" {
+		var err error
+		secret, err = generateSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	q := `
+        INSERT INTO webhook_subscriptions
+            (url, secret, event_types)
+        VALUES
+            ($1, $2, $3)
+        RETURNING
+            id, url, secret, event_types, active, created_at
+    `
+
+	sub := new(Subscription)
+	err := m.db.Get(sub, q, url, secret, pq.StringArray(eventTypes))
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// ListSubscriptions implements Manager.
+func (m *pgManager) ListSubscriptions() ([]Subscription, error) {
+	q := `
+        SELECT
+            id, url, secret, event_types, active, created_at
+        FROM webhook_subscriptions
+        ORDER BY id
+    `
+
+	subs := []Subscription{}
+	err := m.db.Select(&subs, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// GetSubscription implements Manager.
+func (m *pgManager) GetSubscription(id int) (*Subscription, error) {
+	q := `
+        SELECT
+            id, url, secret, event_types, active, created_at
+        FROM webhook_subscriptions
+        WHERE id = $1
+    `
+
+	sub := new(Subscription)
+	err := m.db.Get(sub, q, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(ErrNotFound, err)
+		}
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// DeleteSubscription implements Manager.
+func (m *pgManager) DeleteSubscription(id int) error {
+	res, err := m.db.Exec(`DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return mustAffectRow(res)
+}
+
+// ListDeliveries implements Manager.
+func (m *pgManager) ListDeliveries(subscriptionID int, limit int) ([]Delivery, error) {
+	q := `
+        SELECT
+            id, subscription_id, event_id, event_type, body,
+            status, attempts, next_attempt_at, created_at
+        FROM webhook_deliveries
+        WHERE subscription_id = $1
+        ORDER BY id DESC
+        LIMIT $2
+    `
+
+	deliveries := []Delivery{}
+	err := m.db.Select(&deliveries, q, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ListAttempts implements Manager.
+func (m *pgManager) ListAttempts(subscriptionID int, deliveryID int64) ([]Attempt, error) {
+	q := `
+        SELECT
+            a.id, a.delivery_id, a.status_code, a.error, a.duration_ms, a.created_at
+        FROM webhook_delivery_attempts a
+        JOIN webhook_deliveries d ON d.id = a.delivery_id
+        WHERE a.delivery_id = $1 AND d.subscription_id = $2
+        ORDER BY a.id
+    `
+
+	attempts := []Attempt{}
+	err := m.db.Select(&attempts, q, deliveryID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// ReplayDelivery implements Manager.
+func (m *pgManager) ReplayDelivery(subscriptionID int, deliveryID int64) error {
+	// the attempt counter is reset so the replay gets a fresh retry
+	// budget, the previous attempts are still kept in the attempt log
+	q := `
+        UPDATE webhook_deliveries
+        SET
+            status = $3,
+            attempts = 0,
+            next_attempt_at = CURRENT_TIMESTAMP
+        WHERE
+            id = $1 AND subscription_id = $2
+    `
+
+	res, err := m.db.Exec(q, deliveryID, subscriptionID, DeliveryStatusPending)
+	if err != nil {
+		return err
+	}
+
+	return mustAffectRow(res)
+}
+
+func mustAffectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,303 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/yeyee2901/test/config"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 50
+	defaultMaxAttempts  = 8
+	defaultBackoffBase  = 5 * time.Second
+	defaultBackoffMax   = time.Hour
+	defaultTimeout      = 10 * time.Second
+
+	// leaseMargin is added to the time the HTTP calls of a batch may take
+	leaseMargin = 30 * time.Second
+)
+
+// Worker sends the pending deliveries to the subscribers. A failed
+// delivery is retried with exponential backoff, and is moved to the dead
+// state once it ran out of attempts. Dead deliveries can be replayed
+// through the Manager.
+//
+// The deliveries are claimed and committed before they are sent, and
+// their outcome is recorded afterwards, so a slow receiver holds no lock.
+type Worker struct {
+	db           *sqlx.DB
+	client       *http.Client
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+}
+
+func NewWorker(cfg *config.Config, db *sqlx.DB) *Worker {
+	w := &Worker{
+		db:           db,
+		client:       &http.Client{Timeout: defaultTimeout},
+		pollInterval: defaultPollInterval,
+		batchSize:    defaultBatchSize,
+		maxAttempts:  defaultMaxAttempts,
+		backoffBase:  defaultBackoffBase,
+		backoffMax:   defaultBackoffMax,
+	}
+
+	if cfg.Webhook.PollIntervalMs > 0 {
+		w.pollInterval = time.Duration(cfg.Webhook.PollIntervalMs) * time.Millisecond
+	}
+	if cfg.Webhook.BatchSize > 0 {
+		w.batchSize = cfg.Webhook.BatchSize
+	}
+	if cfg.Webhook.MaxAttempts > 0 {
+		w.maxAttempts = cfg.Webhook.MaxAttempts
+	}
+	if cfg.Webhook.BackoffBaseSeconds > 0 {
+		w.backoffBase = time.Duration(cfg.Webhook.BackoffBaseSeconds) * time.Second
+	}
+	if cfg.Webhook.BackoffMaxSeconds > 0 {
+		w.backoffMax = time.Duration(cfg.Webhook.BackoffMaxSeconds) * time.Second
+	}
+	if cfg.Webhook.TimeoutSeconds > 0 {
+		w.client.Timeout = time.Duration(cfg.Webhook.TimeoutSeconds) * time.Second
+	}
+
+	return w
+}
+
+// Run polls the pending deliveries until the context is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.RunOnce(ctx); err != nil {
+			slog.Error("webhook worker cycle failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pendingDelivery is a due delivery joined with its subscription
+type pendingDelivery struct {
+	Delivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
+
+// RunOnce sends 1 batch of due deliveries and returns how many were
+// attempted. The rows are claimed with SKIP LOCKED and leased, so multiple
+// replicas can run the worker at the same time and no row lock is held
+// during the HTTP calls.
+func (w *Worker) RunOnce(ctx context.Context) (int, error) {
+	deliveries, err := w.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range deliveries {
+		d := &deliveries[i]
+
+		start := time.Now()
+		statusCode, sendErr := w.send(ctx, d.URL, d.Secret, &d.Delivery)
+		duration := time.Since(start)
+
+		err = w.record(ctx, d, statusCode, sendErr, duration)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// lease is how long the claimed deliveries are left to the worker, they
+// are due again when it did not record them by then, e.g. on a crash
+func (w *Worker) lease() time.Duration {
+	return time.Duration(w.batchSize)*w.client.Timeout + leaseMargin
+}
+
+// claim takes 1 batch of due deliveries, counting their attempt and
+// pushing their next attempt past the lease, and commits
+func (w *Worker) claim(ctx context.Context) ([]pendingDelivery, error) {
+	tx, err := w.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q := `
+        SELECT
+            d.id, d.subscription_id, d.event_id, d.event_type, d.body,
+            d.status, d.attempts, d.next_attempt_at, d.created_at,
+            s.url, s.secret
+        FROM webhook_deliveries d
+        JOIN webhook_subscriptions s ON s.id = d.subscription_id
+        WHERE d.status = $1 AND d.next_attempt_at <= CURRENT_TIMESTAMP AND s.active
+        ORDER BY d.next_attempt_at
+        LIMIT $2
+        FOR UPDATE OF d SKIP LOCKED
+    `
+	deliveries := []pendingDelivery{}
+	err = tx.Select(&deliveries, q, DeliveryStatusPending, w.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	ids := make([]int64, len(deliveries))
+	for i := range deliveries {
+		ids[i] = deliveries[i].ID
+		deliveries[i].Attempts++
+	}
+
+	qClaim := `
+        UPDATE webhook_deliveries
+        SET
+            attempts = attempts + 1,
+            next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+        WHERE
+            id = ANY($1)
+    `
+	_, err = tx.Exec(qClaim, pq.Array(ids), w.lease().Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// record stores the attempt and the outcome of a claimed delivery. A
+// delivery replayed or claimed again since is left as is.
+func (w *Worker) record(ctx context.Context, d *pendingDelivery, statusCode int, sendErr error, duration time.Duration) error {
+	var errMsg *string
+	if sendErr != nil {
+		msg := sendErr.Error()
+		errMsg = &msg
+	}
+
+	status, delay := w.outcome(d.Attempts, sendErr)
+	if sendErr != nil {
+		slog.Warn("webhook delivery failed",
+			"delivery_id", d.ID,
+			"subscription_id", d.SubscriptionID,
+			"attempts", d.Attempts,
+			"status", status,
+			"error", sendErr,
+		)
+	}
+
+	tx, err := w.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qAttempt := `
+        INSERT INTO webhook_delivery_attempts
+            (delivery_id, status_code, error, duration_ms)
+        VALUES
+            ($1, $2, $3, $4)
+    `
+	_, err = tx.Exec(qAttempt, d.ID, statusCode, errMsg, duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	qUpdate := `
+        UPDATE webhook_deliveries
+        SET
+            status = $2,
+            next_attempt_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+        WHERE
+            id = $1 AND status = $4 AND attempts = $5
+    `
+	_, err = tx.Exec(qUpdate, d.ID, status, delay.Seconds(), DeliveryStatusPending, d.Attempts)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// outcome returns the status of a delivery after its attempt, and the
+// delay before its next attempt. The delays are added to the clock of the
+// database, next_attempt_at holds no time zone.
+func (w *Worker) outcome(attempts int, sendErr error) (string, time.Duration) {
+	if sendErr == nil {
+		return DeliveryStatusSucceeded, 0
+	}
+	if attempts >= w.maxAttempts {
+		return DeliveryStatusDead, 0
+	}
+
+	return DeliveryStatusPending, backoff(attempts, w.backoffBase, w.backoffMax)
+}
+
+// send makes the signed HTTP call for the delivery. It returns the
+// response status code (0 when no response was received) and a non-nil
+// error when the delivery should be retried.
+func (w *Worker) send(ctx context.Context, url, secret string, d *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDeliveryID, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderEventType, d.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, ts, d.Body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook: receiver responded with %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the next attempt, doubling from base
+// on every attempt and capped at max
+func backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	return min(delay, max)
+}
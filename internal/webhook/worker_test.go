@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWorker_send(t *testing.T) {
+	secret := "whsec_test"
+
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{
+			name:       "test_success",
+			statusCode: http.StatusNoContent,
+			wantErr:    false,
+		},
+		{
+			name:       "test_receiver_down",
+			statusCode: http.StatusInternalServerError,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var verifyErr error
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				verifyErr = Verify(secret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Minute)
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer srv.Close()
+
+			w := &Worker{client: srv.Client()}
+			code, gotErr := w.send(context.Background(), srv.URL, secret, &Delivery{
+				ID:        1,
+				EventType: "BalanceCredited",
+				Body:      []byte(`{"id":1}`),
+			})
+
+			if verifyErr != nil {
+				t.Fatal("receiver failed to verify the signature:", verifyErr)
+			}
+			if code != tt.statusCode {
+				t.Fatal("status code mismatch. Want", tt.statusCode, "; got", code)
+			}
+			if (gotErr != nil) != tt.wantErr {
+				t.Fatalf("send() error mismatch. wantErr %v; got %v", tt.wantErr, gotErr)
+			}
+		})
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	base := 5 * time.Second
+	max := time.Minute
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 5 * time.Second},
+		{attempts: 2, want: 10 * time.Second},
+		{attempts: 3, want: 20 * time.Second},
+		{attempts: 4, want: 40 * time.Second},
+		{attempts: 5, want: time.Minute},
+		{attempts: 30, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.attempts, base, max); got != tt.want {
+			t.Errorf("backoff(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWorker_outcome(t *testing.T) {
+	w := &Worker{maxAttempts: 3, backoffBase: time.Second, backoffMax: time.Minute}
+	sendErr := errors.New("receiver down")
+
+	tests := []struct {
+		name       string
+		attempts   int
+		sendErr    error
+		wantStatus string
+		wantDelay  time.Duration
+	}{
+		{"test_success", 1, nil, DeliveryStatusSucceeded, 0},
+		{"test_retry", 2, sendErr, DeliveryStatusPending, 2 * time.Second},
+		{"test_out_of_attempts", 3, sendErr, DeliveryStatusDead, 0},
+		{"test_attempt_after_a_crash", 4, sendErr, DeliveryStatusDead, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, delay := w.outcome(tt.attempts, tt.sendErr)
+			if status != tt.wantStatus || delay != tt.wantDelay {
+				t.Errorf("outcome() = %s, %v, want %s, %v", status, delay, tt.wantStatus, tt.wantDelay)
+			}
+		})
+	}
+}
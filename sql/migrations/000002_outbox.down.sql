@@ -0,0 +1,3 @@
+DROP INDEX idx_outbox_events_undelivered;
+
+DROP TABLE outbox_events;
@@ -0,0 +1,6 @@
+DROP INDEX idx_webhook_delivery_attempts_delivery_id;
+DROP INDEX idx_webhook_deliveries_pending;
+
+DROP TABLE webhook_delivery_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_subscriptions;
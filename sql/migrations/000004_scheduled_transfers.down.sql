@@ -0,0 +1,6 @@
+DROP INDEX idx_scheduled_transfer_runs_scheduled_transfer_id;
+DROP INDEX idx_scheduled_transfers_source_user_id;
+DROP INDEX idx_scheduled_transfers_next_run_at;
+
+DROP TABLE scheduled_transfer_runs;
+DROP TABLE scheduled_transfers;
@@ -0,0 +1,33 @@
+CREATE TABLE scheduled_transfers (
+    id SERIAL PRIMARY KEY,
+    source_user_id INTEGER NOT NULL REFERENCES users(id),
+    target_user_id INTEGER REFERENCES users(id),
+    type VARCHAR(10) NOT NULL CHECK (type IN ('transfer', 'debit')),
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount > 0),
+    schedule VARCHAR(100) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    max_retries INTEGER NOT NULL DEFAULT 0,
+    retry_interval_seconds INTEGER NOT NULL DEFAULT 0,
+    retry_count INTEGER NOT NULL DEFAULT 0,
+    occurrence_at TIMESTAMP NOT NULL,
+    next_run_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((type = 'transfer') = (target_user_id IS NOT NULL))
+);
+
+CREATE INDEX idx_scheduled_transfers_next_run_at ON scheduled_transfers(next_run_at) WHERE active;
+CREATE INDEX idx_scheduled_transfers_source_user_id ON scheduled_transfers(source_user_id);
+
+CREATE TABLE scheduled_transfer_runs (
+    id BIGSERIAL PRIMARY KEY,
+    scheduled_transfer_id INTEGER NOT NULL REFERENCES scheduled_transfers(id) ON DELETE CASCADE,
+    occurrence_at TIMESTAMP NOT NULL,
+    attempt INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('running', 'succeeded', 'failed')),
+    error TEXT,
+    debit_trx_id INTEGER REFERENCES transactions(id),
+    credit_trx_id INTEGER REFERENCES transactions(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_scheduled_transfer_runs_scheduled_transfer_id ON scheduled_transfer_runs(scheduled_transfer_id);
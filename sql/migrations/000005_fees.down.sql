@@ -0,0 +1,8 @@
+DELETE FROM transactions WHERE user_id = (SELECT id FROM users WHERE username = 'fee_house');
+DELETE FROM users WHERE username = 'fee_house';
+
+DROP INDEX idx_transactions_parent_id;
+
+ALTER TABLE transactions DROP COLUMN parent_id;
+
+ALTER TABLE users DROP COLUMN tier;
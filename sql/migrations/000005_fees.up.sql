@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN tier VARCHAR(20) NOT NULL DEFAULT 'standard';
+
+ALTER TABLE transactions ADD COLUMN parent_id INTEGER REFERENCES transactions(id);
+
+CREATE INDEX idx_transactions_parent_id ON transactions(parent_id);
+
+-- the house account collecting the fees
+INSERT INTO users (username, balance, tier) VALUES ('fee_house', 0, 'house');
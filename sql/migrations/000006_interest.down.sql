@@ -0,0 +1,4 @@
+DROP INDEX idx_interest_accruals_unpaid;
+
+DROP TABLE interest_accrual_runs;
+DROP TABLE interest_accruals;
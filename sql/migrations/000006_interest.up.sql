@@ -0,0 +1,21 @@
+CREATE TABLE interest_accruals (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    accrual_date DATE NOT NULL,
+    balance DECIMAL(15, 2) NOT NULL,
+    annual_rate DECIMAL(7, 4) NOT NULL,
+    amount DECIMAL(20, 8) NOT NULL,
+    paid_at TIMESTAMP,
+    payout_trx_id INTEGER REFERENCES transactions(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, accrual_date)
+);
+
+CREATE INDEX idx_interest_accruals_unpaid ON interest_accruals(user_id, accrual_date) WHERE paid_at IS NULL;
+
+-- the days that were fully accrued
+CREATE TABLE interest_accrual_runs (
+    accrual_date DATE PRIMARY KEY,
+    accounts INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+DROP INDEX idx_reconciliation_adjustments_user_id;
+
+DROP TABLE reconciliation_adjustments;
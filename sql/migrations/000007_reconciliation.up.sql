@@ -0,0 +1,10 @@
+CREATE TABLE reconciliation_adjustments (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    transaction_id INTEGER NOT NULL REFERENCES transactions(id),
+    drift DECIMAL(15, 2) NOT NULL,
+    approved_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_reconciliation_adjustments_user_id ON reconciliation_adjustments(user_id);
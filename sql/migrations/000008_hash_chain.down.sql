@@ -0,0 +1,5 @@
+DROP TABLE ledger_checkpoints;
+
+ALTER TABLE users DROP COLUMN chain_head;
+ALTER TABLE transactions DROP COLUMN hash;
+ALTER TABLE transactions DROP COLUMN prev_hash;
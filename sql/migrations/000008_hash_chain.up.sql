@@ -0,0 +1,40 @@
+-- every transaction hashes its contents together with the hash of the
+-- previous transaction of the same account, the account keeps the head
+ALTER TABLE transactions ADD COLUMN prev_hash CHAR(64);
+ALTER TABLE transactions ADD COLUMN hash CHAR(64);
+ALTER TABLE users ADD COLUMN chain_head CHAR(64);
+
+-- chain the existing transactions, the format must match account.ChainHash
+DO $$
+DECLARE
+    trx RECORD;
+    head CHAR(64);
+BEGIN
+    FOR trx IN SELECT * FROM transactions ORDER BY user_id, id LOOP
+        SELECT chain_head INTO head FROM users WHERE id = trx.user_id;
+
+        UPDATE transactions
+        SET
+            prev_hash = head,
+            hash = encode(sha256(convert_to(
+                'v1|' || trx.id || '|' || trx.user_id || '|' || trx.amount || '|' || trx.type || '|' ||
+                COALESCE(trx.parent_id::TEXT, '') || '|' ||
+                (EXTRACT(EPOCH FROM trx.created_at) * 1000000)::BIGINT || '|' ||
+                COALESCE(head, ''),
+                'UTF8'
+            )), 'hex')
+        WHERE id = trx.id
+        RETURNING hash INTO head;
+
+        UPDATE users SET chain_head = head WHERE id = trx.user_id;
+    END LOOP;
+END $$;
+
+CREATE TABLE ledger_checkpoints (
+    id SERIAL PRIMARY KEY,
+    root CHAR(64) NOT NULL,
+    heads JSONB NOT NULL,
+    public_key VARCHAR(64) NOT NULL,
+    signature VARCHAR(128) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
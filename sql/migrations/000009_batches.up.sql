@@ -0,0 +1,24 @@
+CREATE TABLE batches (
+    id UUID PRIMARY KEY,
+    mode VARCHAR(20) NOT NULL CHECK (mode IN ('atomic', 'best_effort')),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('processing', 'succeeded', 'partially_succeeded', 'failed')),
+    item_count INTEGER NOT NULL,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE TABLE batch_items (
+    batch_id UUID NOT NULL REFERENCES batches(id) ON DELETE CASCADE,
+    item_index INTEGER NOT NULL,
+    type VARCHAR(10) NOT NULL CHECK (type IN ('credit', 'debit', 'transfer')),
+    username VARCHAR(50) NOT NULL,
+    target_username VARCHAR(50),
+    amount DECIMAL(15, 2) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('succeeded', 'failed')),
+    debit_trx_id INTEGER REFERENCES transactions(id),
+    credit_trx_id INTEGER REFERENCES transactions(id),
+    error TEXT,
+    PRIMARY KEY (batch_id, item_index)
+);
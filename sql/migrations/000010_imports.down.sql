@@ -0,0 +1,3 @@
+DROP TABLE import_rows;
+DROP INDEX idx_import_jobs_pending;
+DROP TABLE import_jobs;
@@ -0,0 +1,30 @@
+CREATE TABLE import_jobs (
+    id UUID PRIMARY KEY,
+    filename VARCHAR(255) NOT NULL,
+    -- a file is only imported once
+    checksum CHAR(64) UNIQUE NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'running', 'completed')),
+    total_rows INTEGER NOT NULL,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_import_jobs_pending ON import_jobs(created_at) WHERE status <> 'completed';
+
+CREATE TABLE import_rows (
+    job_id UUID NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    username VARCHAR(50) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL,
+    type VARCHAR(10) NOT NULL CHECK (type IN ('credit', 'debit')),
+    -- a reference is only applied once, across all the imports
+    reference VARCHAR(100) UNIQUE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    transaction_id INTEGER REFERENCES transactions(id),
+    error TEXT,
+    PRIMARY KEY (job_id, line)
+);
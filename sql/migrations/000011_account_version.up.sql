@@ -0,0 +1,2 @@
+-- incremented by every mutation of the account, for optimistic concurrency
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+-- keep the balances of the buckets
+UPDATE users u
+SET
+    balance = u.balance + b.balance
+FROM (
+    SELECT user_id, SUM(balance) AS balance FROM balance_buckets GROUP BY user_id
+) b
+WHERE
+    u.id = b.user_id;
+
+ALTER TABLE transactions DROP COLUMN bucket;
+DROP TABLE balance_buckets;
+ALTER TABLE users DROP COLUMN bucket_count;
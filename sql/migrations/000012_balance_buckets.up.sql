@@ -0,0 +1,17 @@
+-- a hot account spreads its balance over bucket_count buckets, its
+-- balance is users.balance plus the balances of all its buckets
+ALTER TABLE users ADD COLUMN bucket_count INTEGER NOT NULL DEFAULT 0;
+
+-- the credits of a bucket are chained on the bucket, so they only lock
+-- the bucket row
+CREATE TABLE balance_buckets (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    bucket INTEGER NOT NULL,
+    balance DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    version BIGINT NOT NULL DEFAULT 0,
+    chain_head CHAR(64),
+    PRIMARY KEY (user_id, bucket)
+);
+
+-- NULL for the transactions chained on the account
+ALTER TABLE transactions ADD COLUMN bucket INTEGER;
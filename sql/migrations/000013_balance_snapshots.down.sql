@@ -0,0 +1,4 @@
+DROP INDEX idx_transactions_user_created_at;
+
+DROP TABLE balance_snapshot_runs;
+DROP TABLE balance_snapshots;
@@ -0,0 +1,17 @@
+-- the balance of every account at the end of the day, from the ledger
+CREATE TABLE balance_snapshots (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    snapshot_date DATE NOT NULL,
+    balance DECIMAL(15, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, snapshot_date)
+);
+
+-- the days that were fully snapshotted
+CREATE TABLE balance_snapshot_runs (
+    snapshot_date DATE PRIMARY KEY,
+    accounts INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_transactions_user_created_at ON transactions(user_id, created_at);
@@ -0,0 +1,8 @@
+-- the balance of the account right after the transaction, NULL when the
+-- account had buckets, their credits do not lock the account
+ALTER TABLE transactions ADD COLUMN balance_after DECIMAL(15, 2);
+
+-- the past balances are queried by UTC instant, the timestamps are stored
+-- in UTC whatever the time zone of the session
+ALTER TABLE transactions ALTER COLUMN created_at SET DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC');
+ALTER TABLE users ALTER COLUMN created_at SET DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC');
@@ -0,0 +1,5 @@
+DROP INDEX idx_outbox_events_undelivered;
+CREATE INDEX idx_outbox_events_undelivered ON outbox_events(id) WHERE delivered_at IS NULL;
+
+ALTER TABLE outbox_events DROP COLUMN dead_at;
+ALTER TABLE outbox_events DROP COLUMN next_attempt_at;
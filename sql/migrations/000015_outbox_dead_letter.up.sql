@@ -0,0 +1,8 @@
+-- a failed event is retried at next_attempt_at, and is dead once it ran
+-- out of attempts. The relay also pushes next_attempt_at forward while it
+-- delivers the claimed events.
+ALTER TABLE outbox_events ADD COLUMN next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE outbox_events ADD COLUMN dead_at TIMESTAMP;
+
+DROP INDEX idx_outbox_events_undelivered;
+CREATE INDEX idx_outbox_events_undelivered ON outbox_events(aggregate_id, id) WHERE delivered_at IS NULL AND dead_at IS NULL;
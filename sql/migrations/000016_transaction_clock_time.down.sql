@@ -0,0 +1 @@
+ALTER TABLE transactions ALTER COLUMN created_at SET DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC');
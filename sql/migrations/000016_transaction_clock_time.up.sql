@@ -0,0 +1,4 @@
+-- the transactions are stamped when they are written, i.e. after the
+-- account was locked, instead of when their database transaction began.
+-- The creation time then follows the running balance of the account.
+ALTER TABLE transactions ALTER COLUMN created_at SET DEFAULT (clock_timestamp() AT TIME ZONE 'UTC');